@@ -1,18 +1,33 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"rest-api/internal/admin"
+	"rest-api/internal/apikey"
+	"rest-api/internal/apperror"
+	"rest-api/internal/auth"
 	"rest-api/internal/config"
+	"rest-api/internal/handlers"
+	"rest-api/internal/lockout"
+	"rest-api/internal/mfa"
+	"rest-api/internal/migrations"
+	"rest-api/internal/session"
+	"rest-api/internal/storage"
 	"rest-api/internal/user"
 	"rest-api/pkg/db"
 	"rest-api/pkg/logging"
+	"rest-api/pkg/migrate"
+	"rest-api/pkg/notify"
+	"rest-api/pkg/password"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func main() {
@@ -20,29 +35,353 @@ func main() {
 	logger := logging.GetLogger()
 	logger.Info("create router")
 	router := httprouter.New()
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apperror.Write(w, r, apperror.ErrNotFound)
+	})
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apperror.Write(w, r, apperror.New(http.StatusMethodNotAllowed, "method_not_allowed", ""))
+	})
 
 	cfg := config.GetConfig()
 
 	logger.Info("register user handler")
 
-	mongo, err := db.NewMongoClient(cfg.Mongo.URI, logger)
-	if err != nil {
-		logger.Errorf("Can not connect to mongoDB %v", err)
-	}
-	NewMongoStorage := user.NewMongoStorage(mongo, cfg.Mongo.Database, cfg.Mongo.Collection, logger)
-	userHandler := user.NewHandler(logger, NewMongoStorage)
+	stores := newStorage(cfg)
+	userStorage, sessionStorage := stores.users, stores.sessions
+	startPurger(userStorage, cfg)
+	passwords := newPasswords(cfg)
+	tokens := newTokens(cfg)
+	authMiddleware := auth.NewMiddleware(logger, tokens, userStorage, stores.apiKeys, newRoles(userStorage, cfg), cfg.RBAC.MFARoles)
+	mailer := auth.NewMailer(logger, userStorage, sessionStorage, auth.MailOptions{
+		Notifier:  newNotifier(cfg),
+		ResetTTL:  cfg.Auth.ResetTTL,
+		ResetURL:  cfg.Auth.ResetURL,
+		VerifyTTL: cfg.Auth.VerifyTTL,
+		VerifyURL: cfg.Auth.VerifyURL,
+		Workers:   cfg.Notify.Workers,
+		Queue:     cfg.Notify.Queue,
+	})
+	throttle := newLockout(stores.attempts, cfg)
+	userHandler := user.NewHandler(logger, userStorage, passwords, mailer, authMiddleware)
 	userHandler.Register(router)
 
 	logger.Info("register admin handler")
-	adminHandler := admin.NewHandler(logger, NewMongoStorage)
+	adminHandler := admin.NewHandler(logger, userStorage, sessionStorage, passwords, throttle, mailer, authMiddleware)
 	adminHandler.Register(router)
 
+	logger.Info("register auth handler")
+	authHandler, err := auth.NewHandler(logger, userStorage, sessionStorage, passwords, tokens, mailer, newMFA(stores.mfa, cfg), throttle, authMiddleware)
+	if err != nil {
+		logger.Fatalf("Can not create auth handler %v", err)
+	}
+	authHandler.Register(router)
+
+	logger.Info("register API key handler")
+	apiKeyHandler := apikey.NewHandler(logger, stores.apiKeys, authMiddleware)
+	apiKeyHandler.Register(router)
+
 	router.Handler("GET", "/metrics", promhttp.Handler())
 
 	start(router, cfg)
 
 }
 
+// stores are the storages of one driver.
+type stores struct {
+	users    storage.Storage
+	sessions storage.TokenStorage
+	mfa      storage.MFAStorage
+	attempts storage.AttemptStorage
+	apiKeys  storage.APIKeyStorage
+}
+
+func newStorage(cfg *config.Config) stores {
+
+	logger := logging.GetLogger()
+	logger.Infof("use %q storage driver", cfg.Storage.Driver)
+
+	switch cfg.Storage.Driver {
+	case "memory":
+		return stores{
+			users:    user.NewMemoryStorage(logger),
+			sessions: session.NewMemoryStorage(logger),
+			mfa:      mfa.NewMemoryStorage(logger),
+			attempts: lockout.NewMemoryStorage(logger),
+			apiKeys:  apikey.NewMemoryStorage(logger),
+		}
+	case "mongo", "":
+		mongo, err := db.NewMongoClient(cfg.Mongo.URI, logger)
+		if err != nil {
+			logger.Fatalf("Can not connect to mongoDB %v", err)
+		}
+		runMigrations(mongo.Database(cfg.Mongo.Database), cfg)
+		return stores{
+			users:    user.NewMongoStorage(mongo, cfg.Mongo.Database, cfg.Mongo.Collection, logger),
+			sessions: session.NewMongoStorage(mongo, cfg.Mongo.Database, cfg.Mongo.SessionsCollection, cfg.Mongo.OneTimeCollection, logger),
+			mfa:      mfa.NewMongoStorage(mongo, cfg.Mongo.Database, cfg.Mongo.MFACollection, logger),
+			attempts: lockout.NewMongoStorage(mongo, cfg.Mongo.Database, cfg.Mongo.AttemptsCollection, logger),
+			apiKeys:  apikey.NewMongoStorage(mongo, cfg.Mongo.Database, cfg.Mongo.APIKeysCollection, logger),
+		}
+	case "sql":
+		sqlDB, err := db.NewSQLClient(cfg.SQL.Driver, cfg.SQL.DSN, logger)
+		if err != nil {
+			logger.Fatalf("Can not connect to %s database %v", cfg.SQL.Driver, err)
+		}
+		sqlStorage, err := user.NewSQLStorage(context.Background(), sqlDB, cfg.SQL.Table, logger)
+		if err != nil {
+			logger.Fatalf("Can not initialize %s storage %v", cfg.SQL.Driver, err)
+		}
+		sessionStorage, err := session.NewSQLStorage(context.Background(), sqlDB, cfg.SQL.SessionsTable, cfg.SQL.OneTimeTable, logger)
+		if err != nil {
+			logger.Fatalf("Can not initialize %s session storage %v", cfg.SQL.Driver, err)
+		}
+		mfaStorage, err := mfa.NewSQLStorage(context.Background(), sqlDB, cfg.SQL.MFATable, logger)
+		if err != nil {
+			logger.Fatalf("Can not initialize %s MFA storage %v", cfg.SQL.Driver, err)
+		}
+		attemptStorage, err := lockout.NewSQLStorage(context.Background(), sqlDB, cfg.SQL.AttemptsTable, logger)
+		if err != nil {
+			logger.Fatalf("Can not initialize %s lockout storage %v", cfg.SQL.Driver, err)
+		}
+		keyStorage, err := apikey.NewSQLStorage(context.Background(), sqlDB, cfg.SQL.APIKeysTable, logger)
+		if err != nil {
+			logger.Fatalf("Can not initialize %s API key storage %v", cfg.SQL.Driver, err)
+		}
+		return stores{
+			users:    sqlStorage,
+			sessions: sessionStorage,
+			mfa:      mfaStorage,
+			attempts: attemptStorage,
+			apiKeys:  keyStorage,
+		}
+	default:
+		logger.Fatalf("unknown storage driver %q", cfg.Storage.Driver)
+		return stores{}
+	}
+}
+
+func newPasswords(cfg *config.Config) handlers.Passwords {
+
+	logger := logging.GetLogger()
+	hasher, err := password.NewHasher(password.Params{
+		Algorithm:         cfg.Password.Algorithm,
+		BcryptCost:        cfg.Password.BcryptCost,
+		Argon2Memory:      cfg.Password.Argon2.MemoryKiB,
+		Argon2Iterations:  cfg.Password.Argon2.Iterations,
+		Argon2Parallelism: cfg.Password.Argon2.Parallelism,
+	})
+	if err != nil {
+		logger.Fatalf("Invalid password hashing configuration %v", err)
+	}
+
+	policy := &password.Policy{
+		MinLength:     cfg.Password.Policy.MinLength,
+		MaxLength:     cfg.Password.Policy.MaxLength,
+		RequireUpper:  cfg.Password.Policy.RequireUpper,
+		RequireLower:  cfg.Password.Policy.RequireLower,
+		RequireDigit:  cfg.Password.Policy.RequireDigit,
+		RequireSymbol: cfg.Password.Policy.RequireSymbol,
+		MaxBytes:      hasher.MaxBytes(),
+	}
+	policy.Block(cfg.Password.Policy.Blocklist...)
+	if file := cfg.Password.Policy.BlocklistFile; file != "" {
+		if err := policy.BlockFile(file); err != nil {
+			logger.Fatalf("Can not read password blocklist %v", err)
+		}
+	}
+
+	logger.Infof("hash passwords with %s", cfg.Password.Algorithm)
+	return handlers.Passwords{Hasher: hasher, Policy: policy}
+}
+
+func newTokens(cfg *config.Config) *auth.Tokens {
+
+	logger := logging.GetLogger()
+	tokens, err := auth.NewTokens(auth.TokenOptions{
+		Algorithm:      cfg.Auth.Algorithm,
+		Secret:         cfg.Auth.Secret,
+		PrivateKeyFile: cfg.Auth.PrivateKeyFile,
+		KeyID:          cfg.Auth.KeyID,
+		Issuer:         cfg.Auth.Issuer,
+		Audience:       cfg.Auth.Audience,
+		TTL:            cfg.Auth.AccessTTL,
+		RefreshTTL:     cfg.Auth.RefreshTTL,
+	})
+	if err != nil {
+		logger.Fatalf("Invalid auth configuration %v", err)
+	}
+
+	logger.Infof("sign access tokens with %s", cfg.Auth.Algorithm)
+	return tokens
+}
+
+func newNotifier(cfg *config.Config) notify.Notifier {
+
+	logger := logging.GetLogger()
+	logger.Infof("use %q notify driver", cfg.Notify.Driver)
+
+	switch cfg.Notify.Driver {
+	case "smtp":
+		notifier, err := notify.NewSMTP(notify.SMTPOptions{
+			Host:     cfg.Notify.SMTP.Host,
+			Port:     cfg.Notify.SMTP.Port,
+			Username: cfg.Notify.SMTP.Username,
+			Password: cfg.Notify.SMTP.Password,
+			From:     cfg.Notify.SMTP.From,
+		})
+		if err != nil {
+			logger.Fatalf("Invalid smtp configuration %v", err)
+		}
+		return notifier
+	case "file", "":
+		notifier, err := notify.NewFile(cfg.Notify.File)
+		if err != nil {
+			logger.Fatalf("Can not open notify file %v", err)
+		}
+		return notifier
+	default:
+		logger.Fatalf("unknown notify driver %q", cfg.Notify.Driver)
+		return nil
+	}
+}
+
+func newMFA(mfaStorage storage.MFAStorage, cfg *config.Config) *auth.MFA {
+
+	logger := logging.GetLogger()
+	factors, err := auth.NewMFA(auth.MFAOptions{
+		Storage:      mfaStorage,
+		Issuer:       cfg.MFA.Issuer,
+		Key:          cfg.MFA.Key,
+		ChallengeTTL: cfg.MFA.ChallengeTTL,
+	})
+	if err != nil {
+		logger.Fatalf("Invalid MFA configuration %v", err)
+	}
+	if !factors.Sealed() {
+		logger.Warn("mfa.key is not set, TOTP secrets are stored unencrypted")
+	}
+	return factors
+}
+
+func newLockout(attemptStorage storage.AttemptStorage, cfg *config.Config) *auth.Lockout {
+
+	logger := logging.GetLogger()
+	if cfg.Lockout.MaxFailures <= 0 {
+		logger.Warn("account lockout is disabled")
+	} else {
+		logger.Infof("lock accounts after %d and client IPs after %d failed logins for %s",
+			cfg.Lockout.MaxFailures, cfg.Lockout.IPFailures, cfg.Lockout.LockDuration)
+	}
+	return auth.NewLockout(logger, auth.LockoutOptions{
+		Storage:           attemptStorage,
+		MaxFailures:       cfg.Lockout.MaxFailures,
+		IPFailures:        cfg.Lockout.IPFailures,
+		MailRequests:      cfg.Lockout.MailRequests,
+		LockDuration:      cfg.Lockout.LockDuration,
+		BaseDelay:         cfg.Lockout.BaseDelay,
+		MaxDelay:          cfg.Lockout.MaxDelay,
+		Window:            cfg.Lockout.Window,
+		TrustForwardedFor: cfg.Lockout.TrustForwardedFor,
+	})
+}
+
+func newRoles(userStorage storage.Storage, cfg *config.Config) map[string][]auth.Permission {
+
+	logger := logging.GetLogger()
+	roles := auth.DefaultRoles
+	if len(cfg.RBAC.Roles) > 0 {
+		var err error
+		if roles, err = auth.ParseRoles(cfg.RBAC.Roles); err != nil {
+			logger.Fatalf("Invalid role configuration %v", err)
+		}
+	}
+	if err := auth.CheckMFARoles(roles, cfg.RBAC.MFARoles); err != nil {
+		logger.Fatalf("Invalid role configuration: %v", err)
+	}
+
+	if login := cfg.RBAC.BootstrapAdmin; login != "" {
+		ctx := context.Background()
+		account, err := userStorage.FindByLogin(ctx, login)
+		if err == nil {
+			err = userStorage.GrantRole(ctx, account.ID, auth.AdminRole)
+		}
+		// The bootstrap admin is trusted without verifying its email.
+		if err == nil && account.Status == storage.StatusPendingVerification {
+			err = userStorage.SetStatus(ctx, account.ID, account.Status, storage.StatusActive)
+		}
+		if err != nil {
+			logger.Warnf("Can not grant the admin role to %q %v", login, err)
+		}
+	}
+
+	return roles
+}
+
+// runMigrations applies or checks the mongo migrations before the storage is
+// used. Replicas starting together wait for whichever one holds the lock.
+func runMigrations(database *mongo.Database, cfg *config.Config) {
+
+	logger := logging.GetLogger()
+	migrator, err := migrate.NewMigrator(database, migrations.All(cfg.Mongo.Collection, cfg.Mongo.SessionsCollection, cfg.Mongo.OneTimeCollection, cfg.Mongo.AttemptsCollection, cfg.Mongo.APIKeysCollection), cfg.Migrations.LockTTL, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	ctx := context.Background()
+	switch cfg.Migrations.OnStartup {
+	case "apply", "":
+		deadline := time.Now().Add(cfg.Migrations.LockTTL)
+		for {
+			n, err := migrator.Up(ctx, 0)
+			if errors.Is(err, migrate.ErrLocked) && time.Now().Before(deadline) {
+				logger.Info("migrations are running elsewhere, waiting")
+				time.Sleep(2 * time.Second)
+				continue
+			}
+			if err != nil {
+				logger.Fatalf("Can not apply migrations %v", err)
+			}
+			logger.Infof("%d migrations applied", n)
+			return
+		}
+	case "check":
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			logger.Fatalf("Can not read migration status %v", err)
+		}
+		if len(pending) > 0 {
+			logger.Fatalf("%d migrations are pending, run `migrate up` first", len(pending))
+		}
+	case "ignore":
+		logger.Warn("migration check is disabled")
+	default:
+		logger.Fatalf("unknown migrations.on_startup %q", cfg.Migrations.OnStartup)
+	}
+}
+
+func startPurger(userStorage storage.Storage, cfg *config.Config) {
+
+	logger := logging.GetLogger()
+	if cfg.SoftDelete.PurgeAfterDays <= 0 {
+		logger.Info("purging of deleted users is disabled")
+		return
+	}
+
+	retention := time.Duration(cfg.SoftDelete.PurgeAfterDays) * 24 * time.Hour
+	logger.Infof("purge users deleted more than %d days ago every %s", cfg.SoftDelete.PurgeAfterDays, cfg.SoftDelete.PurgeInterval)
+
+	go func() {
+		ticker := time.NewTicker(cfg.SoftDelete.PurgeInterval)
+		defer ticker.Stop()
+		for {
+			if _, err := userStorage.Purge(context.Background(), time.Now().Add(-retention)); err != nil {
+				logger.Errorf("Can not purge deleted users %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
 func start(router *httprouter.Router, cfg *config.Config) {
 
 	logger := logging.GetLogger()
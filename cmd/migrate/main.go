@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"rest-api/internal/config"
+	"rest-api/internal/migrations"
+	"rest-api/pkg/db"
+	"rest-api/pkg/logging"
+	"rest-api/pkg/migrate"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: migrate <command> [argument]
+
+commands:
+  status          list migrations and whether they are applied
+  up [version]    apply pending migrations, up to version when given
+  down [steps]    revert the last applied migrations, 1 by default`
+
+func main() {
+
+	logger := logging.GetLogger()
+
+	if len(os.Args) < 2 || len(os.Args) > 3 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var arg int64
+	if len(os.Args) == 3 {
+		n, err := strconv.ParseInt(os.Args[2], 10, 64)
+		if err != nil || n < 1 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		arg = n
+	}
+
+	cfg := config.GetConfig()
+
+	client, err := db.NewMongoClient(cfg.Mongo.URI, logger)
+	if err != nil {
+		logger.Fatalf("Can not connect to mongoDB %v", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	migrator, err := migrate.NewMigrator(client.Database(cfg.Mongo.Database), migrations.All(cfg.Mongo.Collection, cfg.Mongo.SessionsCollection, cfg.Mongo.OneTimeCollection, cfg.Mongo.AttemptsCollection, cfg.Mongo.APIKeysCollection), cfg.Migrations.LockTTL, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			logger.Fatalf("Can not read migration status %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tAPPLIED\tDESCRIPTION")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Migration.Version, applied, s.Migration.Description)
+		}
+		w.Flush()
+	case "up":
+		n, err := migrator.Up(ctx, arg)
+		if err != nil {
+			logger.Fatalf("Migration failed after %d applied %v", n, err)
+		}
+		logger.Infof("%d migrations applied", n)
+	case "down":
+		if arg == 0 {
+			arg = 1
+		}
+		n, err := migrator.Down(ctx, int(arg))
+		if err != nil {
+			logger.Fatalf("Migration failed after %d reverted %v", n, err)
+		}
+		logger.Infof("%d migrations reverted", n)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
@@ -4,56 +4,105 @@ import (
 	"encoding/json"
 	"net/http"
 	"rest-api/internal/apperror"
+	"rest-api/internal/auth"
 	"rest-api/internal/handlers"
 	"rest-api/internal/storage"
+	"rest-api/pkg/validate"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var _ handlers.Handler = &handler{}
 
 const (
-	usersURL = "/admins"
-	userURL  = "/admins/:uuid"
+	usersURL    = "/admins"
+	userURL     = "/admins/:uuid"
+	rolesURL    = "/admins/:uuid/roles"
+	roleURL     = "/admins/:uuid/roles/:role"
+	sessionsURL = "/admins/:uuid/sessions"
+	suspendURL  = "/admins/:uuid/suspend"
+	activateURL = "/admins/:uuid/reactivate"
+	disableURL  = "/admins/:uuid/disable"
+	verifyURL   = "/admins/:uuid/verify"
+	lockoutURL  = "/admins/:uuid/lockout"
+
+	// trashID selects GET /admins/trash on userURL, httprouter can not
+	// register the static segment next to the wildcard.
+	trashID = "trash"
 )
 
 type handler struct {
-	logger  *logrus.Logger
-	storage storage.Storage
+	logger    *logrus.Logger
+	storage   storage.Storage
+	sessions  storage.TokenStorage
+	passwords handlers.Passwords
+	lockout   *auth.Lockout
+	verifier  handlers.Verifier
+	auth      *auth.Middleware
 }
 
-func NewHandler(logger *logrus.Logger, storage storage.Storage) handlers.Handler {
+func NewHandler(logger *logrus.Logger, storage storage.Storage, sessions storage.TokenStorage, passwords handlers.Passwords, lockout *auth.Lockout, verifier handlers.Verifier, auth *auth.Middleware) handlers.Handler {
 	return &handler{
-		logger:  logger,
-		storage: storage,
+		logger:    logger,
+		storage:   storage,
+		sessions:  sessions,
+		passwords: passwords,
+		lockout:   lockout,
+		verifier:  verifier,
+		auth:      auth,
 	}
 }
 
+// Register requires the admins:manage permission on every admin route.
 func (h *handler) Register(router *httprouter.Router) {
-	router.HandlerFunc(http.MethodGet, usersURL, apperror.ErrorMiddleware(h.GetList))
-	router.HandlerFunc(http.MethodPost, usersURL, apperror.ErrorMiddleware(h.CreateUser))
-	router.HandlerFunc(http.MethodGet, userURL, apperror.ErrorMiddleware(h.GetUserByUUID))
-	router.HandlerFunc(http.MethodPut, userURL, apperror.ErrorMiddleware(h.UpdateUser))
-	router.HandlerFunc(http.MethodPatch, userURL, apperror.ErrorMiddleware(h.PartiallyUpdateUser))
-	router.HandlerFunc(http.MethodDelete, userURL, apperror.ErrorMiddleware(h.DeleteUser))
+	manage := auth.Require(auth.AdminsManage)
+	router.HandlerFunc(http.MethodGet, usersURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.GetList)))
+	router.HandlerFunc(http.MethodPost, usersURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.CreateUser)))
+	router.HandlerFunc(http.MethodGet, userURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.GetUserByUUID)))
+	router.HandlerFunc(http.MethodPut, userURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.UpdateUser)))
+	router.HandlerFunc(http.MethodPatch, userURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.PartiallyUpdateUser)))
+	router.HandlerFunc(http.MethodDelete, userURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.DeleteUser)))
+	router.HandlerFunc(http.MethodPost, rolesURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.GrantRole)))
+	router.HandlerFunc(http.MethodDelete, roleURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.RevokeRole)))
+	router.HandlerFunc(http.MethodDelete, sessionsURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.RevokeSessions)))
+	router.HandlerFunc(http.MethodPost, suspendURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.Suspend)))
+	router.HandlerFunc(http.MethodPost, activateURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.Reactivate)))
+	router.HandlerFunc(http.MethodPost, disableURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.Disable)))
+	router.HandlerFunc(http.MethodPost, verifyURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.Verify)))
+	router.HandlerFunc(http.MethodDelete, lockoutURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.Unlock)))
 }
 
 func (h *handler) GetList(w http.ResponseWriter, r *http.Request) error {
 	h.logger.Info("GetList called for users")
+	return h.list(w, r, false)
+}
+
+// GetTrash lists soft deleted users, accepting the same query as GetList.
+func (h *handler) GetTrash(w http.ResponseWriter, r *http.Request) error {
+	h.logger.Info("GetTrash called for users")
+	return h.list(w, r, true)
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request, deleted bool) error {
+	opts, err := handlers.ParseListOptions(r.URL.Query())
+	if err != nil {
+		h.logger.Errorf("Invalid list options: %v", err)
+		return apperror.FromStorage(err)
+	}
+	opts.Deleted = deleted
 
-	users, err := h.storage.GetAll(r.Context())
+	page, err := h.storage.GetAll(r.Context(), opts)
 	if err != nil {
 		h.logger.Errorf("Failed to get users: %v", err)
-		return apperror.ErrInternalServer
+		return apperror.FromStorage(err)
 	}
 
+	handlers.SetLinkHeader(w, r, opts, page)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	if err := json.NewEncoder(w).Encode(users); err != nil {
+	if err := json.NewEncoder(w).Encode(handlers.NewListResponse(opts, page)); err != nil {
 		h.logger.Errorf("Failed to encode users list: %v", err)
 		return apperror.ErrInternalServer
 	}
@@ -62,20 +111,25 @@ func (h *handler) GetList(w http.ResponseWriter, r *http.Request) error {
 }
 
 func (h *handler) CreateUser(w http.ResponseWriter, r *http.Request) error {
-	var admin storage.Client
+	var req handlers.CreateUserRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
+		return err
+	}
 
-	if err := json.NewDecoder(r.Body).Decode(&admin); err != nil {
-		return apperror.NewError("invalid request body")
+	if err := h.passwords.Validate(req, validate.Create); err != nil {
+		return err
 	}
 
-	if admin.Email == "" || admin.Username == "" || admin.PasswordHash == "" {
-		return apperror.ErrMissingRequiredFields
+	admin, err := h.passwords.Client(req.Input())
+	if err != nil {
+		h.logger.Errorf("Rejected password for new user: %v", err)
+		return apperror.FromStorage(err)
 	}
 
 	id, err := h.storage.Create(r.Context(), admin)
 	if err != nil {
 		h.logger.Errorf("Failed to create user: %v", err)
-		return apperror.ErrInternalServer
+		return apperror.FromStorage(err)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -93,20 +147,27 @@ func (h *handler) GetUserByUUID(w http.ResponseWriter, r *http.Request) error {
 
 	params := httprouter.ParamsFromContext(r.Context())
 	id := params.ByName("uuid")
+	if id == trashID {
+		return h.GetTrash(w, r)
+	}
 
 	user, err := h.storage.FindOne(r.Context(), id)
 	if err != nil {
 		h.logger.Errorf("Failed to find user by ID %s: %v", id, err)
-		if err == mongo.ErrNoDocuments {
-			return apperror.ErrNotFound
-		}
-		return apperror.ErrInternalServer
+		return apperror.FromStorage(err)
+	}
+
+	etag := handlers.ETag(user.Version)
+	w.Header().Set("ETag", etag)
+	if handlers.IfNoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	if err := json.NewEncoder(w).Encode(user); err != nil {
+	if err := json.NewEncoder(w).Encode(handlers.NewUserResponse(user)); err != nil {
 		h.logger.Errorf("Failed to encode user: %v", err)
 		return apperror.ErrInternalServer
 	}
@@ -122,23 +183,45 @@ func (h *handler) UpdateUser(w http.ResponseWriter, r *http.Request) error {
 
 	h.logger.Infof("Attempting to update user with id: %s", id)
 
-	var admin storage.Client
-	if err := json.NewDecoder(r.Body).Decode(&admin); err != nil {
+	var req handlers.ReplaceUserRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
 		h.logger.Errorf("Invalid request body: %v", err)
-		return apperror.NewError("invalid request body")
+		return err
+	}
+	if err := h.passwords.Validate(req, validate.Replace); err != nil {
+		return err
+	}
+
+	admin, err := h.passwords.Client(req.Input())
+	if err != nil {
+		h.logger.Errorf("Rejected password for admin %s: %v", id, err)
+		return apperror.FromStorage(err)
+	}
+
+	version, err := handlers.IfMatchVersion(r)
+	if err != nil {
+		h.logger.Errorf("Precondition failed for admin %s: %v", id, err)
+		return apperror.FromStorage(err)
 	}
 
 	admin.ID = id
+	admin.Version = version
 	h.logger.Infof("User data to be updated: %+v", admin)
 
-	err := h.storage.Update(r.Context(), admin)
+	previous, err := h.storage.FindOne(r.Context(), id)
+	if err == nil {
+		admin, err = h.storage.Update(r.Context(), admin)
+	}
 	if err != nil {
 		h.logger.Errorf("Failed to update admin %s: %v", id, err)
-		return apperror.ErrInternalServer
+		return apperror.FromStorage(err)
 	}
+	handlers.Reverify(h.verifier, previous, admin)
 
 	h.logger.Info("User updated successfully")
-	w.WriteHeader(http.StatusNoContent)
+	if err := handlers.WriteUpdated(w, r, admin); err != nil {
+		h.logger.Errorf("Failed to encode user: %v", err)
+	}
 
 	return nil
 }
@@ -151,23 +234,29 @@ func (h *handler) PartiallyUpdateUser(w http.ResponseWriter, r *http.Request) er
 
 	h.logger.Infof("Attempting to partially update user with id: %s", id)
 
-	var admin storage.Client
-	if err := json.NewDecoder(r.Body).Decode(&admin); err != nil {
-		h.logger.Errorf("Invalid request body: %v", err)
-		return apperror.NewError("invalid request body")
+	patch, err := handlers.DecodePatch(w, r)
+	if err != nil {
+		h.logger.Errorf("Invalid patch: %v", err)
+		return err
 	}
 
-	admin.ID = id
-	h.logger.Infof("User data to be partially updated: %+v", admin)
+	version, err := handlers.IfMatchVersion(r)
+	if err != nil {
+		h.logger.Errorf("Precondition failed for admin %s: %v", id, err)
+		return apperror.FromStorage(err)
+	}
 
-	err := h.storage.PartiallyUpdate(r.Context(), admin)
+	admin, previous, err := h.passwords.PatchUser(r.Context(), h.storage, id, version, patch)
 	if err != nil {
 		h.logger.Errorf("Failed to partially update admin %s: %v", id, err)
-		return apperror.ErrInternalServer
+		return apperror.FromStorage(err)
 	}
+	handlers.Reverify(h.verifier, previous, admin)
 
 	h.logger.Info("User partially updated successfully")
-	w.WriteHeader(http.StatusNoContent)
+	if err := handlers.WriteUpdated(w, r, admin); err != nil {
+		h.logger.Errorf("Failed to encode user: %v", err)
+	}
 
 	return nil
 }
@@ -176,18 +265,17 @@ func (h *handler) DeleteUser(w http.ResponseWriter, r *http.Request) error {
 	id := httprouter.ParamsFromContext(r.Context()).ByName("uuid")
 	h.logger.Infof("Attempting to delete user with id: %s", id)
 
-	objectID, err := primitive.ObjectIDFromHex(id)
+	version, err := handlers.IfMatchVersion(r)
 	if err != nil {
-		h.logger.Errorf("Invalid UUID format: %v", err)
-		return apperror.ErrInvalidUuidFormat
+		h.logger.Errorf("Precondition failed for user %s: %v", id, err)
+		return apperror.FromStorage(err)
 	}
 
-	idString := objectID.Hex()
-
-	err = h.storage.Delete(r.Context(), idString)
+	principal, _ := auth.PrincipalFrom(r.Context())
+	err = h.storage.Delete(r.Context(), id, storage.DeleteOptions{Version: version, DeletedBy: principal.ID})
 	if err != nil {
 		h.logger.Errorf("Failed to delete user %s: %v", id, err)
-		return apperror.ErrInternalServer
+		return apperror.FromStorage(err)
 	}
 
 	h.logger.Infof("User %s deleted successfully", id)
@@ -195,3 +283,142 @@ func (h *handler) DeleteUser(w http.ResponseWriter, r *http.Request) error {
 
 	return nil
 }
+
+func (h *handler) GrantRole(w http.ResponseWriter, r *http.Request) error {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("uuid")
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := handlers.Decode(w, r, &req); err != nil {
+		return err
+	}
+	if !h.auth.KnownRole(req.Role) {
+		return apperror.ErrUnknownRole
+	}
+
+	if err := h.storage.GrantRole(r.Context(), id, req.Role); err != nil {
+		h.logger.Errorf("Failed to grant role %s to user %s: %v", req.Role, id, err)
+		return apperror.FromStorage(err)
+	}
+
+	principal, _ := auth.PrincipalFrom(r.Context())
+	h.logger.Infof("User %s granted role %s to user %s", principal.ID, req.Role, id)
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (h *handler) RevokeRole(w http.ResponseWriter, r *http.Request) error {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, role := params.ByName("uuid"), params.ByName("role")
+
+	if err := h.storage.RevokeRole(r.Context(), id, role); err != nil {
+		h.logger.Errorf("Failed to revoke role %s from user %s: %v", role, id, err)
+		return apperror.FromStorage(err)
+	}
+
+	principal, _ := auth.PrincipalFrom(r.Context())
+	h.logger.Infof("User %s revoked role %s from user %s", principal.ID, role, id)
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// RevokeSessions ends every session of a user. Access tokens already issued
+// stay valid until they expire.
+func (h *handler) RevokeSessions(w http.ResponseWriter, r *http.Request) error {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("uuid")
+
+	user, err := h.storage.FindOne(r.Context(), id)
+	if err != nil {
+		h.logger.Errorf("Failed to find user by ID %s: %v", id, err)
+		return apperror.FromStorage(err)
+	}
+
+	revoked, err := h.sessions.RevokeUser(r.Context(), user.ID)
+	if err != nil {
+		h.logger.Errorf("Failed to revoke sessions of user %s: %v", id, err)
+		return apperror.ErrInternalServer
+	}
+
+	principal, _ := auth.PrincipalFrom(r.Context())
+	h.logger.Infof("User %s revoked %d refresh tokens of user %s", principal.ID, revoked, id)
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// Suspend blocks an active account until it is reactivated and ends its
+// sessions.
+func (h *handler) Suspend(w http.ResponseWriter, r *http.Request) error {
+	return h.setStatus(w, r, storage.StatusActive, storage.StatusSuspended)
+}
+
+func (h *handler) Reactivate(w http.ResponseWriter, r *http.Request) error {
+	return h.setStatus(w, r, storage.StatusSuspended, storage.StatusActive)
+}
+
+// Verify activates a pending account without a verification token, for
+// owners who can not receive mail at the address on record.
+func (h *handler) Verify(w http.ResponseWriter, r *http.Request) error {
+	return h.setStatus(w, r, storage.StatusPendingVerification, storage.StatusActive)
+}
+
+// Disable permanently blocks an account in any status and ends its sessions.
+func (h *handler) Disable(w http.ResponseWriter, r *http.Request) error {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("uuid")
+
+	user, err := h.storage.FindOne(r.Context(), id)
+	if err != nil {
+		h.logger.Errorf("Failed to find user by ID %s: %v", id, err)
+		return apperror.FromStorage(err)
+	}
+	return h.setStatus(w, r, user.Status, storage.StatusDisabled)
+}
+
+// Unlock lifts the lockout of an account after repeated failed logins and
+// forgets its failures. Lockouts of client IPs expire on their own.
+func (h *handler) Unlock(w http.ResponseWriter, r *http.Request) error {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("uuid")
+
+	user, err := h.storage.FindOne(r.Context(), id)
+	if err != nil {
+		h.logger.Errorf("Failed to find user by ID %s: %v", id, err)
+		return apperror.FromStorage(err)
+	}
+
+	principal, _ := auth.PrincipalFrom(r.Context())
+	unlocked, err := h.lockout.Unlock(r.Context(), user.ID, principal.ID)
+	if err != nil {
+		h.logger.Errorf("Failed to unlock user %s: %v", id, err)
+		return apperror.ErrInternalServer
+	}
+	if !unlocked {
+		h.logger.Infof("User %s had no failed logins to unlock", id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (h *handler) setStatus(w http.ResponseWriter, r *http.Request, from, to string) error {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("uuid")
+
+	if err := h.storage.SetStatus(r.Context(), id, from, to); err != nil {
+		h.logger.Errorf("Failed to change status of user %s to %s: %v", id, to, err)
+		return apperror.FromStorage(err)
+	}
+	if to != storage.StatusActive {
+		if _, err := h.sessions.RevokeUser(r.Context(), id); err != nil {
+			h.logger.Errorf("Failed to revoke sessions of user %s: %v", id, err)
+			return apperror.ErrInternalServer
+		}
+	}
+
+	principal, _ := auth.PrincipalFrom(r.Context())
+	h.logger.Infof("User %s set status of user %s to %s", principal.ID, id, to)
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
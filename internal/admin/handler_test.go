@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"rest-api/internal/auth"
+	"rest-api/internal/handlers"
+	"rest-api/internal/lockout"
+	"rest-api/internal/session"
+	"rest-api/internal/storage"
+	"rest-api/internal/user"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+type noVerifier struct{}
+
+func (noVerifier) SendVerification(client storage.Client) {}
+
+func TestRBAC(t *testing.T) {
+	const missing = "0123456789abcdef01234567"
+
+	tests := []struct {
+		name      string
+		roles     []string
+		mfaRoles  []string
+		mfa       bool
+		anonymous bool
+		method    string
+		path      string
+		want      int
+	}{
+		{name: "anonymous", anonymous: true, method: http.MethodGet, path: "/admins", want: http.StatusUnauthorized},
+		{name: "no role", method: http.MethodGet, path: "/admins", want: http.StatusForbidden},
+		{name: "support lists", roles: []string{"support"}, method: http.MethodGet, path: "/admins", want: http.StatusForbidden},
+		{name: "support lists the trash", roles: []string{"support"}, method: http.MethodGet, path: "/admins/trash", want: http.StatusForbidden},
+		{name: "support grants a role", roles: []string{"support"}, method: http.MethodPost, path: "/admins/{id}/roles", want: http.StatusForbidden},
+		{name: "support suspends", roles: []string{"support"}, method: http.MethodPost, path: "/admins/{id}/suspend", want: http.StatusForbidden},
+		{name: "support revokes sessions", roles: []string{"support"}, method: http.MethodDelete, path: "/admins/{id}/sessions", want: http.StatusForbidden},
+		{name: "admin lists", roles: []string{auth.AdminRole}, method: http.MethodGet, path: "/admins", want: http.StatusOK},
+		{name: "admin lists the trash", roles: []string{auth.AdminRole}, method: http.MethodGet, path: "/admins/trash", want: http.StatusOK},
+		{name: "admin revokes sessions of a missing user", roles: []string{auth.AdminRole}, method: http.MethodDelete, path: "/admins/" + missing + "/sessions", want: http.StatusNotFound},
+		{name: "admin without MFA", roles: []string{auth.AdminRole}, mfaRoles: []string{auth.AdminRole}, method: http.MethodGet, path: "/admins", want: http.StatusForbidden},
+		{name: "admin with MFA", roles: []string{auth.AdminRole}, mfaRoles: []string{auth.AdminRole}, mfa: true, method: http.MethodGet, path: "/admins", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			tokens, err := auth.NewTokens(auth.TokenOptions{
+				Algorithm:  "HS256",
+				Secret:     "0123456789abcdef0123456789abcdef",
+				TTL:        time.Minute,
+				RefreshTTL: time.Hour,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			users := user.NewMemoryStorage(logger)
+			middleware := auth.NewMiddleware(logger, tokens, users, nil, auth.DefaultRoles, tt.mfaRoles)
+			throttle := auth.NewLockout(logger, auth.LockoutOptions{Storage: lockout.NewMemoryStorage(logger)})
+			router := httprouter.New()
+			NewHandler(logger, users, session.NewMemoryStorage(logger), handlers.Passwords{}, throttle, noVerifier{}, middleware).Register(router)
+
+			ctx := context.Background()
+			id, err := users.Create(ctx, storage.Client{Email: "bob@example.com", Username: "bob", Roles: tt.roles})
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			caller, err := users.FindOne(ctx, id)
+			if err != nil {
+				t.Fatalf("FindOne() error = %v", err)
+			}
+
+			r := httptest.NewRequest(tt.method, strings.ReplaceAll(tt.path, "{id}", id), strings.NewReader(`{"role":"support"}`))
+			if !tt.anonymous {
+				access, _, err := tokens.Issue(caller, tt.mfa)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r.Header.Set("Authorization", "Bearer "+access)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("%s %s: status = %d, want %d: %s", tt.method, tt.path, w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
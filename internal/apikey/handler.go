@@ -0,0 +1,136 @@
+package apikey
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"rest-api/internal/apperror"
+	"rest-api/internal/auth"
+	"rest-api/internal/handlers"
+	"rest-api/internal/storage"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+var _ handlers.Handler = &handler{}
+
+const (
+	keysURL = "/api-keys"
+	keyURL  = "/api-keys/:id"
+)
+
+type handler struct {
+	logger  *logrus.Logger
+	storage storage.APIKeyStorage
+	auth    *auth.Middleware
+}
+
+func NewHandler(logger *logrus.Logger, storage storage.APIKeyStorage, auth *auth.Middleware) handlers.Handler {
+	return &handler{
+		logger:  logger,
+		storage: storage,
+		auth:    auth,
+	}
+}
+
+// Register requires the admins:manage permission on every API key route.
+func (h *handler) Register(router *httprouter.Router) {
+	manage := auth.Require(auth.AdminsManage)
+	router.HandlerFunc(http.MethodGet, keysURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.GetList)))
+	router.HandlerFunc(http.MethodPost, keysURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.CreateKey)))
+	router.HandlerFunc(http.MethodDelete, keyURL, apperror.ErrorMiddleware(h.auth.AppHandler(manage, h.RevokeKey)))
+}
+
+type createRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type createResponse struct {
+	storage.APIKey
+	// Key is only ever shown in this response.
+	Key string `json:"key"`
+}
+
+func (h *handler) GetList(w http.ResponseWriter, r *http.Request) error {
+	h.logger.Info("GetList called for API keys")
+
+	keys, err := h.storage.ListAPIKeys(r.Context())
+	if err != nil {
+		return apperror.ErrInternalServer
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		h.logger.Errorf("Failed to encode response: %v", err)
+		return apperror.ErrInternalServer
+	}
+	return nil
+}
+
+// CreateKey issues a key with the given scopes. Callers may only grant
+// permissions they hold themselves, and only with a session: a key created
+// by another key could outlive it.
+func (h *handler) CreateKey(w http.ResponseWriter, r *http.Request) error {
+	principal, _ := auth.PrincipalFrom(r.Context())
+	if principal.APIKey != "" {
+		h.logger.Warnf("API key %s may not create API keys", principal.APIKey)
+		return apperror.ErrForbidden
+	}
+
+	var req createRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
+		return err
+	}
+	if req.Name == "" || len(req.Scopes) == 0 {
+		return apperror.ErrMissingRequiredFields
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return apperror.ErrInvalidExpiry
+	}
+
+	for _, scope := range req.Scopes {
+		if !auth.KnownPermission(scope) {
+			return fmt.Errorf("%w: %s", apperror.ErrUnknownScope, scope)
+		}
+		if !principal.Can(auth.Permission(scope)) {
+			h.logger.Warnf("User %s may not grant %s to an API key", principal.ID, scope)
+			return apperror.ErrForbidden
+		}
+	}
+
+	key, record, err := auth.NewAPIKey(req.Name, req.Scopes, req.ExpiresAt, principal.ID)
+	if err != nil {
+		h.logger.Errorf("Failed to generate API key: %v", err)
+		return apperror.ErrInternalServer
+	}
+	if record.ID, err = h.storage.CreateAPIKey(r.Context(), record); err != nil {
+		return apperror.FromStorage(err)
+	}
+
+	h.logger.Infof("User %s created API key %s (%s) with scopes %v", principal.ID, record.ID, record.Prefix, record.Scopes)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(createResponse{APIKey: record, Key: key}); err != nil {
+		h.logger.Errorf("Failed to encode response: %v", err)
+		return apperror.ErrInternalServer
+	}
+	return nil
+}
+
+// RevokeKey stops a key from authenticating. It stays listed for audit.
+func (h *handler) RevokeKey(w http.ResponseWriter, r *http.Request) error {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+	if err := h.storage.RevokeAPIKey(r.Context(), id); err != nil {
+		return apperror.FromStorage(err)
+	}
+
+	principal, _ := auth.PrincipalFrom(r.Context())
+	h.logger.Infof("User %s revoked API key %s", principal.ID, id)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,97 @@
+package apikey
+
+import (
+	"context"
+	"rest-api/internal/storage"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStorage keeps API keys in process memory and mirrors the behaviour
+// of MongoStorage.
+type MemoryStorage struct {
+	mu     sync.RWMutex
+	keys   map[string]storage.APIKey
+	logger *logrus.Logger
+}
+
+func NewMemoryStorage(logger *logrus.Logger) *MemoryStorage {
+	logger.Info("Initializing API key MemoryStorage")
+	return &MemoryStorage{
+		keys:   make(map[string]storage.APIKey),
+		logger: logger,
+	}
+}
+
+func (s *MemoryStorage) CreateAPIKey(ctx context.Context, key storage.APIKey) (string, error) {
+	s.logger.Infof("Creating API key %s", key.Prefix)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.keys {
+		if k.Hash == key.Hash {
+			return "", storage.ErrDuplicateKey
+		}
+	}
+	key.ID = primitive.NewObjectID().Hex()
+	key.Scopes = slices.Clone(key.Scopes)
+	s.keys[key.ID] = key
+	return key.ID, nil
+}
+
+func (s *MemoryStorage) ListAPIKeys(ctx context.Context) ([]storage.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]storage.APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b storage.APIKey) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return keys, nil
+}
+
+func (s *MemoryStorage) FindAPIKey(ctx context.Context, hash string) (storage.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.keys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+	return storage.APIKey{}, storage.ErrNotFound
+}
+
+func (s *MemoryStorage) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.keys[id]; ok {
+		usedAt = usedAt.UTC()
+		key.LastUsedAt = &usedAt
+		s.keys[id] = key
+	}
+	return nil
+}
+
+func (s *MemoryStorage) RevokeAPIKey(ctx context.Context, id string) error {
+	s.logger.Infof("Revoking API key %s", id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok || key.RevokedAt != nil {
+		return storage.ErrNotFound
+	}
+	now := time.Now().UTC()
+	key.RevokedAt = &now
+	s.keys[id] = key
+	return nil
+}
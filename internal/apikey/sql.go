@@ -0,0 +1,156 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rest-api/internal/storage"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const keyColumns = "id, name, prefix, hash, scopes, created_by, created_at, expires_at, last_used_at, revoked_at"
+
+type SQLStorage struct {
+	db     *sql.DB
+	table  string
+	logger *logrus.Logger
+}
+
+func NewSQLStorage(ctx context.Context, db *sql.DB, table string, logger *logrus.Logger) (*SQLStorage, error) {
+	logger.Infof("Initializing API key SQLStorage for table: %s", table)
+
+	s := &SQLStorage{
+		db:     db,
+		table:  `"` + strings.ReplaceAll(table, `"`, `""`) + `"`,
+		logger: logger,
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id           TEXT PRIMARY KEY,
+		name         TEXT NOT NULL,
+		prefix       TEXT NOT NULL,
+		hash         TEXT NOT NULL UNIQUE,
+		scopes       TEXT NOT NULL DEFAULT '[]',
+		created_by   TEXT NOT NULL,
+		created_at   TIMESTAMP NOT NULL,
+		expires_at   TIMESTAMP NULL,
+		last_used_at TIMESTAMP NULL,
+		revoked_at   TIMESTAMP NULL
+	)`, s.table))
+	if err != nil {
+		logger.Errorf("Failed to bootstrap schema: %v", err)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLStorage) CreateAPIKey(ctx context.Context, key storage.APIKey) (string, error) {
+	s.logger.Infof("Creating API key %s", key.Prefix)
+
+	key.ID = primitive.NewObjectID().Hex()
+	scopes, _ := json.Marshal(key.Scopes)
+	_, err := s.db.ExecContext(ctx,
+		fmt.Sprintf(`INSERT INTO %s (id, name, prefix, hash, scopes, created_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, s.table),
+		key.ID, key.Name, key.Prefix, key.Hash, string(scopes), key.CreatedBy, key.CreatedAt.UTC(), nullTime(key.ExpiresAt),
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to create API key: %v", err)
+		return "", err
+	}
+	return key.ID, nil
+}
+
+func (s *SQLStorage) ListAPIKeys(ctx context.Context) ([]storage.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM %s ORDER BY created_at DESC`, keyColumns, s.table))
+	if err != nil {
+		s.logger.Errorf("Failed to list API keys: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []storage.APIKey{}
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			s.logger.Errorf("Failed to decode API key: %v", err)
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *SQLStorage) FindAPIKey(ctx context.Context, hash string) (storage.APIKey, error) {
+	row := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT %s FROM %s WHERE hash = ?`, keyColumns, s.table), hash)
+	key, err := scanKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, storage.ErrNotFound
+	}
+	if err != nil {
+		s.logger.Errorf("Failed to find API key: %v", err)
+	}
+	return key, err
+}
+
+func (s *SQLStorage) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET last_used_at = ? WHERE id = ?`, s.table), usedAt.UTC(), id)
+	if err != nil {
+		s.logger.Errorf("Failed to record use of API key %s: %v", id, err)
+	}
+	return err
+}
+
+func (s *SQLStorage) RevokeAPIKey(ctx context.Context, id string) error {
+	s.logger.Infof("Revoking API key %s", id)
+
+	result, err := s.db.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE %s SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, s.table),
+		time.Now().UTC(), id,
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to revoke API key %s: %v", id, err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func scanKey(row interface{ Scan(...any) error }) (storage.APIKey, error) {
+	var (
+		key                              storage.APIKey
+		scopes                           string
+		expiresAt, lastUsedAt, revokedAt sql.NullTime
+	)
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, &scopes, &key.CreatedBy, &key.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		return key, err
+	}
+	if err := json.Unmarshal([]byte(scopes), &key.Scopes); err != nil {
+		return key, err
+	}
+	key.ExpiresAt = timePtr(expiresAt)
+	key.LastUsedAt = timePtr(lastUsedAt)
+	key.RevokedAt = timePtr(revokedAt)
+	return key, nil
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
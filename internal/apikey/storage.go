@@ -0,0 +1,94 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"rest-api/internal/storage"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStorage keeps API keys in their own collection, the migrations add a
+// unique index on the hash.
+type MongoStorage struct {
+	collection *mongo.Collection
+	logger     *logrus.Logger
+}
+
+func NewMongoStorage(client *mongo.Client, dbName, collectionName string, logger *logrus.Logger) *MongoStorage {
+	logger.Infof("Initializing API key MongoStorage for database: %s, collection: %s", dbName, collectionName)
+	return &MongoStorage{
+		collection: client.Database(dbName).Collection(collectionName),
+		logger:     logger,
+	}
+}
+
+func (s *MongoStorage) CreateAPIKey(ctx context.Context, key storage.APIKey) (string, error) {
+	s.logger.Infof("Creating API key %s", key.Prefix)
+
+	key.ID = primitive.NewObjectID().Hex()
+	if _, err := s.collection.InsertOne(ctx, key); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return "", storage.ErrDuplicateKey
+		}
+		s.logger.Errorf("Failed to create API key: %v", err)
+		return "", err
+	}
+	return key.ID, nil
+}
+
+func (s *MongoStorage) ListAPIKeys(ctx context.Context) ([]storage.APIKey, error) {
+	cursor, err := s.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		s.logger.Errorf("Failed to list API keys: %v", err)
+		return nil, err
+	}
+	keys := []storage.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		s.logger.Errorf("Failed to decode API keys: %v", err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *MongoStorage) FindAPIKey(ctx context.Context, hash string) (storage.APIKey, error) {
+	var key storage.APIKey
+	err := s.collection.FindOne(ctx, bson.M{"hash": hash}).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return key, storage.ErrNotFound
+	}
+	if err != nil {
+		s.logger.Errorf("Failed to find API key: %v", err)
+	}
+	return key, err
+}
+
+func (s *MongoStorage) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := s.collection.UpdateByID(ctx, id, bson.M{"$set": bson.M{"lastUsedAt": usedAt.UTC()}})
+	if err != nil {
+		s.logger.Errorf("Failed to record use of API key %s: %v", id, err)
+	}
+	return err
+}
+
+func (s *MongoStorage) RevokeAPIKey(ctx context.Context, id string) error {
+	s.logger.Infof("Revoking API key %s", id)
+
+	result, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}},
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to revoke API key %s: %v", id, err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
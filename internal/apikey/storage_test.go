@@ -0,0 +1,83 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"reflect"
+	"rest-api/internal/storage"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+func backends(t *testing.T) map[string]storage.APIKeyStorage {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	sqlStorage, err := NewSQLStorage(context.Background(), db, "api_keys", logger)
+	if err != nil {
+		t.Fatalf("NewSQLStorage() error = %v", err)
+	}
+
+	return map[string]storage.APIKeyStorage{
+		"memory": NewMemoryStorage(logger),
+		"sql":    sqlStorage,
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			created := time.Now().UTC().Truncate(time.Second)
+			older, err := s.CreateAPIKey(ctx, storage.APIKey{Name: "old", Prefix: "rk_1", Hash: "h1", Scopes: []string{"users:read"}, CreatedBy: "u1", CreatedAt: created.Add(-time.Hour)})
+			if err != nil {
+				t.Fatalf("CreateAPIKey() error = %v", err)
+			}
+			newer, err := s.CreateAPIKey(ctx, storage.APIKey{Name: "new", Prefix: "rk_2", Hash: "h2", Scopes: []string{"users:read", "users:write"}, CreatedBy: "u1", CreatedAt: created})
+			if err != nil {
+				t.Fatalf("CreateAPIKey() error = %v", err)
+			}
+
+			key, err := s.FindAPIKey(ctx, "h2")
+			if err != nil || key.ID != newer || key.CreatedBy != "u1" || !reflect.DeepEqual(key.Scopes, []string{"users:read", "users:write"}) {
+				t.Errorf("FindAPIKey() = %+v, %v", key, err)
+			}
+			if _, err := s.FindAPIKey(ctx, "unknown"); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("FindAPIKey() of an unknown hash error = %v, want %v", err, storage.ErrNotFound)
+			}
+
+			if err := s.TouchAPIKey(ctx, newer, created); err != nil {
+				t.Fatalf("TouchAPIKey() error = %v", err)
+			}
+			if err := s.RevokeAPIKey(ctx, older); err != nil {
+				t.Fatalf("RevokeAPIKey() error = %v", err)
+			}
+			if err := s.RevokeAPIKey(ctx, older); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("second RevokeAPIKey() error = %v, want %v", err, storage.ErrNotFound)
+			}
+
+			keys, err := s.ListAPIKeys(ctx)
+			if err != nil || len(keys) != 2 {
+				t.Fatalf("ListAPIKeys() = %+v, %v, want 2 keys", keys, err)
+			}
+			if keys[0].ID != newer || keys[0].LastUsedAt == nil || keys[0].RevokedAt != nil {
+				t.Errorf("ListAPIKeys()[0] = %+v, want the used key first", keys[0])
+			}
+			if keys[1].ID != older || keys[1].RevokedAt == nil {
+				t.Errorf("ListAPIKeys()[1] = %+v, want the revoked key", keys[1])
+			}
+		})
+	}
+}
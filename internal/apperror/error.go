@@ -1,6 +1,13 @@
 package apperror
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"rest-api/internal/storage"
+	"rest-api/pkg/password"
+	"strings"
+)
 
 var (
 	ErrNotFound              = errors.New("resource not found")
@@ -8,8 +15,176 @@ var (
 	ErrInternalServer        = errors.New("internal server error")
 	ErrMissingRequiredFields = errors.New("missing required fields")
 	ErrInvalidUuidFormat     = errors.New("invalid UUID format")
+	ErrConflict              = errors.New("resource conflict")
+	ErrInvalidQuery          = errors.New("invalid query parameters")
+	ErrPreconditionFailed    = errors.New("precondition failed")
+	ErrInvalidPassword       = errors.New("invalid password")
+	ErrInvalidCredentials    = errors.New("invalid login or password")
+	ErrForbidden             = errors.New("forbidden")
+	ErrUnknownRole           = errors.New("unknown role")
+	ErrInvalidToken          = errors.New("invalid or expired token")
+	ErrInvalidTransition     = errors.New("invalid status transition")
+	ErrAccountInactive       = errors.New("account is not active")
+	ErrInvalidCode           = errors.New("invalid verification code")
+	ErrTooManyAttempts       = errors.New("too many failed attempts, try again later")
+	ErrUnknownScope          = errors.New("unknown scope")
+	ErrInvalidExpiry         = errors.New("expiry must be in the future")
+	ErrInvalidBody           = errors.New("invalid request body")
+	ErrUnsupportedFormat     = errors.New("unsupported format")
+	ErrValidation            = errors.New("request failed validation")
+	ErrBodyTooLarge          = errors.New("request body too large")
+	ErrUnsupportedMediaType  = errors.New("unsupported media type")
+	// ErrPatchConflict is a patch that does not apply to the current
+	// document, ErrPatchTestFailed one whose test operation failed.
+	ErrPatchConflict   = errors.New("patch does not apply")
+	ErrPatchTestFailed = errors.New("patch test failed")
 )
 
+// problems maps the sentinel errors to their status and machine-readable
+// code. The first match wins, so wrapped errors keep the code of the
+// sentinel they wrap.
+var problems = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrMissingRequiredFields, http.StatusBadRequest, "missing_required_fields"},
+	{ErrInvalidBody, http.StatusBadRequest, "invalid_body"},
+	{ErrInvalidUuidFormat, http.StatusBadRequest, "invalid_id"},
+	{ErrInvalidQuery, http.StatusBadRequest, "invalid_query"},
+	{ErrInvalidPassword, http.StatusBadRequest, "invalid_password"},
+	{ErrUnknownRole, http.StatusBadRequest, "unknown_role"},
+	{ErrInvalidToken, http.StatusBadRequest, "invalid_token"},
+	{ErrInvalidCode, http.StatusBadRequest, "invalid_code"},
+	{ErrUnknownScope, http.StatusBadRequest, "unknown_scope"},
+	{ErrInvalidExpiry, http.StatusBadRequest, "invalid_expiry"},
+	{ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials"},
+	{ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ErrAccountInactive, http.StatusForbidden, "account_inactive"},
+	{ErrNotFound, http.StatusNotFound, "not_found"},
+	{ErrUnsupportedFormat, http.StatusNotAcceptable, "unsupported_format"},
+	{ErrBodyTooLarge, http.StatusRequestEntityTooLarge, "body_too_large"},
+	{ErrUnsupportedMediaType, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+	{ErrConflict, http.StatusConflict, "conflict"},
+	{ErrInvalidTransition, http.StatusConflict, "invalid_transition"},
+	{ErrPatchConflict, http.StatusConflict, "patch_conflict"},
+	{ErrPatchTestFailed, http.StatusConflict, "patch_test_failed"},
+	{ErrPreconditionFailed, http.StatusPreconditionFailed, "precondition_failed"},
+	{ErrTooManyAttempts, http.StatusTooManyRequests, "too_many_attempts"},
+}
+
+// AppError is an error response, rendered by Write as an RFC 7807
+// application/problem+json document. Clients branch on Code, Detail is
+// meant for humans.
+type AppError struct {
+	Status int    `json:"status"`
+	Code   string `json:"code"`
+	Title  string `json:"title"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request that failed.
+	Instance string       `json:"instance,omitempty"`
+	Fields   []FieldError `json:"errors,omitempty"`
+	// Err is the error the response was built from, for errors.Is.
+	Err error `json:"-"`
+}
+
+// FieldError points at one invalid field of a request body and the rule
+// it breaks.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// New returns an error response with the given status and code.
+func New(status int, code, detail string) *AppError {
+	return &AppError{Status: status, Code: code, Title: http.StatusText(status), Detail: detail}
+}
+
+// Invalid returns a 422 response listing every invalid field.
+func Invalid(fields []FieldError) *AppError {
+	e := New(http.StatusUnprocessableEntity, "validation_failed", ErrValidation.Error())
+	e.Fields = fields
+	e.Err = ErrValidation
+	return e
+}
+
+// Conflict returns a 409 response naming the field whose value is already
+// taken.
+func Conflict(field string) *AppError {
+	detail := fmt.Sprintf("%s already exists", field)
+	e := New(http.StatusConflict, "conflict", detail)
+	e.Fields = []FieldError{{Field: field, Rule: "unique", Message: detail}}
+	e.Err = ErrConflict
+	return e
+}
+
+func (e *AppError) Error() string {
+	if e.Detail != "" {
+		return e.Detail
+	}
+	return e.Title
+}
+
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
+// From turns any error into an error response. Errors other than AppError
+// are looked up in the sentinel table, and unknown ones become a 500 that
+// does not leak their text.
+func From(err error) *AppError {
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	for _, p := range problems {
+		if errors.Is(err, p.err) {
+			e := New(p.status, p.code, err.Error())
+			e.Err = err
+			return e
+		}
+	}
+	e := New(http.StatusInternalServerError, "internal_error", "")
+	e.Err = err
+	return e
+}
+
+// NewError returns a 400 response for a malformed request.
 func NewError(text string) error {
-	return errors.New(text)
+	return New(http.StatusBadRequest, "bad_request", text)
+}
+
+// FromStorage translates storage and password policy errors into the
+// sentinel errors known to From.
+func FromStorage(err error) error {
+	var (
+		appErr   *AppError
+		conflict *storage.ConflictError
+		policy   *password.PolicyError
+	)
+	switch {
+	case errors.As(err, &appErr):
+		return appErr
+	case errors.As(err, &conflict) && conflict.Field != "":
+		return Conflict(conflict.Field)
+	case errors.As(err, &policy):
+		return fmt.Errorf("%w: requires %s", ErrInvalidPassword, strings.Join(policy.Violations, ", "))
+	case errors.Is(err, storage.ErrNotFound):
+		return ErrNotFound
+	case errors.Is(err, storage.ErrInvalidID):
+		return ErrInvalidUuidFormat
+	case errors.Is(err, storage.ErrDuplicateKey):
+		return ErrConflict
+	case errors.Is(err, storage.ErrVersionConflict):
+		return ErrPreconditionFailed
+	case errors.Is(err, storage.ErrInvalidListOptions):
+		return ErrInvalidQuery
+	case errors.Is(err, storage.ErrInvalidTransition):
+		return ErrInvalidTransition
+	default:
+		return ErrInternalServer
+	}
 }
@@ -1,6 +1,7 @@
 package apperror
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
 )
@@ -9,22 +10,28 @@ type appHandler func(w http.ResponseWriter, r *http.Request) error
 
 func ErrorMiddleware(next appHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := next(w, r)
-		if err != nil {
+		if err := next(w, r); err != nil {
 			log.Println("Error:", err)
-
-			switch err {
-			case ErrMissingRequiredFields:
-				http.Error(w, err.Error(), http.StatusNotFound)
-			case ErrInvalidUuidFormat:
-				http.Error(w, err.Error(), http.StatusNotFound)
-			case ErrNotFound:
-				http.Error(w, err.Error(), http.StatusNotFound)
-			case ErrUnauthorized:
-				http.Error(w, err.Error(), http.StatusUnauthorized)
-			default:
-				http.Error(w, ErrInternalServer.Error(), http.StatusInternalServerError)
-			}
+			Write(w, r, err)
 		}
 	}
 }
+
+// Write sends err as an application/problem+json response.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	problem := *From(err)
+	if problem.Instance == "" {
+		problem.Instance = r.URL.Path
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		log.Println("Error: failed to encode problem:", err)
+	}
+}
+
+func StatusCode(err error) int {
+	return From(err).Status
+}
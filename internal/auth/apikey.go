@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"rest-api/internal/apperror"
+	"rest-api/internal/storage"
+	"time"
+)
+
+// APIKeyPrefix starts every API key, so that leaked keys are easy to spot.
+const APIKeyPrefix = "rk_"
+
+// keyTouchInterval limits how often the last use of a key is written.
+const keyTouchInterval = time.Minute
+
+// KnownPermission reports whether name is a permission, and so a valid
+// API key scope.
+func KnownPermission(name string) bool {
+	return permissions[Permission(name)]
+}
+
+// NewAPIKey returns a new key and the record it is stored as. The key is
+// its visible prefix, which identifies it in listings, and a secret.
+func NewAPIKey(name string, scopes []string, expiresAt *time.Time, createdBy string) (string, storage.APIKey, error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", storage.APIKey{}, err
+	}
+	secret, err := opaqueToken()
+	if err != nil {
+		return "", storage.APIKey{}, err
+	}
+	prefix := APIKeyPrefix + hex.EncodeToString(id)
+	key := prefix + "_" + secret
+	return key, storage.APIKey{
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      HashToken(key),
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// authenticateKey resolves an API key to a principal holding the scopes
+// that its creator's current roles still grant.
+func (m *Middleware) authenticateKey(w http.ResponseWriter, r *http.Request, raw string) (context.Context, error) {
+	key, err := m.keys.FindAPIKey(r.Context(), HashToken(raw))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		m.logger.Errorf("Failed to look up API key: %v", err)
+		return nil, apperror.ErrInternalServer
+	}
+	var (
+		creator    storage.Client
+		creatorErr error
+	)
+	if err == nil {
+		creator, creatorErr = m.storage.FindOne(r.Context(), key.CreatedBy)
+		if creatorErr != nil && !errors.Is(creatorErr, storage.ErrNotFound) && !errors.Is(creatorErr, storage.ErrInvalidID) {
+			m.logger.Errorf("Failed to load creator %s of API key %s: %v", key.CreatedBy, key.Prefix, creatorErr)
+			return nil, apperror.FromStorage(creatorErr)
+		}
+	}
+	now := time.Now()
+	switch {
+	case err != nil:
+		m.logger.Warn("Rejected unknown API key")
+	case key.RevokedAt != nil:
+		m.logger.Warnf("Rejected revoked API key %s", key.Prefix)
+	case key.ExpiresAt != nil && now.After(*key.ExpiresAt):
+		m.logger.Warnf("Rejected expired API key %s", key.Prefix)
+	case creatorErr != nil:
+		m.logger.Warnf("Rejected API key %s of missing user %s", key.Prefix, key.CreatedBy)
+	case checkActive(creator) != nil:
+		m.logger.Warnf("Rejected API key %s of %s user %s", key.Prefix, creator.Status, creator.ID)
+	default:
+		if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > keyTouchInterval {
+			// Only logged by the storage, a failed write must not fail the request.
+			_ = m.keys.TouchAPIKey(r.Context(), key.ID, now)
+		}
+		principal := Principal{
+			ID:          "apikey:" + key.ID,
+			APIKey:      key.ID,
+			permissions: make(map[Permission]bool, len(key.Scopes)),
+		}
+		// The creator needed MFA to grant the scopes, the key can not pass it.
+		granted := m.permissions(creator.Roles, true)
+		for _, scope := range key.Scopes {
+			if granted[Permission(scope)] {
+				principal.permissions[Permission(scope)] = true
+			}
+		}
+		return WithPrincipal(r.Context(), principal), nil
+	}
+	w.Header().Set("WWW-Authenticate", `ApiKey realm="rest-api"`)
+	return nil, apperror.ErrUnauthorized
+}
@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rest-api/internal/apperror"
+	"rest-api/internal/handlers"
+	"rest-api/internal/storage"
+	"rest-api/pkg/password"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+var _ handlers.Handler = &handler{}
+
+const (
+	loginURL   = "/auth/login"
+	refreshURL = "/auth/refresh"
+	logoutURL  = "/auth/logout"
+	forgotURL  = "/auth/password/forgot"
+	resetURL   = "/auth/password/reset"
+	verifyURL  = "/auth/email/verify"
+	resendURL  = "/auth/email/resend"
+	mfaURL     = "/auth/mfa"
+	totpURL    = "/users/:uuid/mfa/totp"
+	confirmURL = "/users/:uuid/mfa/totp/confirm"
+)
+
+type handler struct {
+	logger    *logrus.Logger
+	storage   storage.Storage
+	sessions  storage.TokenStorage
+	passwords handlers.Passwords
+	tokens    *Tokens
+	mailer    *Mailer
+	mfa       *MFA
+	lockout   *Lockout
+	auth      *Middleware
+	// dummyHash is verified against when the login is unknown, so that a
+	// missing account takes as long to reject as a wrong password.
+	dummyHash string
+}
+
+func NewHandler(logger *logrus.Logger, storage storage.Storage, sessions storage.TokenStorage, passwords handlers.Passwords, tokens *Tokens, mailer *Mailer, mfa *MFA, lockout *Lockout, auth *Middleware) (handlers.Handler, error) {
+	dummyHash, err := passwords.Hasher.Hash("not a real password")
+	if err != nil {
+		return nil, err
+	}
+	return &handler{
+		logger:    logger,
+		storage:   storage,
+		sessions:  sessions,
+		passwords: passwords,
+		tokens:    tokens,
+		mailer:    mailer,
+		mfa:       mfa,
+		lockout:   lockout,
+		auth:      auth,
+		dummyHash: dummyHash,
+	}, nil
+}
+
+func (h *handler) Register(router *httprouter.Router) {
+	router.HandlerFunc(http.MethodPost, loginURL, apperror.ErrorMiddleware(h.Login))
+	router.HandlerFunc(http.MethodPost, refreshURL, apperror.ErrorMiddleware(h.Refresh))
+	router.HandlerFunc(http.MethodPost, logoutURL, apperror.ErrorMiddleware(h.Logout))
+	router.HandlerFunc(http.MethodPost, forgotURL, apperror.ErrorMiddleware(h.ForgotPassword))
+	router.HandlerFunc(http.MethodPost, resetURL, apperror.ErrorMiddleware(h.ResetPassword))
+	router.HandlerFunc(http.MethodPost, verifyURL, apperror.ErrorMiddleware(h.VerifyEmail))
+	router.HandlerFunc(http.MethodPost, resendURL, apperror.ErrorMiddleware(h.ResendVerification))
+	router.HandlerFunc(http.MethodPost, mfaURL, apperror.ErrorMiddleware(h.VerifyMFA))
+	router.HandlerFunc(http.MethodPost, totpURL, apperror.ErrorMiddleware(h.auth.AppHandler(Self(), h.EnrollTOTP)))
+	router.HandlerFunc(http.MethodPost, confirmURL, apperror.ErrorMiddleware(h.auth.AppHandler(Self(), h.ConfirmTOTP)))
+	router.HandlerFunc(http.MethodDelete, totpURL, apperror.ErrorMiddleware(h.auth.AppHandler(SelfOr(AdminsManage), h.DeleteTOTP)))
+}
+
+type loginRequest struct {
+	// Login is an email or a username, Email and Username are accepted as aliases.
+	Login    string `json:"login"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Login exchanges credentials for tokens, or for an MFA challenge. Failed
+// attempts are throttled per account and client IP, see Lockout.
+func (h *handler) Login(w http.ResponseWriter, r *http.Request) error {
+	var req loginRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
+		return err
+	}
+	login := req.Login
+	if login == "" {
+		login = req.Email
+	}
+	if login == "" {
+		login = req.Username
+	}
+	if login == "" || req.Password == "" {
+		return apperror.ErrMissingRequiredFields
+	}
+
+	ip := ipKey(h.lockout.ClientIP(r))
+	if err := h.lockout.allow(r.Context(), w, ip, scopeIP); err != nil {
+		return err
+	}
+
+	client, err := h.storage.FindByLogin(r.Context(), login)
+	if errors.Is(err, storage.ErrNotFound) {
+		account := loginKey(login)
+		if err := h.lockout.allow(r.Context(), w, account, scopeAccount); err != nil {
+			return err
+		}
+		h.passwords.Hasher.Verify(h.dummyHash, req.Password)
+		h.logger.Warn("Login failed: unknown account")
+		h.lockout.fail(r.Context(), account, scopeAccount)
+		h.lockout.fail(r.Context(), ip, scopeIP)
+		return apperror.ErrInvalidCredentials
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to look up account: %v", err)
+		return apperror.FromStorage(err)
+	}
+
+	account := accountKey(client.ID)
+	if err := h.lockout.allow(r.Context(), w, account, scopeAccount); err != nil {
+		return err
+	}
+	if err := h.passwords.Hasher.Verify(client.PasswordHash, req.Password); err != nil {
+		if !errors.Is(err, password.ErrMismatch) {
+			h.logger.Errorf("Can not verify password of user %s: %v", client.ID, err)
+		}
+		h.logger.Warnf("Login failed for user %s", client.ID)
+		h.lockout.fail(r.Context(), account, scopeAccount)
+		h.lockout.fail(r.Context(), ip, scopeIP)
+		return apperror.ErrInvalidCredentials
+	}
+	if err := checkActive(client); err != nil {
+		h.logger.Warnf("Login refused for %s user %s", client.Status, client.ID)
+		return err
+	}
+
+	if h.passwords.Hasher.NeedsRehash(client.PasswordHash) {
+		h.rehash(r.Context(), client, req.Password)
+	}
+
+	enabled, err := h.mfa.enabled(r.Context(), client.ID)
+	if err != nil {
+		h.logger.Errorf("Failed to look up MFA of user %s: %v", client.ID, err)
+		return apperror.ErrInternalServer
+	}
+	if enabled {
+		h.logger.Infof("User %s passed the password step, MFA required", client.ID)
+		return h.respondWithChallenge(w, r, client)
+	}
+
+	h.lockout.succeed(r.Context(), account)
+	h.logger.Infof("User %s logged in", client.ID)
+	return h.respondWithTokens(w, r, client, "", false)
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token. A
+// refresh token presented twice was copied, the whole session is revoked.
+func (h *handler) Refresh(w http.ResponseWriter, r *http.Request) error {
+	var req refreshRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
+		return err
+	}
+	if req.RefreshToken == "" {
+		return apperror.ErrMissingRequiredFields
+	}
+
+	token, err := h.sessions.UseRefreshToken(r.Context(), HashToken(req.RefreshToken))
+	if errors.Is(err, storage.ErrNotFound) {
+		return apperror.ErrUnauthorized
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to use refresh token: %v", err)
+		return apperror.ErrInternalServer
+	}
+
+	switch {
+	case token.RevokedAt != nil:
+		h.logger.Warnf("Revoked refresh token presented for user %s", token.UserID)
+		return apperror.ErrUnauthorized
+	case token.UsedAt != nil:
+		h.logger.Warnf("Refresh token reused for user %s, revoking session %s", token.UserID, token.FamilyID)
+		if err := h.sessions.RevokeFamily(r.Context(), token.FamilyID); err != nil {
+			return apperror.ErrInternalServer
+		}
+		return apperror.ErrUnauthorized
+	case time.Now().After(token.ExpiresAt):
+		return apperror.ErrUnauthorized
+	}
+
+	client, err := h.storage.FindOne(r.Context(), token.UserID)
+	if errors.Is(err, storage.ErrNotFound) {
+		h.logger.Warnf("Refresh token of missing user %s", token.UserID)
+		return apperror.ErrUnauthorized
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to load user %s: %v", token.UserID, err)
+		return apperror.FromStorage(err)
+	}
+	if err := checkActive(client); err != nil {
+		h.logger.Warnf("Refresh refused for %s user %s", client.Status, client.ID)
+		return err
+	}
+
+	return h.respondWithTokens(w, r, client, token.FamilyID, token.MFA)
+}
+
+// Logout ends the session of a refresh token. Unknown tokens are ignored.
+func (h *handler) Logout(w http.ResponseWriter, r *http.Request) error {
+	var req refreshRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
+		return err
+	}
+	if req.RefreshToken == "" {
+		return apperror.ErrMissingRequiredFields
+	}
+
+	token, err := h.sessions.UseRefreshToken(r.Context(), HashToken(req.RefreshToken))
+	if err == nil {
+		err = h.sessions.RevokeFamily(r.Context(), token.FamilyID)
+	}
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		h.logger.Errorf("Failed to log out: %v", err)
+		return apperror.ErrInternalServer
+	}
+
+	if err == nil {
+		h.logger.Infof("User %s logged out of session %s", token.UserID, token.FamilyID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// respondWithTokens issues an access token and a refresh token of familyID,
+// or of a new family when it is empty.
+func (h *handler) respondWithTokens(w http.ResponseWriter, r *http.Request, client storage.Client, familyID string, mfa bool) error {
+	token, expires, err := h.tokens.Issue(client, mfa)
+	if err != nil {
+		h.logger.Errorf("Failed to sign access token: %v", err)
+		return apperror.ErrInternalServer
+	}
+
+	refresh, session, err := h.tokens.Refresh(client.ID, familyID, mfa)
+	if err == nil {
+		err = h.sessions.CreateRefreshToken(r.Context(), session)
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to create refresh token: %v", err)
+		return apperror.ErrInternalServer
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(tokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(expires).Round(time.Second) / time.Second),
+		RefreshToken: refresh,
+	}); err != nil {
+		h.logger.Errorf("Failed to encode token: %v", err)
+	}
+
+	return nil
+}
+
+// rehash upgrades a hash made with outdated settings. It is best effort: a
+// failure only means the upgrade is retried on the next login.
+func (h *handler) rehash(ctx context.Context, client storage.Client, plain string) {
+	hash, err := h.passwords.Hasher.Hash(plain)
+	if err != nil {
+		h.logger.Errorf("Failed to rehash password of user %s: %v", client.ID, err)
+		return
+	}
+	_, err = h.storage.PartiallyUpdate(ctx, storage.Patch{
+		ID:      client.ID,
+		Version: client.Version,
+		Set:     map[string]string{storage.FieldPassword: hash},
+	})
+	if err != nil {
+		h.logger.Warnf("Failed to store rehashed password of user %s: %v", client.ID, err)
+		return
+	}
+	h.logger.Infof("Upgraded password hash of user %s", client.ID)
+}
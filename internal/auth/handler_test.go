@@ -0,0 +1,387 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"rest-api/internal/apikey"
+	"rest-api/internal/auth"
+	"rest-api/internal/handlers"
+	"rest-api/internal/lockout"
+	"rest-api/internal/mfa"
+	"rest-api/internal/session"
+	"rest-api/internal/storage"
+	"rest-api/internal/user"
+	"rest-api/pkg/notify"
+	"rest-api/pkg/password"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pquerna/otp/totp"
+	"github.com/sirupsen/logrus"
+)
+
+const testPassword = "Correct-horse-99"
+
+// server wires the auth and user routes to memory storages.
+type server struct {
+	router    *httprouter.Router
+	users     *user.MemoryStorage
+	keys      *apikey.MemoryStorage
+	lockout   *auth.Lockout
+	passwords handlers.Passwords
+}
+
+func newServer(t *testing.T, lockoutOpts auth.LockoutOptions) *server {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	hasher, err := password.NewHasher(password.Params{Algorithm: password.Bcrypt, BcryptCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.NewTokens(auth.TokenOptions{
+		Algorithm:  "HS256",
+		Secret:     "0123456789abcdef0123456789abcdef",
+		Issuer:     "rest-api",
+		TTL:        time.Minute,
+		RefreshTTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	factors, err := auth.NewMFA(auth.MFAOptions{Storage: mfa.NewMemoryStorage(logger), Issuer: "rest-api", ChallengeTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier, err := notify.NewFile(filepath.Join(t.TempDir(), "mail.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{
+		router:    httprouter.New(),
+		users:     user.NewMemoryStorage(logger),
+		keys:      apikey.NewMemoryStorage(logger),
+		passwords: handlers.Passwords{Hasher: hasher, Policy: &password.Policy{}},
+	}
+	sessions := session.NewMemoryStorage(logger)
+	lockoutOpts.Storage = lockout.NewMemoryStorage(logger)
+	if lockoutOpts.Window == 0 {
+		lockoutOpts.Window = time.Hour
+	}
+	s.lockout = auth.NewLockout(logger, lockoutOpts)
+
+	middleware := auth.NewMiddleware(logger, tokens, s.users, s.keys, auth.DefaultRoles, nil)
+	mailer := auth.NewMailer(logger, s.users, sessions, auth.MailOptions{Notifier: notifier, ResetTTL: time.Hour, VerifyTTL: time.Hour, Workers: 1, Queue: 10})
+	authHandler, err := auth.NewHandler(logger, s.users, sessions, s.passwords, tokens, mailer, factors, s.lockout, middleware)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authHandler.Register(s.router)
+	user.NewHandler(logger, s.users, s.passwords, mailer, middleware).Register(s.router)
+	return s
+}
+
+// createUser stores an active user with testPassword.
+func (s *server) createUser(t *testing.T, username string, roles ...string) storage.Client {
+	t.Helper()
+	hash, err := s.passwords.Hasher.Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	id, err := s.users.Create(ctx, storage.Client{Email: username + "@example.com", Username: username, PasswordHash: hash, Roles: roles})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	client, err := s.users.FindOne(ctx, id)
+	if err != nil {
+		t.Fatalf("FindOne() error = %v", err)
+	}
+	return client
+}
+
+// do serves a request with a JSON body and an Authorization header.
+func (s *server) do(method, path, authorization string, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	r := httptest.NewRequest(method, path, &buf)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	return w
+}
+
+// decode reads the JSON body of a response that must have status want.
+func decode(t *testing.T, w *httptest.ResponseRecorder, want int) map[string]any {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d, want %d: %s", w.Code, want, w.Body.String())
+	}
+	var body map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func (s *server) login(t *testing.T, login, pass string) *httptest.ResponseRecorder {
+	t.Helper()
+	return s.do(http.MethodPost, "/auth/login", "", map[string]string{"login": login, "password": pass})
+}
+
+func TestRefreshReuse(t *testing.T) {
+	s := newServer(t, auth.LockoutOptions{})
+	s.createUser(t, "bob")
+
+	refresh := func(token string) *httptest.ResponseRecorder {
+		return s.do(http.MethodPost, "/auth/refresh", "", map[string]string{"refresh_token": token})
+	}
+
+	first := decode(t, s.login(t, "bob", testPassword), http.StatusOK)["refresh_token"].(string)
+	second := decode(t, refresh(first), http.StatusOK)["refresh_token"].(string)
+
+	// Presenting the first token again betrays a copy, the whole family
+	// goes, including the token that replaced it.
+	if w := refresh(first); w.Code != http.StatusUnauthorized {
+		t.Errorf("reused token: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := refresh(second); w.Code != http.StatusUnauthorized {
+		t.Errorf("token of the revoked family: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// Other sessions are not affected.
+	other := decode(t, s.login(t, "bob", testPassword), http.StatusOK)["refresh_token"].(string)
+	decode(t, refresh(other), http.StatusOK)
+}
+
+func TestLoginLockout(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("backoff", func(t *testing.T) {
+		s := newServer(t, auth.LockoutOptions{BaseDelay: time.Hour, MaxDelay: time.Hour})
+		s.createUser(t, "bob")
+
+		if w := s.login(t, "bob", "wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password: status = %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+		// Even the right password waits out the delay.
+		w := s.login(t, "bob", testPassword)
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("during backoff: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+		}
+		if w.Header().Get("Retry-After") != "3600" {
+			t.Errorf("Retry-After = %q, want %q", w.Header().Get("Retry-After"), "3600")
+		}
+	})
+
+	t.Run("lockout and unlock", func(t *testing.T) {
+		s := newServer(t, auth.LockoutOptions{MaxFailures: 3, LockDuration: time.Hour})
+		bob := s.createUser(t, "bob")
+		s.createUser(t, "amy")
+
+		for i := 0; i < 3; i++ {
+			if w := s.login(t, "bob", "wrong"); w.Code != http.StatusUnauthorized {
+				t.Fatalf("failure %d: status = %d, want %d", i+1, w.Code, http.StatusUnauthorized)
+			}
+		}
+		if w := s.login(t, "bob", testPassword); w.Code != http.StatusTooManyRequests {
+			t.Fatalf("locked account: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+		}
+		// The lockout is per account, not per client.
+		decode(t, s.login(t, "amy", testPassword), http.StatusOK)
+
+		unlocked, err := s.lockout.Unlock(ctx, bob.ID, "admin")
+		if err != nil || !unlocked {
+			t.Fatalf("Unlock() = %t, %v, want true", unlocked, err)
+		}
+		decode(t, s.login(t, "bob", testPassword), http.StatusOK)
+		if unlocked, _ := s.lockout.Unlock(ctx, bob.ID, "admin"); unlocked {
+			t.Error("Unlock() after a successful login = true, want false")
+		}
+	})
+
+	t.Run("client IP", func(t *testing.T) {
+		s := newServer(t, auth.LockoutOptions{IPFailures: 2, LockDuration: time.Hour})
+		s.createUser(t, "bob")
+
+		// Unknown logins count against the client IP as well.
+		for _, login := range []string{"nobody", "bob"} {
+			if w := s.login(t, login, "wrong"); w.Code != http.StatusUnauthorized {
+				t.Fatalf("login %s: status = %d, want %d", login, w.Code, http.StatusUnauthorized)
+			}
+		}
+		if w := s.login(t, "bob", testPassword); w.Code != http.StatusTooManyRequests {
+			t.Errorf("locked client IP: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+		}
+	})
+}
+
+// enrollTOTP enables TOTP for client and returns its secret.
+func (s *server) enrollTOTP(t *testing.T, client storage.Client) string {
+	t.Helper()
+	access := "Bearer " + decode(t, s.login(t, client.Username, testPassword), http.StatusOK)["access_token"].(string)
+	path := "/users/" + client.ID + "/mfa/totp"
+	secret := decode(t, s.do(http.MethodPost, path, access, nil), http.StatusCreated)["secret"].(string)
+	decode(t, s.do(http.MethodPost, path+"/confirm", access, map[string]string{"code": totpCode(t, secret, time.Now())}), http.StatusOK)
+	return secret
+}
+
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCode(secret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestMFAChallenge(t *testing.T) {
+	s := newServer(t, auth.LockoutOptions{})
+	bob := s.createUser(t, "bob")
+	secret := s.enrollTOTP(t, bob)
+
+	challenge := func() string {
+		t.Helper()
+		body := decode(t, s.login(t, "bob", testPassword), http.StatusOK)
+		if body["mfa_required"] != true || body["access_token"] != nil {
+			t.Fatalf("login with TOTP = %v, want a challenge", body)
+		}
+		return body["mfa_token"].(string)
+	}
+	verify := func(token, code string) *httptest.ResponseRecorder {
+		return s.do(http.MethodPost, "/auth/mfa", "", map[string]string{"mfa_token": token, "code": code})
+	}
+
+	// The code of the enrollment was spent, use the next time step.
+	code := totpCode(t, secret, time.Now().Add(30*time.Second))
+	wrong := "000000"
+	for _, valid := range []string{code, totpCode(t, secret, time.Now()), totpCode(t, secret, time.Now().Add(-30*time.Second))} {
+		if wrong == valid {
+			wrong = "111111"
+		}
+	}
+
+	token := challenge()
+	if w := verify(token, wrong); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong code: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	// A challenge allows a single attempt, the right code comes too late.
+	if w := verify(token, code); w.Code != http.StatusUnauthorized {
+		t.Fatalf("spent challenge: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	token = challenge()
+	body := decode(t, verify(token, code), http.StatusOK)
+	if body["access_token"] == nil || body["refresh_token"] == nil {
+		t.Errorf("verified challenge = %v, want tokens", body)
+	}
+	if w := verify(token, code); w.Code != http.StatusUnauthorized {
+		t.Errorf("reused challenge: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		roles  []string
+		scopes []string
+		setup  func(s *server, creator storage.Client, keyID string) error
+		method string
+		path   string
+		want   int
+	}{
+		{
+			name:   "scope granted by the creator",
+			roles:  []string{"support"},
+			scopes: []string{string(auth.UsersRead)},
+			method: http.MethodGet, path: "/users", want: http.StatusOK,
+		},
+		{
+			name:   "scope the creator lacks",
+			roles:  []string{"support"},
+			scopes: []string{string(auth.UsersWrite)},
+			method: http.MethodPost, path: "/batch/users", want: http.StatusForbidden,
+		},
+		{
+			name:   "creator permission outside the scopes",
+			roles:  []string{auth.AdminRole},
+			scopes: []string{string(auth.UsersRead)},
+			method: http.MethodPost, path: "/batch/users", want: http.StatusForbidden,
+		},
+		{
+			name:   "creator lost the role",
+			roles:  []string{"support"},
+			scopes: []string{string(auth.UsersRead)},
+			setup: func(s *server, creator storage.Client, keyID string) error {
+				return s.users.RevokeRole(ctx, creator.ID, "support")
+			},
+			method: http.MethodGet, path: "/users", want: http.StatusForbidden,
+		},
+		{
+			name:   "suspended creator",
+			roles:  []string{"support"},
+			scopes: []string{string(auth.UsersRead)},
+			setup: func(s *server, creator storage.Client, keyID string) error {
+				return s.users.SetStatus(ctx, creator.ID, storage.StatusActive, storage.StatusSuspended)
+			},
+			method: http.MethodGet, path: "/users", want: http.StatusUnauthorized,
+		},
+		{
+			name:   "deleted creator",
+			roles:  []string{"support"},
+			scopes: []string{string(auth.UsersRead)},
+			setup: func(s *server, creator storage.Client, keyID string) error {
+				return s.users.Delete(ctx, creator.ID, storage.DeleteOptions{})
+			},
+			method: http.MethodGet, path: "/users", want: http.StatusUnauthorized,
+		},
+		{
+			name:   "revoked key",
+			roles:  []string{"support"},
+			scopes: []string{string(auth.UsersRead)},
+			setup: func(s *server, creator storage.Client, keyID string) error {
+				return s.keys.RevokeAPIKey(ctx, keyID)
+			},
+			method: http.MethodGet, path: "/users", want: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServer(t, auth.LockoutOptions{})
+			creator := s.createUser(t, "amy", tt.roles...)
+
+			key, record, err := auth.NewAPIKey("ci", tt.scopes, nil, creator.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := s.keys.CreateAPIKey(ctx, record)
+			if err != nil {
+				t.Fatalf("CreateAPIKey() error = %v", err)
+			}
+			if tt.setup != nil {
+				if err := tt.setup(s, creator, id); err != nil {
+					t.Fatalf("setup error = %v", err)
+				}
+			}
+
+			if w := s.do(tt.method, tt.path, "ApiKey "+key, nil); w.Code != tt.want {
+				t.Errorf("%s %s: status = %d, want %d: %s", tt.method, tt.path, w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"rest-api/internal/apperror"
+	"rest-api/internal/storage"
+	"rest-api/pkg/metrics"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	scopeAccount = "account"
+	scopeIP      = "ip"
+	// scopeMail limits the requests of a client IP that send mail.
+	scopeMail = "mail"
+)
+
+// LockoutOptions mirror the lockout section of the config. Each mail request
+// counts as a failure of the client IP against MailRequests.
+type LockoutOptions struct {
+	Storage      storage.AttemptStorage
+	MaxFailures  int
+	IPFailures   int
+	MailRequests int
+	LockDuration time.Duration
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Window       time.Duration
+	// TrustForwardedFor reads the client IP from the last X-Forwarded-For entry.
+	TrustForwardedFor bool
+}
+
+// Lockout tracks failed logins per account and client IP. Unknown logins are
+// tracked by name so that a lockout does not reveal which accounts exist.
+type Lockout struct {
+	logger *logrus.Logger
+	opts   LockoutOptions
+}
+
+func NewLockout(logger *logrus.Logger, opts LockoutOptions) *Lockout {
+	return &Lockout{logger: logger, opts: opts}
+}
+
+func accountKey(userID string) string { return "user:" + userID }
+
+func loginKey(login string) string { return "login:" + strings.ToLower(login) }
+
+func ipKey(ip string) string { return "ip:" + ip }
+
+func mailKey(ip string) string { return "mail:" + ip }
+
+// ClientIP returns the address of the caller of r.
+func (l *Lockout) ClientIP(r *http.Request) string {
+	if l.opts.TrustForwardedFor {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allow rejects the attempt with a Retry-After header while key waits out
+// its backoff or lockout.
+func (l *Lockout) allow(ctx context.Context, w http.ResponseWriter, key, scope string) error {
+	attempts, err := l.opts.Storage.GetAttempts(ctx, key)
+	if err != nil {
+		l.logger.Errorf("Failed to read login attempts: %v", err)
+		return apperror.ErrInternalServer
+	}
+	wait := time.Until(l.until(attempts, l.threshold(scope)))
+	if wait <= 0 {
+		return nil
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	l.logger.Warnf("Attempt of %s rejected, retry in %s", key, wait.Round(time.Second))
+	return apperror.ErrTooManyAttempts
+}
+
+// throttleMail counts a request that sends mail against the client IP of r,
+// and rejects it while the IP waits out its backoff or lockout.
+func (l *Lockout) throttleMail(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	key := mailKey(l.ClientIP(r))
+	if err := l.allow(ctx, w, key, scopeMail); err != nil {
+		return err
+	}
+
+	attempts, err := l.opts.Storage.RecordFailure(ctx, key, time.Now().Add(l.opts.Window))
+	if err != nil {
+		l.logger.Errorf("Failed to record mail request: %v", err)
+		return nil
+	}
+	if threshold := l.threshold(scopeMail); threshold > 0 && attempts.Failures >= threshold {
+		l.logger.WithFields(logrus.Fields{
+			"event":    "lockout",
+			"scope":    scopeMail,
+			"key":      key,
+			"requests": attempts.Failures,
+			"until":    l.until(attempts, threshold).Format(time.RFC3339),
+		}).Warnf("Locked %s after %d mail requests", key, attempts.Failures)
+		metrics.RecordLockout(scopeMail)
+	}
+	return nil
+}
+
+// fail records a failed attempt of key and reports when it locks key.
+// Storage errors are logged only, the attempt was rejected anyway.
+func (l *Lockout) fail(ctx context.Context, key, scope string) {
+	metrics.RecordLoginFailure(scope)
+
+	attempts, err := l.opts.Storage.RecordFailure(ctx, key, time.Now().Add(l.opts.Window))
+	if err != nil {
+		l.logger.Errorf("Failed to record login failure: %v", err)
+		return
+	}
+	if threshold := l.threshold(scope); threshold > 0 && attempts.Failures >= threshold {
+		l.logger.WithFields(logrus.Fields{
+			"event":    "lockout",
+			"scope":    scope,
+			"key":      key,
+			"failures": attempts.Failures,
+			"until":    l.until(attempts, threshold).Format(time.RFC3339),
+		}).Warnf("Locked %s after %d failed logins", key, attempts.Failures)
+		metrics.RecordLockout(scope)
+	}
+}
+
+// succeed forgets the failures of an account. Those of the client IP are
+// kept, a valid login of its own must not let a client keep guessing.
+func (l *Lockout) succeed(ctx context.Context, key string) {
+	if _, err := l.opts.Storage.ResetAttempts(ctx, key); err != nil {
+		l.logger.Errorf("Failed to reset login attempts: %v", err)
+	}
+}
+
+// Unlock forgets the failed logins of an account, lifting its lockout, and
+// reports whether it had any.
+func (l *Lockout) Unlock(ctx context.Context, userID, by string) (bool, error) {
+	unlocked, err := l.opts.Storage.ResetAttempts(ctx, accountKey(userID))
+	if err != nil {
+		return false, err
+	}
+	if unlocked {
+		l.logger.WithFields(logrus.Fields{
+			"event": "unlock",
+			"scope": scopeAccount,
+			"key":   accountKey(userID),
+			"by":    by,
+		}).Infof("User %s unlocked user %s", by, userID)
+		metrics.RecordUnlock(scopeAccount)
+	}
+	return unlocked, nil
+}
+
+func (l *Lockout) threshold(scope string) int {
+	switch scope {
+	case scopeIP:
+		return l.opts.IPFailures
+	case scopeMail:
+		return l.opts.MailRequests
+	}
+	return l.opts.MaxFailures
+}
+
+// until returns when the next attempt is allowed after attempts.
+func (l *Lockout) until(attempts storage.LoginAttempts, threshold int) time.Time {
+	if attempts.Failures == 0 {
+		return time.Time{}
+	}
+	if threshold > 0 && attempts.Failures >= threshold {
+		return attempts.LastFailure.Add(l.opts.LockDuration)
+	}
+	if l.opts.BaseDelay <= 0 {
+		return time.Time{}
+	}
+	delay := l.opts.MaxDelay
+	// Past 30 doublings any delay exceeds a sensible MaxDelay.
+	if n := attempts.Failures - 1; n < 30 {
+		delay = min(l.opts.BaseDelay<<n, l.opts.MaxDelay)
+	}
+	return attempts.LastFailure.Add(delay)
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"rest-api/internal/storage"
+	"testing"
+	"time"
+)
+
+func TestLockoutUntil(t *testing.T) {
+	last := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	opts := LockoutOptions{
+		LockDuration: 15 * time.Minute,
+		BaseDelay:    time.Second,
+		MaxDelay:     30 * time.Second,
+	}
+	noBackoff := opts
+	noBackoff.BaseDelay = 0
+
+	tests := []struct {
+		name      string
+		opts      LockoutOptions
+		failures  int
+		threshold int
+		want      time.Time
+	}{
+		{"no failures", opts, 0, 5, time.Time{}},
+		{"first failure", opts, 1, 5, last.Add(time.Second)},
+		{"second failure doubles", opts, 2, 5, last.Add(2 * time.Second)},
+		{"fourth failure", opts, 4, 5, last.Add(8 * time.Second)},
+		{"capped at max delay", opts, 6, 0, last.Add(30 * time.Second)},
+		{"no overflow", opts, 100, 0, last.Add(30 * time.Second)},
+		{"threshold locks", opts, 5, 5, last.Add(15 * time.Minute)},
+		{"past threshold", opts, 7, 5, last.Add(15 * time.Minute)},
+		{"threshold 0 never locks", opts, 50, 0, last.Add(30 * time.Second)},
+		{"without backoff", noBackoff, 3, 5, time.Time{}},
+		{"without backoff still locks", noBackoff, 5, 5, last.Add(15 * time.Minute)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Lockout{opts: tt.opts}
+			attempts := storage.LoginAttempts{Failures: tt.failures, LastFailure: last}
+			if got := l.until(attempts, tt.threshold); !got.Equal(tt.want) {
+				t.Errorf("until() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
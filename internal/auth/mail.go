@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"rest-api/internal/storage"
+	"rest-api/pkg/notify"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sendTimeout bounds the lookup and delivery of a message, which outlive
+// the request that asked for it.
+const sendTimeout = 30 * time.Second
+
+// MailOptions configure the token messages. The token is added to a URL as
+// its token query parameter, without a URL the bare token is sent.
+type MailOptions struct {
+	Notifier  notify.Notifier
+	ResetTTL  time.Duration
+	ResetURL  string
+	VerifyTTL time.Duration
+	VerifyURL string
+	// Workers deliver the messages, beyond Queue waiting ones they are dropped.
+	Workers int
+	Queue   int
+}
+
+// Mailer sends password reset and email verification tokens in the
+// background, failures are only logged.
+type Mailer struct {
+	logger   *logrus.Logger
+	storage  storage.Storage
+	sessions storage.TokenStorage
+	opts     MailOptions
+	jobs     chan func()
+}
+
+func NewMailer(logger *logrus.Logger, storage storage.Storage, sessions storage.TokenStorage, opts MailOptions) *Mailer {
+	m := &Mailer{logger: logger, storage: storage, sessions: sessions, opts: opts, jobs: make(chan func(), max(opts.Queue, 0))}
+	for range max(opts.Workers, 1) {
+		go func() {
+			for job := range m.jobs {
+				job()
+			}
+		}()
+	}
+	return m
+}
+
+// enqueue hands job to the workers, or drops it when the queue is full.
+func (m *Mailer) enqueue(what string, job func()) {
+	select {
+	case m.jobs <- job:
+	default:
+		m.logger.Warnf("Mail queue is full, dropped %s", what)
+	}
+}
+
+// SendReset mails a password reset token to the owner of email, if any.
+func (m *Mailer) SendReset(email string) {
+	m.enqueue("password reset", func() {
+		client, ok := m.lookup(email, "Password reset")
+		if !ok {
+			return
+		}
+		m.send(client, storage.PurposePasswordReset, m.opts.ResetTTL, m.opts.ResetURL, "Reset your password",
+			"Someone asked to reset the password of your account. To choose a new one, use:")
+	})
+}
+
+// SendVerification mails an email verification token to client.
+func (m *Mailer) SendVerification(client storage.Client) {
+	m.enqueue("verification", func() { m.sendVerification(client) })
+}
+
+// ResendVerification mails a new verification token to the owner of email,
+// if the account still waits for one.
+func (m *Mailer) ResendVerification(email string) {
+	m.enqueue("verification", func() {
+		client, ok := m.lookup(email, "Verification")
+		if !ok {
+			return
+		}
+		if client.Status != storage.StatusPendingVerification {
+			m.logger.Warnf("Verification requested for user %s, which is %s", client.ID, client.Status)
+			return
+		}
+		m.sendVerification(client)
+	})
+}
+
+func (m *Mailer) sendVerification(client storage.Client) {
+	m.send(client, storage.PurposeVerifyEmail, m.opts.VerifyTTL, m.opts.VerifyURL, "Verify your email",
+		"Welcome! To confirm this is your email address and activate your account, use:")
+}
+
+func (m *Mailer) lookup(email, what string) (storage.Client, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	if !strings.Contains(email, "@") {
+		m.logger.Warnf("%s requested for a login that is not an email", what)
+		return storage.Client{}, false
+	}
+	client, err := m.storage.FindByLogin(ctx, email)
+	if errors.Is(err, storage.ErrNotFound) {
+		m.logger.Warnf("%s requested for an unknown email", what)
+		return client, false
+	}
+	if err != nil {
+		m.logger.Errorf("Failed to look up account: %v", err)
+		return client, false
+	}
+	return client, true
+}
+
+func (m *Mailer) send(client storage.Client, purpose string, ttl time.Duration, link, subject, intro string) {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	token, record, err := OneTime(client.ID, purpose, ttl)
+	if err == nil {
+		err = m.sessions.CreateOneTimeToken(ctx, record)
+	}
+	if err != nil {
+		m.logger.Errorf("Failed to create %s token for user %s: %v", purpose, client.ID, err)
+		return
+	}
+
+	err = m.opts.Notifier.Notify(ctx, notify.Message{
+		To:      client.Email,
+		Subject: subject,
+		Body: fmt.Sprintf("%s\n\n%s\n\nIt works once and expires at %s. If you did not ask for it, ignore this message.",
+			intro, withToken(link, token), record.ExpiresAt.Format(time.RFC1123)),
+	})
+	if err != nil {
+		m.logger.Errorf("Failed to send %s token to user %s: %v", purpose, client.ID, err)
+		return
+	}
+	m.logger.Infof("Sent %s token to user %s", purpose, client.ID)
+}
+
+func withToken(link, token string) string {
+	if link == "" {
+		return token
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return token
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,476 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image/png"
+	"net/http"
+	"rest-api/internal/apperror"
+	"rest-api/internal/handlers"
+	"rest-api/internal/storage"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// totpPeriod and totpSkew accept the codes of the previous, current and
+	// next 30 second step, the defaults of authenticator apps.
+	totpPeriod = 30
+	totpSkew   = 1
+	// recoveryCodes are issued on confirmation, each works once.
+	recoveryCodes = 10
+	// sealedPrefix marks secrets encrypted with the MFA key.
+	sealedPrefix = "sealed:"
+	// recoveryAlphabet leaves out characters that are easily confused.
+	recoveryAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+)
+
+var (
+	errInvalidCode = errors.New("invalid verification code")
+	errNoKey       = errors.New("TOTP secret is sealed but no MFA key is configured")
+)
+
+var totpOpts = totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+
+// MFAOptions configure TOTP multi-factor authentication.
+type MFAOptions struct {
+	Storage storage.MFAStorage
+	// Issuer names the account in authenticator apps.
+	Issuer string
+	// Key encrypts TOTP secrets at rest, without it they are stored as is.
+	Key string
+	// ChallengeTTL bounds the time between the two steps of a login.
+	ChallengeTTL time.Duration
+}
+
+type MFA struct {
+	storage      storage.MFAStorage
+	issuer       string
+	aead         cipher.AEAD
+	challengeTTL time.Duration
+}
+
+func NewMFA(opts MFAOptions) (*MFA, error) {
+	if opts.ChallengeTTL <= 0 {
+		return nil, errors.New("MFA challenge TTL must be positive")
+	}
+	m := &MFA{storage: opts.Storage, issuer: opts.Issuer, challengeTTL: opts.ChallengeTTL}
+	if opts.Key != "" {
+		key := sha256.Sum256([]byte(opts.Key))
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return nil, err
+		}
+		if m.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Sealed reports whether TOTP secrets are encrypted at rest.
+func (m *MFA) Sealed() bool {
+	return m.aead != nil
+}
+
+type enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+	// QRCode is a PNG of URI, base64 encoded.
+	QRCode string `json:"qr_png"`
+}
+
+// enroll starts a TOTP enrollment for client, replacing one that was never
+// confirmed.
+func (m *MFA) enroll(ctx context.Context, client storage.Client) (enrollment, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      m.issuer,
+		AccountName: client.Email,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return enrollment{}, err
+	}
+	sealed, err := m.seal(key.Secret())
+	if err != nil {
+		return enrollment{}, err
+	}
+	err = m.storage.CreateTOTP(ctx, storage.TOTP{UserID: client.ID, Secret: sealed, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		return enrollment{}, err
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return enrollment{}, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return enrollment{}, err
+	}
+	return enrollment{
+		Secret: key.Secret(),
+		URI:    key.URL(),
+		QRCode: base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// confirm completes the pending enrollment of userID with a first code and
+// returns the recovery codes, which are only ever shown here.
+func (m *MFA) confirm(ctx context.Context, userID, code string) ([]string, error) {
+	enrolled, err := m.storage.FindTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if enrolled.ConfirmedAt != nil {
+		return nil, storage.ErrNotFound
+	}
+	step, err := m.match(enrolled, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodes)
+	hashes := make([]string, recoveryCodes)
+	for i := range codes {
+		if codes[i], err = recoveryCode(); err != nil {
+			return nil, err
+		}
+		hashes[i] = HashToken(normalizeRecoveryCode(codes[i]))
+	}
+	if err := m.storage.ConfirmTOTP(ctx, userID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// enabled reports whether userID has a confirmed enrollment.
+func (m *MFA) enabled(ctx context.Context, userID string) (bool, error) {
+	enrolled, err := m.storage.FindTOTP(ctx, userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return enrolled.ConfirmedAt != nil, nil
+}
+
+// verify checks a TOTP code, or a recovery code when code is empty. Either
+// is accepted once.
+func (m *MFA) verify(ctx context.Context, userID, code, recovery string) error {
+	if code == "" {
+		err := m.storage.UseRecoveryCode(ctx, userID, HashToken(normalizeRecoveryCode(recovery)))
+		if errors.Is(err, storage.ErrNotFound) {
+			return errInvalidCode
+		}
+		return err
+	}
+
+	enrolled, err := m.storage.FindTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	step, err := m.match(enrolled, code)
+	if err != nil {
+		return err
+	}
+	err = m.storage.UseTOTPStep(ctx, userID, step)
+	if errors.Is(err, storage.ErrNotFound) {
+		return errInvalidCode
+	}
+	return err
+}
+
+// match returns the time step whose code equals code.
+func (m *MFA) match(enrolled storage.TOTP, code string) (int64, error) {
+	secret, err := m.open(enrolled.Secret)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	for skew := -totpSkew; skew <= totpSkew; skew++ {
+		at := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, at, totpOpts)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return at.Unix() / totpPeriod, nil
+		}
+	}
+	return 0, errInvalidCode
+}
+
+func (m *MFA) seal(secret string) (string, error) {
+	if m.aead == nil {
+		return secret, nil
+	}
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(m.aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+func (m *MFA) open(stored string) (string, error) {
+	raw, ok := strings.CutPrefix(stored, sealedPrefix)
+	if !ok {
+		return stored, nil
+	}
+	if m.aead == nil {
+		return "", errNoKey
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(raw)
+	if err != nil || len(sealed) < m.aead.NonceSize() {
+		return "", errors.New("malformed sealed TOTP secret")
+	}
+	nonce, ciphertext := sealed[:m.aead.NonceSize()], sealed[m.aead.NonceSize():]
+	secret, err := m.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// recoveryCode returns 10 random characters grouped as xxxxx-xxxxx.
+func recoveryCode() (string, error) {
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	for i, b := range raw {
+		raw[i] = recoveryAlphabet[int(b)%len(recoveryAlphabet)]
+	}
+	return string(raw[:5]) + "-" + string(raw[5:]), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+type challengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type mfaRequest struct {
+	MFAToken string `json:"mfa_token"`
+	// Code is the current TOTP code, RecoveryCode replaces it when the
+	// authenticator is lost.
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type codeRequest struct {
+	Code string `json:"code"`
+}
+
+type disableRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// respondWithChallenge answers the password step of a login with MFA by a
+// token for VerifyMFA instead of session tokens.
+func (h *handler) respondWithChallenge(w http.ResponseWriter, r *http.Request, client storage.Client) error {
+	token, record, err := OneTime(client.ID, storage.PurposeMFAChallenge, h.mfa.challengeTTL)
+	if err == nil {
+		err = h.sessions.CreateOneTimeToken(r.Context(), record)
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to create MFA challenge: %v", err)
+		return apperror.ErrInternalServer
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(challengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int64(h.mfa.challengeTTL / time.Second),
+	}); err != nil {
+		h.logger.Errorf("Failed to encode MFA challenge: %v", err)
+	}
+	return nil
+}
+
+// VerifyMFA completes a login with the challenge token of the password step
+// and a TOTP or recovery code. A challenge allows a single attempt.
+func (h *handler) VerifyMFA(w http.ResponseWriter, r *http.Request) error {
+	var req mfaRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
+		return err
+	}
+	if req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		return apperror.ErrMissingRequiredFields
+	}
+
+	challenge, err := h.sessions.UseOneTimeToken(r.Context(), HashToken(req.MFAToken), storage.PurposeMFAChallenge)
+	if errors.Is(err, storage.ErrNotFound) {
+		return apperror.ErrUnauthorized
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to use MFA challenge: %v", err)
+		return apperror.ErrInternalServer
+	}
+
+	client, err := h.storage.FindOne(r.Context(), challenge.UserID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return apperror.ErrUnauthorized
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to load user %s: %v", challenge.UserID, err)
+		return apperror.FromStorage(err)
+	}
+	if err := checkActive(client); err != nil {
+		return err
+	}
+	// Failed codes count against the account like failed passwords, and
+	// the password step does not reset them.
+	account := accountKey(client.ID)
+	if err := h.lockout.allow(r.Context(), w, account, scopeAccount); err != nil {
+		return err
+	}
+
+	if err := h.mfa.verify(r.Context(), client.ID, req.Code, req.RecoveryCode); err != nil {
+		if !errors.Is(err, errInvalidCode) {
+			h.logger.Errorf("Failed to verify MFA of user %s: %v", client.ID, err)
+			return apperror.ErrInternalServer
+		}
+		h.logger.Warnf("MFA failed for user %s", client.ID)
+		h.lockout.fail(r.Context(), account, scopeAccount)
+		h.lockout.fail(r.Context(), ipKey(h.lockout.ClientIP(r)), scopeIP)
+		return apperror.ErrInvalidCredentials
+	}
+	if req.Code == "" {
+		h.logger.Warnf("User %s logged in with a recovery code", client.ID)
+	}
+
+	h.lockout.succeed(r.Context(), account)
+	h.logger.Infof("User %s logged in with MFA", client.ID)
+	return h.respondWithTokens(w, r, client, "", true)
+}
+
+// EnrollTOTP starts a TOTP enrollment. It stays inactive until confirmed
+// with a first code.
+func (h *handler) EnrollTOTP(w http.ResponseWriter, r *http.Request) error {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("uuid")
+
+	client, err := h.storage.FindOne(r.Context(), id)
+	if err != nil {
+		h.logger.Errorf("Failed to find user by ID %s: %v", id, err)
+		return apperror.FromStorage(err)
+	}
+	enrolled, err := h.mfa.enroll(r.Context(), client)
+	if errors.Is(err, storage.ErrDuplicateKey) {
+		return apperror.Conflict("totp")
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to enroll TOTP for user %s: %v", id, err)
+		return apperror.ErrInternalServer
+	}
+
+	h.logger.Infof("User %s started a TOTP enrollment", id)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(enrolled); err != nil {
+		h.logger.Errorf("Failed to encode TOTP enrollment: %v", err)
+	}
+	return nil
+}
+
+// ConfirmTOTP enables the pending enrollment and returns the recovery codes.
+func (h *handler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) error {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("uuid")
+
+	var req codeRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
+		return err
+	}
+	if req.Code == "" {
+		return apperror.ErrMissingRequiredFields
+	}
+
+	codes, err := h.mfa.confirm(r.Context(), id, req.Code)
+	switch {
+	case errors.Is(err, errInvalidCode):
+		return apperror.ErrInvalidCode
+	case errors.Is(err, storage.ErrNotFound):
+		return apperror.ErrNotFound
+	case err != nil:
+		h.logger.Errorf("Failed to confirm TOTP of user %s: %v", id, err)
+		return apperror.ErrInternalServer
+	}
+
+	h.logger.Infof("User %s enabled TOTP", id)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes}); err != nil {
+		h.logger.Errorf("Failed to encode recovery codes: %v", err)
+	}
+	return nil
+}
+
+// DeleteTOTP disables MFA. Owners have to present a code so that a stolen
+// session alone can not turn it off, admins do not.
+func (h *handler) DeleteTOTP(w http.ResponseWriter, r *http.Request) error {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("uuid")
+	principal, _ := PrincipalFrom(r.Context())
+
+	if principal.ID == id {
+		var req disableRequest
+		if err := handlers.Decode(w, r, &req); err != nil {
+			return err
+		}
+		if req.Code == "" && req.RecoveryCode == "" {
+			return apperror.ErrMissingRequiredFields
+		}
+		account := accountKey(id)
+		if err := h.lockout.allow(r.Context(), w, account, scopeAccount); err != nil {
+			return err
+		}
+		err := h.mfa.verify(r.Context(), id, req.Code, req.RecoveryCode)
+		switch {
+		case errors.Is(err, errInvalidCode):
+			h.logger.Warnf("Invalid code to disable TOTP of user %s", id)
+			h.lockout.fail(r.Context(), account, scopeAccount)
+			h.lockout.fail(r.Context(), ipKey(h.lockout.ClientIP(r)), scopeIP)
+			return apperror.ErrInvalidCode
+		case errors.Is(err, storage.ErrNotFound):
+			return apperror.ErrNotFound
+		case err != nil:
+			h.logger.Errorf("Failed to verify MFA of user %s: %v", id, err)
+			return apperror.ErrInternalServer
+		}
+	}
+
+	if err := h.mfa.storage.DeleteTOTP(r.Context(), id); err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			h.logger.Errorf("Failed to delete TOTP of user %s: %v", id, err)
+		}
+		return apperror.FromStorage(err)
+	}
+
+	h.logger.Infof("User %s disabled TOTP of user %s", principal.ID, id)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"rest-api/internal/apperror"
+	"rest-api/internal/storage"
+	"slices"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	ID      string
+	TokenID string
+	Roles   []string
+	// MFA is set when the session passed a second factor at login.
+	MFA bool
+	// APIKey is the ID of the API key the caller authenticated with.
+	APIKey      string
+	permissions map[Permission]bool
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the caller stored by Middleware, if any.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// Middleware requires a valid access token or API key and the route policy:
+//
+//	router.GET(url, h.auth.Handle(auth.SelfOr(auth.UsersRead), h.Get))
+type Middleware struct {
+	logger  *logrus.Logger
+	tokens  *Tokens
+	storage storage.Storage
+	keys    storage.APIKeyStorage
+	roles   map[string][]Permission
+	// mfaRoles only grant their permissions to sessions that passed MFA.
+	mfaRoles map[string]bool
+}
+
+func NewMiddleware(logger *logrus.Logger, tokens *Tokens, storage storage.Storage, keys storage.APIKeyStorage, roles map[string][]Permission, mfaRoles []string) *Middleware {
+	m := &Middleware{
+		logger:   logger,
+		tokens:   tokens,
+		storage:  storage,
+		keys:     keys,
+		roles:    roles,
+		mfaRoles: make(map[string]bool, len(mfaRoles)),
+	}
+	for _, role := range mfaRoles {
+		m.mfaRoles[role] = true
+	}
+	return m
+}
+
+// KnownRole reports whether role is defined in the role table.
+func (m *Middleware) KnownRole(role string) bool {
+	_, ok := m.roles[role]
+	return ok
+}
+
+// AppHandler wraps a handler of apperror.ErrorMiddleware.
+func (m *Middleware) AppHandler(policy Policy, next func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		ctx, err := m.authorize(w, r, policy, httprouter.ParamsFromContext(r.Context()))
+		if err != nil {
+			return err
+		}
+		return next(w, r.WithContext(ctx))
+	}
+}
+
+func (m *Middleware) Handle(policy Policy, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		ctx, err := m.authorize(w, r, policy, params)
+		if err != nil {
+			apperror.Write(w, r, err)
+			return
+		}
+		next(w, r.WithContext(ctx), params)
+	}
+}
+
+func (m *Middleware) authorize(w http.ResponseWriter, r *http.Request, policy Policy, params httprouter.Params) (context.Context, error) {
+	ctx, err := m.authenticate(w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	principal, _ := PrincipalFrom(ctx)
+	if !policy(principal, params) {
+		m.logger.Warnf("User %s may not %s %s", principal.ID, r.Method, r.URL.Path)
+		return nil, apperror.ErrForbidden
+	}
+	return ctx, nil
+}
+
+// checkActive rejects accounts that are pending verification, suspended or
+// disabled, including through tokens issued while they were active.
+func checkActive(client storage.Client) error {
+	if client.Status != storage.StatusActive {
+		return fmt.Errorf("%w: %s", apperror.ErrAccountInactive, client.Status)
+	}
+	return nil
+}
+
+// authenticate verifies the token and reads the roles from the account, so
+// that deletions and role changes apply to tokens already issued.
+func (m *Middleware) authenticate(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "ApiKey") && strings.TrimSpace(token) != "" {
+		return m.authenticateKey(w, r, strings.TrimSpace(token))
+	}
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="rest-api"`)
+		w.Header().Add("WWW-Authenticate", `ApiKey realm="rest-api"`)
+		return nil, apperror.ErrUnauthorized
+	}
+
+	claims, err := m.tokens.Verify(strings.TrimSpace(token))
+	if err != nil {
+		m.logger.Warnf("Rejected access token: %v", err)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="rest-api", error="invalid_token"`)
+		return nil, apperror.ErrUnauthorized
+	}
+
+	account, err := m.storage.FindOne(r.Context(), claims.Subject)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) && !errors.Is(err, storage.ErrInvalidID) {
+			m.logger.Errorf("Failed to load principal %s: %v", claims.Subject, err)
+			return nil, apperror.FromStorage(err)
+		}
+		m.logger.Warnf("Rejected access token of missing user %s", claims.Subject)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="rest-api", error="invalid_token"`)
+		return nil, apperror.ErrUnauthorized
+	}
+	if err := checkActive(account); err != nil {
+		m.logger.Warnf("Rejected access token of %s user %s", account.Status, account.ID)
+		return nil, err
+	}
+
+	principal := Principal{
+		ID:      account.ID,
+		TokenID: claims.ID,
+		Roles:   account.Roles,
+		MFA:     slices.Contains(claims.AMR, MethodOTP),
+	}
+	principal.permissions = m.permissions(account.Roles, principal.MFA)
+	return WithPrincipal(r.Context(), principal), nil
+}
+
+// permissions returns the permissions granted by roles, leaving out roles
+// that require MFA unless mfa is set.
+func (m *Middleware) permissions(roles []string, mfa bool) map[Permission]bool {
+	granted := make(map[Permission]bool)
+	for _, role := range roles {
+		if m.mfaRoles[role] && !mfa {
+			continue
+		}
+		for _, permission := range m.roles[role] {
+			granted[permission] = true
+		}
+	}
+	return granted
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type Permission string
+
+const (
+	UsersRead    Permission = "users:read"
+	UsersWrite   Permission = "users:write"
+	AdminsManage Permission = "admins:manage"
+)
+
+// AdminRole is granted to the bootstrap admin and must exist in every role table.
+const AdminRole = "admin"
+
+var permissions = map[Permission]bool{UsersRead: true, UsersWrite: true, AdminsManage: true}
+
+// DefaultRoles is the role table used unless the configuration defines one.
+// Accounts without a role may only access their own record.
+var DefaultRoles = map[string][]Permission{
+	AdminRole: {UsersRead, UsersWrite, AdminsManage},
+	"support": {UsersRead},
+}
+
+// ParseRoles builds a role table from configuration.
+func ParseRoles(raw map[string][]string) (map[string][]Permission, error) {
+	roles := make(map[string][]Permission, len(raw))
+	for role, names := range raw {
+		for _, name := range names {
+			if !permissions[Permission(name)] {
+				return nil, fmt.Errorf("role %s: unknown permission %q", role, name)
+			}
+			roles[role] = append(roles[role], Permission(name))
+		}
+	}
+	if _, ok := roles[AdminRole]; !ok {
+		return nil, fmt.Errorf("the %s role must be defined", AdminRole)
+	}
+	return roles, nil
+}
+
+// CheckMFARoles reports mfa_roles entries missing from the role table, a
+// misspelt role would silently not require MFA.
+func CheckMFARoles(roles map[string][]Permission, mfaRoles []string) error {
+	for _, role := range mfaRoles {
+		if _, ok := roles[role]; !ok {
+			return fmt.Errorf("mfa_roles names unknown role %q", role)
+		}
+	}
+	return nil
+}
+
+// Policy decides whether principal may call a route. Every authenticated
+// route declares one in its handler's Register method.
+type Policy func(principal Principal, params httprouter.Params) bool
+
+// Require allows holders of permission.
+func Require(permission Permission) Policy {
+	return func(principal Principal, params httprouter.Params) bool {
+		return principal.Can(permission)
+	}
+}
+
+// Self allows principals to access only their own record, named by the
+// uuid route parameter.
+func Self() Policy {
+	return func(principal Principal, params httprouter.Params) bool {
+		return principal.ID == params.ByName("uuid")
+	}
+}
+
+// SelfOr allows principals to access their own record, named by the uuid
+// route parameter, and holders of permission to access any.
+func SelfOr(permission Permission) Policy {
+	return func(principal Principal, params httprouter.Params) bool {
+		return principal.ID == params.ByName("uuid") || principal.Can(permission)
+	}
+}
+
+func (p Principal) Can(permission Permission) bool {
+	return p.permissions[permission]
+}
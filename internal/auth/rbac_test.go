@@ -0,0 +1,24 @@
+package auth
+
+import "testing"
+
+func TestCheckMFARoles(t *testing.T) {
+	tests := []struct {
+		name     string
+		mfaRoles []string
+		wantErr  bool
+	}{
+		{"none", nil, false},
+		{"known", []string{AdminRole, "support"}, false},
+		{"unknown", []string{AdminRole, "admins"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckMFARoles(DefaultRoles, tt.mfaRoles)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckMFARoles(%q) error = %v, want error %t", tt.mfaRoles, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"rest-api/internal/apperror"
+	"rest-api/internal/handlers"
+	"rest-api/internal/storage"
+)
+
+type emailRequest struct {
+	Email string `json:"email"`
+}
+
+type resetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ForgotPassword mails a reset token to the owner of an email. It answers
+// 202 before the lookup so that it does not reveal which accounts exist.
+func (h *handler) ForgotPassword(w http.ResponseWriter, r *http.Request) error {
+	var req emailRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
+		return err
+	}
+	if req.Email == "" {
+		return apperror.ErrMissingRequiredFields
+	}
+	if err := h.lockout.throttleMail(r.Context(), w, r); err != nil {
+		return err
+	}
+
+	h.mailer.SendReset(req.Email)
+
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+// ResetPassword sets a new password with a reset token and ends every
+// session of the account.
+func (h *handler) ResetPassword(w http.ResponseWriter, r *http.Request) error {
+	var req resetRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
+		return err
+	}
+	if req.Token == "" || req.Password == "" {
+		return apperror.ErrMissingRequiredFields
+	}
+
+	// The token is only spent once the password passed the whole policy,
+	// including the rules on the email and username, a rejected password
+	// leaves it usable.
+	hash := HashToken(req.Token)
+	token, err := h.sessions.FindOneTimeToken(r.Context(), hash, storage.PurposePasswordReset)
+	if errors.Is(err, storage.ErrNotFound) {
+		return apperror.ErrInvalidToken
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to find password reset token: %v", err)
+		return apperror.ErrInternalServer
+	}
+
+	client, err := h.storage.FindOne(r.Context(), token.UserID)
+	if errors.Is(err, storage.ErrNotFound) {
+		h.logger.Warnf("Password reset token of missing user %s", token.UserID)
+		return apperror.ErrInvalidToken
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to load user %s: %v", token.UserID, err)
+		return apperror.FromStorage(err)
+	}
+
+	update, err := h.passwords.Client(handlers.ClientInput{
+		Client:   storage.Client{ID: client.ID, Email: client.Email, Username: client.Username},
+		Password: req.Password,
+	})
+	if err != nil {
+		return apperror.FromStorage(err)
+	}
+
+	if _, err := h.sessions.UseOneTimeToken(r.Context(), hash, storage.PurposePasswordReset); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return apperror.ErrInvalidToken
+		}
+		h.logger.Errorf("Failed to use password reset token: %v", err)
+		return apperror.ErrInternalServer
+	}
+	if _, err := h.storage.PartiallyUpdate(r.Context(), storage.Patch{ID: client.ID, Set: map[string]string{storage.FieldPassword: update.PasswordHash}}); err != nil {
+		h.logger.Errorf("Failed to store new password of user %s: %v", client.ID, err)
+		return apperror.FromStorage(err)
+	}
+
+	if _, err := h.sessions.RevokeUser(r.Context(), client.ID); err != nil {
+		h.logger.Errorf("Failed to revoke sessions of user %s after password reset: %v", client.ID, err)
+		return apperror.ErrInternalServer
+	}
+
+	h.logger.Infof("User %s reset their password", client.ID)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"rest-api/internal/storage"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// minSecretLength follows RFC 7518: an HS256 key must be at least as long as the hash.
+	minSecretLength = 32
+	// leeway absorbs clock skew between replicas.
+	leeway = 30 * time.Second
+)
+
+// TokenOptions configure the access tokens. HS256 signs with Secret, RS256
+// and EdDSA with the PEM encoded private key in PrivateKeyFile.
+type TokenOptions struct {
+	Algorithm      string
+	Secret         string
+	PrivateKeyFile string
+	KeyID          string
+	Issuer         string
+	Audience       []string
+	TTL            time.Duration
+	RefreshTTL     time.Duration
+}
+
+// Authentication methods of RFC 8176, listed in the amr claim.
+const (
+	MethodPassword = "pwd"
+	MethodOTP      = "otp"
+)
+
+type Claims struct {
+	jwt.RegisteredClaims
+	AMR []string `json:"amr,omitempty"`
+}
+
+type Tokens struct {
+	method     jwt.SigningMethod
+	signKey    any
+	verifyKey  any
+	keyID      string
+	issuer     string
+	aud        []string
+	ttl        time.Duration
+	refreshTTL time.Duration
+}
+
+func NewTokens(opts TokenOptions) (*Tokens, error) {
+	if opts.TTL <= 0 || opts.RefreshTTL <= 0 {
+		return nil, errors.New("access and refresh token TTLs must be positive")
+	}
+	t := &Tokens{keyID: opts.KeyID, issuer: opts.Issuer, aud: opts.Audience, ttl: opts.TTL, refreshTTL: opts.RefreshTTL}
+
+	switch opts.Algorithm {
+	case "HS256":
+		if len(opts.Secret) < minSecretLength {
+			return nil, fmt.Errorf("HS256 secret must be at least %d bytes", minSecretLength)
+		}
+		t.method, t.signKey, t.verifyKey = jwt.SigningMethodHS256, []byte(opts.Secret), []byte(opts.Secret)
+	case "RS256", "EdDSA":
+		pem, err := os.ReadFile(opts.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read private key: %w", err)
+		}
+		var key crypto.Signer
+		if opts.Algorithm == "RS256" {
+			t.method = jwt.SigningMethodRS256
+			key, err = jwt.ParseRSAPrivateKeyFromPEM(pem)
+		} else {
+			t.method = jwt.SigningMethodEdDSA
+			var edKey crypto.PrivateKey
+			edKey, err = jwt.ParseEdPrivateKeyFromPEM(pem)
+			key, _ = edKey.(crypto.Signer)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse %s private key: %w", opts.Algorithm, err)
+		}
+		t.signKey, t.verifyKey = key, key.Public()
+	default:
+		return nil, fmt.Errorf("unsupported token algorithm %q", opts.Algorithm)
+	}
+	return t, nil
+}
+
+// Issue returns a signed access token for client and its expiry. mfa
+// records that the session passed a second factor.
+func (t *Tokens) Issue(client storage.Client, mfa bool) (string, time.Time, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", time.Time{}, err
+	}
+
+	amr := []string{MethodPassword}
+	if mfa {
+		amr = append(amr, MethodOTP)
+	}
+
+	now := time.Now()
+	expires := now.Add(t.ttl)
+	token := jwt.NewWithClaims(t.method, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(jti),
+			Subject:   client.ID,
+			Issuer:    t.issuer,
+			Audience:  t.aud,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+		AMR: amr,
+	})
+	if t.keyID != "" {
+		token.Header["kid"] = t.keyID
+	}
+
+	signed, err := token.SignedString(t.signKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expires, nil
+}
+
+// Refresh returns a new refresh token and its record. An empty familyID
+// starts a new session.
+func (t *Tokens) Refresh(userID, familyID string, mfa bool) (string, storage.RefreshToken, error) {
+	token, err := opaqueToken()
+	if err != nil {
+		return "", storage.RefreshToken{}, err
+	}
+	if familyID == "" {
+		family := make([]byte, 16)
+		if _, err := rand.Read(family); err != nil {
+			return "", storage.RefreshToken{}, err
+		}
+		familyID = hex.EncodeToString(family)
+	}
+
+	now := time.Now().UTC()
+	return token, storage.RefreshToken{
+		Hash:      HashToken(token),
+		FamilyID:  familyID,
+		UserID:    userID,
+		MFA:       mfa,
+		CreatedAt: now,
+		ExpiresAt: now.Add(t.refreshTTL),
+	}, nil
+}
+
+// OneTime returns a new single-use token of purpose for userID, valid for
+// ttl, and the record to store for it.
+func OneTime(userID, purpose string, ttl time.Duration) (string, storage.OneTimeToken, error) {
+	token, err := opaqueToken()
+	if err != nil {
+		return "", storage.OneTimeToken{}, err
+	}
+	now := time.Now().UTC()
+	return token, storage.OneTimeToken{
+		Hash:      HashToken(token),
+		Purpose:   purpose,
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}, nil
+}
+
+func opaqueToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// HashToken returns the form an opaque token is stored and looked up in.
+// Tokens carry 256 random bits, a fast hash is enough.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks the signature, issuer, audience and lifetime of token.
+func (t *Tokens) Verify(token string) (*Claims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{t.method.Alg()}),
+		jwt.WithIssuer(t.issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+	}
+	if len(t.aud) > 0 {
+		opts = append(opts, jwt.WithAudience(t.aud[0]))
+	}
+
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return t.verifyKey, nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
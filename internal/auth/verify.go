@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"rest-api/internal/apperror"
+	"rest-api/internal/handlers"
+	"rest-api/internal/storage"
+)
+
+type verifyRequest struct {
+	Token string `json:"token"`
+}
+
+// VerifyEmail activates the account a verification token was sent to.
+func (h *handler) VerifyEmail(w http.ResponseWriter, r *http.Request) error {
+	var req verifyRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
+		return err
+	}
+	if req.Token == "" {
+		return apperror.ErrMissingRequiredFields
+	}
+
+	token, err := h.sessions.UseOneTimeToken(r.Context(), HashToken(req.Token), storage.PurposeVerifyEmail)
+	if errors.Is(err, storage.ErrNotFound) {
+		return apperror.ErrInvalidToken
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to use verification token: %v", err)
+		return apperror.ErrInternalServer
+	}
+
+	err = h.storage.SetStatus(r.Context(), token.UserID, storage.StatusPendingVerification, storage.StatusActive)
+	if errors.Is(err, storage.ErrNotFound) {
+		h.logger.Warnf("Verification token of missing user %s", token.UserID)
+		return apperror.ErrInvalidToken
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to activate user %s: %v", token.UserID, err)
+		return apperror.FromStorage(err)
+	}
+
+	h.logger.Infof("User %s verified their email", token.UserID)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// ResendVerification mails a new verification token and, like
+// ForgotPassword, answers 202 for any email.
+func (h *handler) ResendVerification(w http.ResponseWriter, r *http.Request) error {
+	var req emailRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
+		return err
+	}
+	if req.Email == "" {
+		return apperror.ErrMissingRequiredFields
+	}
+	if err := h.lockout.throttleMail(r.Context(), w, r); err != nil {
+		return err
+	}
+
+	h.mailer.ResendVerification(req.Email)
+
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"rest-api/pkg/logging"
 	"sync"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
@@ -14,11 +15,134 @@ type Config struct {
 		Address string `yaml:"address"`
 		Port    string `yaml:"port"`
 	} `yaml:"listen"`
+	Storage struct {
+		Driver string `yaml:"driver" env-default:"mongo"`
+	} `yaml:"storage"`
 	Mongo struct {
-		URI        string `yaml:"uri"`
-		Database   string `yaml:"database"`
-		Collection string `yaml:"collection"`
+		URI                string `yaml:"uri"`
+		Database           string `yaml:"database"`
+		Collection         string `yaml:"collection"`
+		SessionsCollection string `yaml:"sessions_collection" env-default:"refresh_tokens"`
+		OneTimeCollection  string `yaml:"one_time_collection" env-default:"one_time_tokens"`
+		MFACollection      string `yaml:"mfa_collection" env-default:"mfa"`
+		AttemptsCollection string `yaml:"attempts_collection" env-default:"login_attempts"`
+		APIKeysCollection  string `yaml:"api_keys_collection" env-default:"api_keys"`
 	} `yaml:"mongo"`
+	SQL struct {
+		Driver        string `yaml:"driver" env-default:"sqlite"`
+		DSN           string `yaml:"dsn" env-default:"file:rest-api.db"`
+		Table         string `yaml:"table" env-default:"users"`
+		SessionsTable string `yaml:"sessions_table" env-default:"refresh_tokens"`
+		OneTimeTable  string `yaml:"one_time_table" env-default:"one_time_tokens"`
+		MFATable      string `yaml:"mfa_table" env-default:"mfa"`
+		AttemptsTable string `yaml:"attempts_table" env-default:"login_attempts"`
+		APIKeysTable  string `yaml:"api_keys_table" env-default:"api_keys"`
+	} `yaml:"sql"`
+	SoftDelete struct {
+		// PurgeAfterDays permanently removes trashed users after that many days, 0 keeps them forever.
+		PurgeAfterDays int           `yaml:"purge_after_days" env-default:"0"`
+		PurgeInterval  time.Duration `yaml:"purge_interval" env-default:"1h"`
+	} `yaml:"soft_delete"`
+	Password struct {
+		// Algorithm of new hashes, "argon2id" or "bcrypt". Existing hashes are
+		// upgraded when their owner logs in.
+		Algorithm  string `yaml:"algorithm" env-default:"argon2id"`
+		BcryptCost int    `yaml:"bcrypt_cost" env-default:"12"`
+		Argon2     struct {
+			MemoryKiB   uint32 `yaml:"memory_kib" env-default:"65536"`
+			Iterations  uint32 `yaml:"iterations" env-default:"3"`
+			Parallelism uint8  `yaml:"parallelism" env-default:"2"`
+		} `yaml:"argon2"`
+		Policy struct {
+			MinLength     int      `yaml:"min_length" env-default:"12"`
+			MaxLength     int      `yaml:"max_length" env-default:"128"`
+			RequireUpper  bool     `yaml:"require_upper"`
+			RequireLower  bool     `yaml:"require_lower"`
+			RequireDigit  bool     `yaml:"require_digit"`
+			RequireSymbol bool     `yaml:"require_symbol"`
+			Blocklist     []string `yaml:"blocklist"`
+			// BlocklistFile holds one blocked password per line.
+			BlocklistFile string `yaml:"blocklist_file"`
+		} `yaml:"policy"`
+	} `yaml:"password"`
+	Auth struct {
+		// Algorithm is HS256, signing with Secret, or RS256 / EdDSA, signing
+		// with the PEM private key in PrivateKeyFile.
+		Algorithm      string        `yaml:"algorithm" env-default:"HS256"`
+		Secret         string        `yaml:"secret" env:"AUTH_SECRET"`
+		PrivateKeyFile string        `yaml:"private_key_file"`
+		KeyID          string        `yaml:"key_id"`
+		Issuer         string        `yaml:"issuer" env-default:"rest-api"`
+		Audience       []string      `yaml:"audience" env-default:"rest-api"`
+		AccessTTL      time.Duration `yaml:"access_ttl" env-default:"15m"`
+		// RefreshTTL is the lifetime of a refresh token. Every refresh issues
+		// a new one, so sessions idle for longer than this end.
+		RefreshTTL time.Duration `yaml:"refresh_ttl" env-default:"720h"`
+		ResetTTL   time.Duration `yaml:"reset_ttl" env-default:"1h"`
+		// ResetURL is the page that completes a password reset.
+		ResetURL string `yaml:"reset_url"`
+		// VerifyURL is the page that completes an email verification.
+		VerifyTTL time.Duration `yaml:"verify_ttl" env-default:"48h"`
+		VerifyURL string        `yaml:"verify_url"`
+	} `yaml:"auth"`
+	Notify struct {
+		// Driver is "smtp", or "file" to write messages to File, or to stdout
+		// when File is empty.
+		Driver string `yaml:"driver" env-default:"file"`
+		File   string `yaml:"file"`
+		SMTP   struct {
+			Host     string `yaml:"host"`
+			Port     int    `yaml:"port" env-default:"587"`
+			Username string `yaml:"username"`
+			Password string `yaml:"password" env:"SMTP_PASSWORD"`
+			From     string `yaml:"from"`
+		} `yaml:"smtp"`
+		// Workers send the messages, at most Queue of them wait to be sent.
+		Workers int `yaml:"workers" env-default:"2"`
+		Queue   int `yaml:"queue" env-default:"100"`
+	} `yaml:"notify"`
+	RBAC struct {
+		// Roles maps role names to permissions and replaces the built-in
+		// roles when set. It must define the "admin" role.
+		Roles map[string][]string `yaml:"roles"`
+		// BootstrapAdmin, an email or username, is granted the admin role on start.
+		BootstrapAdmin string `yaml:"bootstrap_admin"`
+		// MFARoles only grant their permissions to sessions that logged in
+		// with a second factor.
+		MFARoles []string `yaml:"mfa_roles"`
+	} `yaml:"rbac"`
+	MFA struct {
+		// Issuer names the account in authenticator apps.
+		Issuer string `yaml:"issuer" env-default:"rest-api"`
+		// Key encrypts TOTP secrets at rest, they are stored as is without it.
+		Key          string        `yaml:"key" env:"MFA_KEY"`
+		ChallengeTTL time.Duration `yaml:"challenge_ttl" env-default:"5m"`
+	} `yaml:"mfa"`
+	Lockout struct {
+		// MaxFailures consecutive failed logins lock an account, IPFailures a
+		// client IP, for LockDuration. 0 disables the lockout.
+		MaxFailures int `yaml:"max_failures" env-default:"5"`
+		IPFailures  int `yaml:"ip_failures" env-default:"50"`
+		// MailRequests password reset and verification requests of a client
+		// IP lock it out of both for LockDuration. 0 disables the lockout.
+		MailRequests int           `yaml:"mail_requests" env-default:"10"`
+		LockDuration time.Duration `yaml:"lock_duration" env-default:"15m"`
+		// Below the threshold, each failure doubles the wait before the next
+		// attempt from BaseDelay up to MaxDelay. 0 disables the backoff.
+		BaseDelay time.Duration `yaml:"base_delay" env-default:"1s"`
+		MaxDelay  time.Duration `yaml:"max_delay" env-default:"30s"`
+		// Window is how long failures are remembered after the last one.
+		Window time.Duration `yaml:"window" env-default:"24h"`
+		// TrustForwardedFor takes the client IP from X-Forwarded-For. Only
+		// enable it behind a proxy that sets the header.
+		TrustForwardedFor bool `yaml:"trust_forwarded_for"`
+	} `yaml:"lockout"`
+	Migrations struct {
+		// OnStartup is "apply" to run pending mongo migrations, "check" to refuse
+		// to start while any are pending, or "ignore".
+		OnStartup string        `yaml:"on_startup" env-default:"apply"`
+		LockTTL   time.Duration `yaml:"lock_ttl" env-default:"10m"`
+	} `yaml:"migrations"`
 }
 
 var instance *Config
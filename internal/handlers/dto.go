@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"rest-api/internal/apperror"
+	"rest-api/internal/storage"
+	"time"
+)
+
+// MaxBodyBytes bounds every request body read by Decode, a full batch
+// included.
+const MaxBodyBytes = 1 << 20
+
+// Decode reads the JSON body of r into v. Unknown fields, trailing data
+// and bodies over MaxBodyBytes are rejected.
+func Decode(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("unexpected data after the JSON body")
+	}
+	return bodyError(err)
+}
+
+func bodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &tooLarge):
+		return fmt.Errorf("%w: the limit is %d bytes", apperror.ErrBodyTooLarge, tooLarge.Limit)
+	default:
+		return fmt.Errorf("%w: %v", apperror.ErrInvalidBody, err)
+	}
+}
+
+// UserRequest is a request body that writes a user.
+type UserRequest interface {
+	Input() ClientInput
+}
+
+// CreateUserRequest is the body of POST /users and /admins, and of the
+// create and update operations of a batch.
+type CreateUserRequest struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Username string `json:"username" validate:"required,username"`
+	Password string `json:"password" validate:"required_on_create"`
+}
+
+// ReplaceUserRequest is the body of PUT. Without a password the current one
+// is kept.
+type ReplaceUserRequest CreateUserRequest
+
+func (req CreateUserRequest) Input() ClientInput {
+	return ClientInput{
+		Client:   storage.Client{Email: req.Email, Username: req.Username},
+		Password: req.Password,
+	}
+}
+
+func (req ReplaceUserRequest) Input() ClientInput { return CreateUserRequest(req).Input() }
+
+// UserResponse is a user as returned by the API.
+type UserResponse struct {
+	ID        string     `json:"id"`
+	Email     string     `json:"email"`
+	Username  string     `json:"username"`
+	Version   int64      `json:"version"`
+	Roles     []string   `json:"roles,omitempty"`
+	Status    string     `json:"status,omitempty"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	DeletedBy string     `json:"deletedBy,omitempty"`
+}
+
+func NewUserResponse(client storage.Client) UserResponse {
+	return UserResponse{
+		ID:        client.ID,
+		Email:     client.Email,
+		Username:  client.Username,
+		Version:   client.Version,
+		Roles:     client.Roles,
+		Status:    client.Status,
+		DeletedAt: client.DeletedAt,
+		DeletedBy: client.DeletedBy,
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"rest-api/internal/storage"
+	"strconv"
+	"strings"
+)
+
+// ETag renders a client version as a strong entity tag.
+func ETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// IfMatchVersion returns the version demanded by the If-Match header, or 0
+// when the header is absent or "*". A tag that can never match a stored
+// version is reported as storage.ErrVersionConflict.
+func IfMatchVersion(r *http.Request) (int64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	tag, err := strconv.Unquote(header)
+	if err != nil {
+		return 0, fmt.Errorf("%w: malformed If-Match %s", storage.ErrVersionConflict, header)
+	}
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("%w: unknown entity tag %s", storage.ErrVersionConflict, header)
+	}
+	return version, nil
+}
+
+// IfNoneMatch reports whether the If-None-Match header matches etag, using
+// the weak comparison required by RFC 9110.
+func IfNoneMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"rest-api/internal/storage"
+	"strconv"
+	"strings"
+)
+
+type ListResponse struct {
+	Items      []UserResponse `json:"items"`
+	Pagination Pagination     `json:"pagination"`
+}
+
+type Pagination struct {
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	Total      int64  `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ParseListOptions reads paging, filtering and sorting from the query string:
+//
+//	?limit=20&offset=40
+//	?limit=20&cursor=<next_cursor>
+//	?email=a@b.c&username_prefix=adm&sort=-username,email
+func ParseListOptions(query url.Values) (storage.ListOptions, error) {
+	var opts storage.ListOptions
+
+	for _, param := range []string{"limit", "offset"} {
+		raw := query.Get(param)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return opts, fmt.Errorf("%w: %s must be an integer", storage.ErrInvalidListOptions, param)
+		}
+		if param == "limit" {
+			if n < 1 {
+				return opts, fmt.Errorf("%w: limit must be positive", storage.ErrInvalidListOptions)
+			}
+			opts.Limit = n
+		} else {
+			opts.Offset = n
+		}
+	}
+
+	opts.Cursor = query.Get("cursor")
+
+	for _, field := range []string{"email", "username", "status"} {
+		if value, ok := query[field]; ok {
+			opts.Filters = append(opts.Filters, storage.Filter{Field: field, Op: storage.FilterEqual, Value: value[0]})
+		}
+		if value, ok := query[field+"_prefix"]; ok {
+			opts.Filters = append(opts.Filters, storage.Filter{Field: field, Op: storage.FilterPrefix, Value: value[0]})
+		}
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		for _, field := range strings.Split(sort, ",") {
+			desc := strings.HasPrefix(field, "-")
+			opts.Sort = append(opts.Sort, storage.SortField{Field: strings.TrimPrefix(field, "-"), Desc: desc})
+		}
+	}
+
+	return opts, opts.Validate()
+}
+
+func NewListResponse(opts storage.ListOptions, page storage.Page) ListResponse {
+	items := make([]UserResponse, len(page.Items))
+	for i, client := range page.Items {
+		items[i] = NewUserResponse(client)
+	}
+	return ListResponse{
+		Items: items,
+		Pagination: Pagination{
+			Limit:      opts.PageSize(),
+			Offset:     opts.Offset,
+			Total:      page.Total,
+			NextCursor: page.NextCursor,
+		},
+	}
+}
+
+// SetLinkHeader advertises neighbouring pages as described in RFC 8288.
+// Offset based requests get first/prev/next/last links, everything else
+// continues with the keyset cursor.
+func SetLinkHeader(w http.ResponseWriter, r *http.Request, opts storage.ListOptions, page storage.Page) {
+	limit := opts.PageSize()
+	link := func(rel string, set func(q url.Values)) string {
+		q := r.URL.Query()
+		q.Del("cursor")
+		q.Del("offset")
+		q.Set("limit", strconv.Itoa(limit))
+		set(q)
+		u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+		return fmt.Sprintf("<%s>; rel=%q", u.String(), rel)
+	}
+	atOffset := func(offset int) func(q url.Values) {
+		return func(q url.Values) {
+			if offset > 0 {
+				q.Set("offset", strconv.Itoa(offset))
+			}
+		}
+	}
+
+	var links []string
+	if r.URL.Query().Has("offset") {
+		links = append(links, link("first", atOffset(0)))
+		if opts.Offset > 0 {
+			links = append(links, link("prev", atOffset(max(opts.Offset-limit, 0))))
+		}
+		if int64(opts.Offset+limit) < page.Total {
+			links = append(links, link("next", atOffset(opts.Offset+limit)))
+		}
+		if page.Total > 0 {
+			links = append(links, link("last", atOffset(int((page.Total-1)/int64(limit))*limit)))
+		}
+	} else {
+		links = append(links, link("first", atOffset(0)))
+		if page.NextCursor != "" {
+			links = append(links, link("next", func(q url.Values) { q.Set("cursor", page.NextCursor) }))
+		}
+	}
+
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"rest-api/internal/storage"
+	"testing"
+)
+
+func TestParseListOptions(t *testing.T) {
+	tests := []struct {
+		query string
+		want  storage.ListOptions
+		err   bool
+	}{
+		{
+			query: "",
+		},
+		{
+			query: "limit=20&offset=40",
+			want:  storage.ListOptions{Limit: 20, Offset: 40},
+		},
+		{
+			query: "limit=20&cursor=abc",
+			want:  storage.ListOptions{Limit: 20, Cursor: "abc"},
+		},
+		{
+			query: "email=a@b.c&username_prefix=adm&status=active",
+			want: storage.ListOptions{Filters: []storage.Filter{
+				{Field: "email", Op: storage.FilterEqual, Value: "a@b.c"},
+				{Field: "username", Op: storage.FilterPrefix, Value: "adm"},
+				{Field: "status", Op: storage.FilterEqual, Value: "active"},
+			}},
+		},
+		{
+			query: "username=bob&username_prefix=b",
+			want: storage.ListOptions{Filters: []storage.Filter{
+				{Field: "username", Op: storage.FilterEqual, Value: "bob"},
+				{Field: "username", Op: storage.FilterPrefix, Value: "b"},
+			}},
+		},
+		{
+			query: "email=",
+			want: storage.ListOptions{Filters: []storage.Filter{
+				{Field: "email", Op: storage.FilterEqual, Value: ""},
+			}},
+		},
+		{
+			query: "sort=-username,email",
+			want: storage.ListOptions{Sort: []storage.SortField{
+				{Field: "username", Desc: true},
+				{Field: "email"},
+			}},
+		},
+		{
+			query: "role=admin",
+		},
+		{query: "limit=ten", err: true},
+		{query: "limit=0", err: true},
+		{query: "limit=-1", err: true},
+		{query: "limit=1000000", err: true},
+		{query: "offset=x", err: true},
+		{query: "offset=-1", err: true},
+		{query: "offset=10&cursor=abc", err: true},
+		{query: "sort=password", err: true},
+		{query: "sort=email,-email", err: true},
+		{query: "sort=", want: storage.ListOptions{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseListOptions(query)
+			if tt.err {
+				if !errors.Is(err, storage.ErrInvalidListOptions) {
+					t.Fatalf("ParseListOptions() error = %v, want %v", err, storage.ErrInvalidListOptions)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseListOptions() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseListOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
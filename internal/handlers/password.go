@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"rest-api/internal/storage"
+	"rest-api/pkg/password"
+)
+
+// ClientInput is a client to create or change, mapped from a UserRequest.
+// Password is write-only: it is hashed into Client.PasswordHash and never
+// stored or returned in plain text.
+type ClientInput struct {
+	storage.Client
+	Password string
+}
+
+// Passwords checks new passwords against the policy and hashes them.
+type Passwords struct {
+	Hasher *password.Hasher
+	Policy *password.Policy
+}
+
+// Client returns the client of in with its password hashed and the fields
+// managed by the server cleared. Without a password PasswordHash stays
+// empty, which storage reads as "keep the current one".
+func (p Passwords) Client(in ClientInput) (storage.Client, error) {
+	client := in.Client
+	client.PasswordHash = ""
+	client.Roles, client.Status, client.DeletedAt, client.DeletedBy = nil, "", nil, ""
+	if in.Password == "" {
+		return client, nil
+	}
+
+	if err := p.Policy.Check(in.Password, client.Email, client.Username); err != nil {
+		return client, err
+	}
+	hash, err := p.Hasher.Hash(in.Password)
+	if err != nil {
+		return client, err
+	}
+	client.PasswordHash = hash
+	return client, nil
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"rest-api/internal/apperror"
+	"rest-api/internal/storage"
+	"rest-api/pkg/jsonpatch"
+	"rest-api/pkg/validate"
+	"sort"
+)
+
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// patchAttempts bounds how often PatchUser re-applies a patch without
+// If-Match when the user changed between reading and writing it.
+const patchAttempts = 3
+
+// readOnlyMembers are the members of a UserResponse a patch may test but
+// not change.
+var readOnlyMembers = map[string]bool{
+	"id":        true,
+	"version":   true,
+	"roles":     true,
+	"status":    true,
+	"deletedAt": true,
+	"deletedBy": true,
+}
+
+// UserPatch is the body of PATCH /users/:uuid and /admins/:uuid: a merge
+// patch or a JSON Patch of the user as UserResponse renders it, which may
+// also add the write-only password member.
+type UserPatch struct {
+	merge any
+	ops   []jsonpatch.Operation
+}
+
+// DecodePatch reads the PATCH body of r by its Content-Type. Plain
+// application/json is read as a merge patch.
+func DecodePatch(w http.ResponseWriter, r *http.Request) (UserPatch, error) {
+	var patch UserPatch
+
+	mediaType := "application/json"
+	if header := r.Header.Get("Content-Type"); header != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(header); err != nil {
+			mediaType = header
+		}
+	}
+	if mediaType != "application/json" && mediaType != MergePatchType && mediaType != JSONPatchType {
+		w.Header().Set("Accept-Patch", MergePatchType+", "+JSONPatchType)
+		return patch, fmt.Errorf("%w: %s, use %s or %s", apperror.ErrUnsupportedMediaType, mediaType, MergePatchType, JSONPatchType)
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	if err != nil {
+		return patch, bodyError(err)
+	}
+	if mediaType == JSONPatchType {
+		err = jsonpatch.Unmarshal(body, &patch.ops)
+		if err == nil && patch.ops == nil {
+			err = errors.New("a JSON Patch is an array of operations")
+		}
+	} else {
+		err = jsonpatch.Unmarshal(body, &patch.merge)
+	}
+	return patch, bodyError(err)
+}
+
+// PatchUser applies patch to the user id and returns the user as stored,
+// and as it was before the patch. With version set the user must be at
+// that version, without it a patch that raced with another write is
+// applied again to the new document, up to patchAttempts times before it
+// fails with a conflict.
+func (p Passwords) PatchUser(ctx context.Context, users storage.Storage, id string, version int64, patch UserPatch) (user, previous storage.Client, err error) {
+	for attempt := 1; ; attempt++ {
+		previous, err = users.FindOne(ctx, id)
+		if err != nil {
+			return user, previous, err
+		}
+		if version > 0 && previous.Version != version {
+			return user, previous, fmt.Errorf("%w: the user is at version %d", storage.ErrVersionConflict, previous.Version)
+		}
+
+		change, err := p.Apply(patch, previous)
+		if err != nil {
+			return user, previous, err
+		}
+		user, err = users.PartiallyUpdate(ctx, change)
+		if errors.Is(err, storage.ErrVersionConflict) && version == 0 {
+			if attempt < patchAttempts {
+				continue
+			}
+			// The client sent no version that could be stale, 412 does not apply.
+			err = apperror.From(fmt.Errorf("%w: the user kept changing, retry the request", apperror.ErrConflict))
+		}
+		return user, previous, err
+	}
+}
+
+// Apply applies patch to current and returns the fields that changed. The
+// patched user is validated like the body of a PUT, members other than
+// email, username and password must be left as they are.
+func (p Passwords) Apply(patch UserPatch, current storage.Client) (storage.Patch, error) {
+	change := storage.Patch{ID: current.ID, Version: current.Version, Set: map[string]string{}}
+
+	var doc any
+	data, err := json.Marshal(NewUserResponse(current))
+	if err == nil {
+		err = jsonpatch.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return change, err
+	}
+
+	var patched any
+	if patch.ops != nil {
+		patched, err = jsonpatch.Apply(doc, patch.ops)
+	} else {
+		patched = jsonpatch.Merge(doc, patch.merge)
+	}
+	switch {
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		return change, apperror.From(fmt.Errorf("%w: %v", apperror.ErrPatchTestFailed, err))
+	case errors.Is(err, jsonpatch.ErrPathNotFound):
+		return change, apperror.From(fmt.Errorf("%w: %v", apperror.ErrPatchConflict, err))
+	case err != nil:
+		return change, apperror.From(fmt.Errorf("%w: %v", apperror.ErrInvalidBody, err))
+	}
+	result, ok := patched.(map[string]any)
+	if !ok {
+		return change, apperror.From(fmt.Errorf("%w: the patched user is not an object", apperror.ErrPatchConflict))
+	}
+
+	original := doc.(map[string]any)
+	var req ReplaceUserRequest
+	writable := map[string]*string{
+		storage.FieldEmail:    &req.Email,
+		storage.FieldUsername: &req.Username,
+		storage.FieldPassword: &req.Password,
+	}
+
+	var fields []apperror.FieldError
+	for _, name := range memberNames(original, result) {
+		before, had := original[name]
+		after, has := result[name]
+		if target, ok := writable[name]; ok {
+			if s, ok := after.(string); ok {
+				*target = s
+			} else if has {
+				fields = append(fields, apperror.FieldError{Field: name, Rule: "type", Message: "must be a string"})
+			}
+			continue
+		}
+		if had == has && jsonpatch.Equal(before, after) {
+			continue
+		}
+		if had || readOnlyMembers[name] {
+			fields = append(fields, apperror.FieldError{Field: name, Rule: "read_only", Message: "can not be changed"})
+		} else {
+			fields = append(fields, apperror.FieldError{Field: name, Rule: "unknown", Message: "is not a field of a user"})
+		}
+	}
+	if err := p.Validate(req, validate.Replace); err != nil {
+		var invalid *apperror.AppError
+		if !errors.As(err, &invalid) || len(invalid.Fields) == 0 {
+			return change, err
+		}
+		fields = append(fields, invalid.Fields...)
+	}
+	if len(fields) > 0 {
+		return change, apperror.Invalid(fields)
+	}
+
+	for _, field := range []string{storage.FieldEmail, storage.FieldUsername} {
+		if value := *writable[field]; value != original[field] {
+			change.Set[field] = value
+		}
+	}
+	if req.Password != "" {
+		hash, err := p.Hasher.Hash(req.Password)
+		if err != nil {
+			return change, err
+		}
+		change.Set[storage.FieldPassword] = hash
+	}
+	return change, nil
+}
+
+// memberNames returns the member names of a and b, sorted.
+func memberNames(a, b map[string]any) []string {
+	var names []string
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"rest-api/internal/apperror"
+	"rest-api/internal/storage"
+	"testing"
+)
+
+// racingStorage loses every conditional write to a concurrent one.
+type racingStorage struct {
+	storage.Storage
+	user    storage.Client
+	updates int
+}
+
+func (s *racingStorage) FindOne(ctx context.Context, id string) (storage.Client, error) {
+	return s.user, nil
+}
+
+func (s *racingStorage) PartiallyUpdate(ctx context.Context, patch storage.Patch) (storage.Client, error) {
+	s.updates++
+	s.user.Version++
+	return storage.Client{}, storage.ErrVersionConflict
+}
+
+func TestPatchUserRace(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int64
+		status  int
+		updates int
+	}{
+		{"without If-Match", 0, http.StatusConflict, patchAttempts},
+		{"with If-Match", 1, http.StatusPreconditionFailed, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &racingStorage{user: storage.Client{ID: "1", Email: "bob@example.com", Username: "bob", Version: 1}}
+			patch := UserPatch{merge: map[string]any{"username": "bobby"}}
+
+			_, _, err := Passwords{}.PatchUser(context.Background(), users, "1", tt.version, patch)
+			if got := apperror.From(apperror.FromStorage(err)).Status; got != tt.status {
+				t.Errorf("PatchUser() error = %v, status %d, want %d", err, got, tt.status)
+			}
+			if users.updates != tt.updates {
+				t.Errorf("PatchUser() tried %d updates, want %d", users.updates, tt.updates)
+			}
+		})
+	}
+}
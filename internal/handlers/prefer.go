@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"rest-api/internal/storage"
+	"strings"
+)
+
+// PreferRepresentation reports whether the Prefer header (RFC 7240) asks
+// for return=representation.
+func PreferRepresentation(r *http.Request) bool {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, preference := range strings.Split(header, ",") {
+			preference, _, _ = strings.Cut(preference, ";")
+			name, value, _ := strings.Cut(preference, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "return") &&
+				strings.EqualFold(strings.Trim(strings.TrimSpace(value), `"`), "representation") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteUpdated answers a PUT or PATCH that stored user with its new ETag,
+// and with the user itself when the request prefers return=representation.
+// Otherwise there is no content.
+func WriteUpdated(w http.ResponseWriter, r *http.Request, user storage.Client) error {
+	w.Header().Set("ETag", ETag(user.Version))
+	if !PreferRepresentation(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	w.Header().Set("Preference-Applied", "return=representation")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(NewUserResponse(user))
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rest-api/internal/storage"
+	"testing"
+)
+
+func TestPreferRepresentation(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []string
+		want   bool
+	}{
+		{"none", nil, false},
+		{"representation", []string{"return=representation"}, true},
+		{"minimal", []string{"return=minimal"}, false},
+		{"case and spaces", []string{" Return = Representation "}, true},
+		{"quoted", []string{`return="representation"`}, true},
+		{"with parameters", []string{"return=representation; foo=bar"}, true},
+		{"among others", []string{"respond-async, return=representation, wait=10"}, true},
+		{"second header", []string{"respond-async", "return=representation"}, true},
+		{"other preference", []string{"handling=representation"}, false},
+		{"prefix of a name", []string{"returns=representation"}, false},
+		{"no value", []string{"return"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/users/1", nil)
+			for _, value := range tt.header {
+				r.Header.Add("Prefer", value)
+			}
+			if got := PreferRepresentation(r); got != tt.want {
+				t.Errorf("PreferRepresentation(%q) = %t, want %t", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteUpdated(t *testing.T) {
+	user := storage.Client{ID: "1", Email: "bob@example.com", Username: "bob", PasswordHash: "secret", Version: 3, Status: storage.StatusActive}
+
+	t.Run("minimal", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		if err := WriteUpdated(w, httptest.NewRequest(http.MethodPut, "/users/1", nil), user); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusNoContent {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusNoContent)
+		}
+		if got := w.Header().Get("ETag"); got != ETag(3) {
+			t.Errorf("ETag = %q, want %q", got, ETag(3))
+		}
+		if w.Header().Get("Preference-Applied") != "" || w.Body.Len() != 0 {
+			t.Errorf("unexpected representation %q", w.Body.String())
+		}
+	})
+
+	t.Run("representation", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPut, "/users/1", nil)
+		r.Header.Set("Prefer", "return=representation")
+		if err := WriteUpdated(w, r, user); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("ETag"); got != ETag(3) {
+			t.Errorf("ETag = %q, want %q", got, ETag(3))
+		}
+		if got := w.Header().Get("Preference-Applied"); got != "return=representation" {
+			t.Errorf("Preference-Applied = %q", got)
+		}
+		var body map[string]any
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body["email"] != user.Email || body["username"] != user.Username {
+			t.Errorf("body = %v", body)
+		}
+		if _, ok := body["password"]; ok {
+			t.Errorf("body contains the password: %v", body)
+		}
+	})
+}
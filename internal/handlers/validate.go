@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"errors"
+	"rest-api/internal/apperror"
+	"rest-api/pkg/password"
+	"rest-api/pkg/validate"
+)
+
+// Validate checks req against the rules of mode and the password policy and
+// returns every violation at once, as a 422 apperror.AppError.
+func (p Passwords) Validate(req UserRequest, mode validate.Mode) error {
+	var fields []apperror.FieldError
+	for _, v := range validate.Struct(req, mode) {
+		fields = append(fields, apperror.FieldError{Field: v.Field, Rule: v.Rule, Message: v.Message})
+	}
+
+	if in := req.Input(); in.Password != "" {
+		err := p.Policy.Check(in.Password, in.Email, in.Username)
+		var policy *password.PolicyError
+		if errors.As(err, &policy) {
+			for _, violation := range policy.Violations {
+				fields = append(fields, apperror.FieldError{Field: "password", Rule: "policy", Message: "requires " + violation})
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if len(fields) > 0 {
+		return apperror.Invalid(fields)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"rest-api/internal/storage"
+	"strings"
+)
+
+// Verifier starts the email verification of a client that signed up on its
+// own or changed its email. It returns immediately, delivery happens in the
+// background.
+type Verifier interface {
+	SendVerification(client storage.Client)
+}
+
+// Reverify starts the verification of the new email of a client an update
+// left pending, see storage.StatusAfterEmailChange. before is the client
+// as it was read ahead of the update.
+func Reverify(verifier Verifier, before, after storage.Client) {
+	if after.Status == storage.StatusPendingVerification && !strings.EqualFold(before.Email, after.Email) {
+		verifier.SendVerification(after)
+	}
+}
@@ -0,0 +1,67 @@
+package lockout
+
+import (
+	"context"
+	"rest-api/internal/storage"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryStorage keeps failed login counts in process memory and mirrors
+// the behaviour of MongoStorage.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	attempts map[string]storage.LoginAttempts
+	logger   *logrus.Logger
+}
+
+func NewMemoryStorage(logger *logrus.Logger) *MemoryStorage {
+	logger.Info("Initializing lockout MemoryStorage")
+	return &MemoryStorage{
+		attempts: make(map[string]storage.LoginAttempts),
+		logger:   logger,
+	}
+}
+
+func (s *MemoryStorage) GetAttempts(ctx context.Context, key string) (storage.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.attempts[key]
+	if !ok || !attempts.ExpiresAt.After(time.Now()) {
+		return storage.LoginAttempts{Key: key}, nil
+	}
+	return attempts, nil
+}
+
+func (s *MemoryStorage) RecordFailure(ctx context.Context, key string, expiresAt time.Time) (storage.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	// Without a TTL index, expired counts are dropped on every failure.
+	for k, a := range s.attempts {
+		if !a.ExpiresAt.After(now) {
+			delete(s.attempts, k)
+		}
+	}
+
+	attempts := s.attempts[key]
+	attempts.Key = key
+	attempts.Failures++
+	attempts.LastFailure = now
+	attempts.ExpiresAt = expiresAt.UTC()
+	s.attempts[key] = attempts
+	return attempts, nil
+}
+
+func (s *MemoryStorage) ResetAttempts(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.attempts[key]
+	delete(s.attempts, key)
+	return ok, nil
+}
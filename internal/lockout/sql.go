@@ -0,0 +1,85 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"rest-api/internal/storage"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type SQLStorage struct {
+	db     *sql.DB
+	table  string
+	logger *logrus.Logger
+}
+
+func NewSQLStorage(ctx context.Context, db *sql.DB, table string, logger *logrus.Logger) (*SQLStorage, error) {
+	logger.Infof("Initializing lockout SQLStorage for table: %s", table)
+
+	s := &SQLStorage{
+		db:     db,
+		table:  `"` + strings.ReplaceAll(table, `"`, `""`) + `"`,
+		logger: logger,
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		key          TEXT PRIMARY KEY,
+		failures     INTEGER NOT NULL,
+		last_failure TIMESTAMP NOT NULL,
+		expires_at   TIMESTAMP NOT NULL
+	)`, s.table))
+	if err != nil {
+		logger.Errorf("Failed to bootstrap schema: %v", err)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLStorage) GetAttempts(ctx context.Context, key string) (storage.LoginAttempts, error) {
+	attempts := storage.LoginAttempts{Key: key}
+	err := s.db.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT failures, last_failure, expires_at FROM %s WHERE key = ? AND expires_at > ?`, s.table),
+		key, time.Now().UTC(),
+	).Scan(&attempts.Failures, &attempts.LastFailure, &attempts.ExpiresAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		s.logger.Errorf("Failed to get login attempts: %v", err)
+		return attempts, err
+	}
+	return attempts, nil
+}
+
+func (s *SQLStorage) RecordFailure(ctx context.Context, key string, expiresAt time.Time) (storage.LoginAttempts, error) {
+	now := time.Now().UTC()
+
+	// Without a TTL index, expired counts are dropped on every failure.
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE expires_at <= ?`, s.table), now); err != nil {
+		s.logger.Warnf("Failed to delete expired login attempts: %v", err)
+	}
+
+	attempts := storage.LoginAttempts{Key: key, LastFailure: now, ExpiresAt: expiresAt.UTC()}
+	err := s.db.QueryRowContext(ctx,
+		fmt.Sprintf(`INSERT INTO %s (key, failures, last_failure, expires_at) VALUES (?, 1, ?, ?)
+			ON CONFLICT (key) DO UPDATE SET failures = failures + 1, last_failure = excluded.last_failure, expires_at = excluded.expires_at
+			RETURNING failures`, s.table),
+		key, attempts.LastFailure, attempts.ExpiresAt,
+	).Scan(&attempts.Failures)
+	if err != nil {
+		s.logger.Errorf("Failed to record login failure: %v", err)
+		return attempts, err
+	}
+	return attempts, nil
+}
+
+func (s *SQLStorage) ResetAttempts(ctx context.Context, key string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE key = ?`, s.table), key)
+	if err != nil {
+		s.logger.Errorf("Failed to reset login attempts: %v", err)
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
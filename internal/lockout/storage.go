@@ -0,0 +1,69 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"rest-api/internal/storage"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStorage keeps failed login counts in their own collection. Expired
+// counts are removed by the TTL index created in the migrations.
+type MongoStorage struct {
+	collection *mongo.Collection
+	logger     *logrus.Logger
+}
+
+func NewMongoStorage(client *mongo.Client, dbName, collectionName string, logger *logrus.Logger) *MongoStorage {
+	logger.Infof("Initializing lockout MongoStorage for database: %s, collection: %s", dbName, collectionName)
+	return &MongoStorage{
+		collection: client.Database(dbName).Collection(collectionName),
+		logger:     logger,
+	}
+}
+
+func (s *MongoStorage) GetAttempts(ctx context.Context, key string) (storage.LoginAttempts, error) {
+	attempts := storage.LoginAttempts{Key: key}
+	err := s.collection.FindOne(ctx, bson.M{"_id": key, "expiresAt": bson.M{"$gt": time.Now().UTC()}}).Decode(&attempts)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		s.logger.Errorf("Failed to get login attempts: %v", err)
+		return attempts, err
+	}
+	return attempts, nil
+}
+
+func (s *MongoStorage) RecordFailure(ctx context.Context, key string, expiresAt time.Time) (storage.LoginAttempts, error) {
+	now := time.Now().UTC()
+
+	// The TTL monitor runs once a minute, an expired count may still be there.
+	if _, err := s.collection.DeleteOne(ctx, bson.M{"_id": key, "expiresAt": bson.M{"$lte": now}}); err != nil {
+		s.logger.Errorf("Failed to delete expired login attempts: %v", err)
+		return storage.LoginAttempts{}, err
+	}
+
+	var attempts storage.LoginAttempts
+	err := s.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{"$inc": bson.M{"failures": 1}, "$set": bson.M{"lastFailure": now, "expiresAt": expiresAt.UTC()}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempts)
+	if err != nil {
+		s.logger.Errorf("Failed to record login failure: %v", err)
+		return attempts, err
+	}
+	return attempts, nil
+}
+
+func (s *MongoStorage) ResetAttempts(ctx context.Context, key string) (bool, error) {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": key})
+	if err != nil {
+		s.logger.Errorf("Failed to reset login attempts: %v", err)
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
@@ -0,0 +1,77 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"rest-api/internal/storage"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+func backends(t *testing.T) map[string]storage.AttemptStorage {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	sqlStorage, err := NewSQLStorage(context.Background(), db, "login_attempts", logger)
+	if err != nil {
+		t.Fatalf("NewSQLStorage() error = %v", err)
+	}
+
+	return map[string]storage.AttemptStorage{
+		"memory": NewMemoryStorage(logger),
+		"sql":    sqlStorage,
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			expires := time.Now().Add(time.Hour)
+			for want := 1; want <= 3; want++ {
+				attempts, err := s.RecordFailure(ctx, "user:1", expires)
+				if err != nil || attempts.Failures != want {
+					t.Fatalf("RecordFailure() = %+v, %v, want %d failures", attempts, err, want)
+				}
+			}
+			if attempts, err := s.GetAttempts(ctx, "user:1"); err != nil || attempts.Failures != 3 || attempts.LastFailure.IsZero() {
+				t.Errorf("GetAttempts() = %+v, %v, want 3 failures", attempts, err)
+			}
+			if attempts, err := s.GetAttempts(ctx, "user:2"); err != nil || attempts.Failures != 0 {
+				t.Errorf("GetAttempts() of another key = %+v, %v, want none", attempts, err)
+			}
+
+			if reset, err := s.ResetAttempts(ctx, "user:1"); err != nil || !reset {
+				t.Errorf("ResetAttempts() = %t, %v, want true", reset, err)
+			}
+			if reset, err := s.ResetAttempts(ctx, "user:1"); err != nil || reset {
+				t.Errorf("second ResetAttempts() = %t, %v, want false", reset, err)
+			}
+			if attempts, _ := s.GetAttempts(ctx, "user:1"); attempts.Failures != 0 {
+				t.Errorf("GetAttempts() after the reset = %+v", attempts)
+			}
+
+			// Expired failures no longer count.
+			if _, err := s.RecordFailure(ctx, "ip:1", time.Now().Add(-time.Second)); err != nil {
+				t.Fatalf("RecordFailure() error = %v", err)
+			}
+			if attempts, _ := s.GetAttempts(ctx, "ip:1"); attempts.Failures != 0 {
+				t.Errorf("GetAttempts() of expired failures = %+v", attempts)
+			}
+			if attempts, _ := s.RecordFailure(ctx, "ip:1", expires); attempts.Failures != 1 {
+				t.Errorf("RecordFailure() after expiry = %+v, want the count restarted", attempts)
+			}
+		})
+	}
+}
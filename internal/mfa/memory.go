@@ -0,0 +1,112 @@
+package mfa
+
+import (
+	"context"
+	"rest-api/internal/storage"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryStorage keeps TOTP enrollments in process memory and mirrors the
+// behaviour of MongoStorage.
+type MemoryStorage struct {
+	mu     sync.Mutex
+	totps  map[string]storage.TOTP
+	logger *logrus.Logger
+}
+
+func NewMemoryStorage(logger *logrus.Logger) *MemoryStorage {
+	logger.Info("Initializing MFA MemoryStorage")
+	return &MemoryStorage{
+		totps:  make(map[string]storage.TOTP),
+		logger: logger,
+	}
+}
+
+func (s *MemoryStorage) CreateTOTP(ctx context.Context, totp storage.TOTP) error {
+	s.logger.Infof("Creating TOTP enrollment for user %s", totp.UserID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.totps[totp.UserID]; ok && current.ConfirmedAt != nil {
+		s.logger.Warnf("User %s already has a confirmed TOTP enrollment", totp.UserID)
+		return storage.ErrDuplicateKey
+	}
+	s.totps[totp.UserID] = totp
+	return nil
+}
+
+func (s *MemoryStorage) FindTOTP(ctx context.Context, userID string) (storage.TOTP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totps[userID]
+	if !ok {
+		return totp, storage.ErrNotFound
+	}
+	return totp, nil
+}
+
+func (s *MemoryStorage) ConfirmTOTP(ctx context.Context, userID string, step int64, recoveryCodes []string) error {
+	s.logger.Infof("Confirming TOTP enrollment of user %s", userID)
+	return s.update(userID, func(totp *storage.TOTP) bool {
+		if totp.ConfirmedAt != nil {
+			return false
+		}
+		now := time.Now().UTC()
+		totp.ConfirmedAt, totp.LastStep, totp.RecoveryCodes = &now, step, slices.Clone(recoveryCodes)
+		return true
+	})
+}
+
+func (s *MemoryStorage) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	return s.update(userID, func(totp *storage.TOTP) bool {
+		if totp.ConfirmedAt == nil || totp.LastStep >= step {
+			return false
+		}
+		totp.LastStep = step
+		return true
+	})
+}
+
+func (s *MemoryStorage) UseRecoveryCode(ctx context.Context, userID, hash string) error {
+	s.logger.Infof("Using a recovery code of user %s", userID)
+	return s.update(userID, func(totp *storage.TOTP) bool {
+		i := slices.Index(totp.RecoveryCodes, hash)
+		if totp.ConfirmedAt == nil || i < 0 {
+			return false
+		}
+		totp.RecoveryCodes = slices.Delete(slices.Clone(totp.RecoveryCodes), i, i+1)
+		return true
+	})
+}
+
+func (s *MemoryStorage) DeleteTOTP(ctx context.Context, userID string) error {
+	s.logger.Infof("Deleting TOTP enrollment of user %s", userID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.totps[userID]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(s.totps, userID)
+	return nil
+}
+
+// update stores the enrollment of userID when change accepts it.
+func (s *MemoryStorage) update(userID string, change func(totp *storage.TOTP) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totps[userID]
+	if !ok || !change(&totp) {
+		return storage.ErrNotFound
+	}
+	s.totps[userID] = totp
+	return nil
+}
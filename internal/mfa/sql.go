@@ -0,0 +1,177 @@
+package mfa
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rest-api/internal/storage"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type SQLStorage struct {
+	db     *sql.DB
+	table  string
+	logger *logrus.Logger
+}
+
+func NewSQLStorage(ctx context.Context, db *sql.DB, table string, logger *logrus.Logger) (*SQLStorage, error) {
+	logger.Infof("Initializing MFA SQLStorage for table: %s", table)
+
+	s := &SQLStorage{
+		db:     db,
+		table:  `"` + strings.ReplaceAll(table, `"`, `""`) + `"`,
+		logger: logger,
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		user_id        TEXT PRIMARY KEY,
+		secret         TEXT NOT NULL,
+		created_at     TIMESTAMP NOT NULL,
+		confirmed_at   TIMESTAMP NULL,
+		recovery_codes TEXT NOT NULL DEFAULT '[]',
+		last_step      INTEGER NOT NULL DEFAULT 0
+	)`, s.table))
+	if err != nil {
+		logger.Errorf("Failed to bootstrap schema: %v", err)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLStorage) CreateTOTP(ctx context.Context, totp storage.TOTP) error {
+	s.logger.Infof("Creating TOTP enrollment for user %s", totp.UserID)
+
+	result, err := s.db.ExecContext(ctx,
+		fmt.Sprintf(`INSERT INTO %s (user_id, secret, created_at) VALUES (?, ?, ?)
+			ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, created_at = excluded.created_at
+			WHERE confirmed_at IS NULL`, s.table),
+		totp.UserID, totp.Secret, totp.CreatedAt.UTC(),
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to create TOTP enrollment: %v", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		s.logger.Warnf("User %s already has a confirmed TOTP enrollment", totp.UserID)
+		return storage.ErrDuplicateKey
+	}
+	return nil
+}
+
+func (s *SQLStorage) FindTOTP(ctx context.Context, userID string) (storage.TOTP, error) {
+	totp := storage.TOTP{UserID: userID}
+	var (
+		confirmedAt sql.NullTime
+		codes       string
+	)
+	err := s.db.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT secret, created_at, confirmed_at, recovery_codes, last_step FROM %s WHERE user_id = ?`, s.table),
+		userID,
+	).Scan(&totp.Secret, &totp.CreatedAt, &confirmedAt, &codes, &totp.LastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return totp, storage.ErrNotFound
+		}
+		s.logger.Errorf("Failed to find TOTP enrollment: %v", err)
+		return totp, err
+	}
+	if confirmedAt.Valid {
+		totp.ConfirmedAt = &confirmedAt.Time
+	}
+	totp.RecoveryCodes = decodeCodes(codes)
+	return totp, nil
+}
+
+func (s *SQLStorage) ConfirmTOTP(ctx context.Context, userID string, step int64, recoveryCodes []string) error {
+	s.logger.Infof("Confirming TOTP enrollment of user %s", userID)
+	return s.exec(ctx,
+		fmt.Sprintf(`UPDATE %s SET confirmed_at = ?, last_step = ?, recovery_codes = ? WHERE user_id = ? AND confirmed_at IS NULL`, s.table),
+		time.Now().UTC(), step, encodeCodes(recoveryCodes), userID,
+	)
+}
+
+func (s *SQLStorage) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	return s.exec(ctx,
+		fmt.Sprintf(`UPDATE %s SET last_step = ? WHERE user_id = ? AND confirmed_at IS NOT NULL AND last_step < ?`, s.table),
+		step, userID, step,
+	)
+}
+
+func (s *SQLStorage) UseRecoveryCode(ctx context.Context, userID, hash string) error {
+	s.logger.Infof("Using a recovery code of user %s", userID)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var codes string
+	err = tx.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT recovery_codes FROM %s WHERE user_id = ? AND confirmed_at IS NOT NULL`, s.table),
+		userID,
+	).Scan(&codes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrNotFound
+	}
+	if err != nil {
+		s.logger.Errorf("Failed to load recovery codes: %v", err)
+		return err
+	}
+
+	remaining := decodeCodes(codes)
+	i := slices.Index(remaining, hash)
+	if i < 0 {
+		return storage.ErrNotFound
+	}
+	remaining = slices.Delete(remaining, i, i+1)
+	_, err = tx.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE %s SET recovery_codes = ? WHERE user_id = ?`, s.table),
+		encodeCodes(remaining), userID,
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to use recovery code: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStorage) DeleteTOTP(ctx context.Context, userID string) error {
+	s.logger.Infof("Deleting TOTP enrollment of user %s", userID)
+	return s.exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE user_id = ?`, s.table), userID)
+}
+
+// exec runs a statement that must change one row.
+func (s *SQLStorage) exec(ctx context.Context, query string, args ...any) error {
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		s.logger.Errorf("Failed to update TOTP enrollment: %v", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// Recovery code hashes are stored as a JSON array in a TEXT column.
+func encodeCodes(codes []string) string {
+	if len(codes) == 0 {
+		return "[]"
+	}
+	raw, _ := json.Marshal(codes)
+	return string(raw)
+}
+
+func decodeCodes(raw string) []string {
+	var codes []string
+	if err := json.Unmarshal([]byte(raw), &codes); err != nil || len(codes) == 0 {
+		return nil
+	}
+	return codes
+}
@@ -0,0 +1,109 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"rest-api/internal/storage"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStorage keeps one TOTP enrollment per user, keyed by the user id.
+type MongoStorage struct {
+	collection *mongo.Collection
+	logger     *logrus.Logger
+}
+
+func NewMongoStorage(client *mongo.Client, dbName, collectionName string, logger *logrus.Logger) *MongoStorage {
+	logger.Infof("Initializing MFA MongoStorage for database: %s, collection: %s", dbName, collectionName)
+	return &MongoStorage{
+		collection: client.Database(dbName).Collection(collectionName),
+		logger:     logger,
+	}
+}
+
+func (s *MongoStorage) CreateTOTP(ctx context.Context, totp storage.TOTP) error {
+	s.logger.Infof("Creating TOTP enrollment for user %s", totp.UserID)
+
+	// A confirmed enrollment does not match, the upsert then collides on _id.
+	_, err := s.collection.ReplaceOne(ctx,
+		bson.M{"_id": totp.UserID, "confirmedAt": nil},
+		totp,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			s.logger.Warnf("User %s already has a confirmed TOTP enrollment", totp.UserID)
+			return storage.ErrDuplicateKey
+		}
+		s.logger.Errorf("Failed to create TOTP enrollment: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *MongoStorage) FindTOTP(ctx context.Context, userID string) (storage.TOTP, error) {
+	var totp storage.TOTP
+	err := s.collection.FindOne(ctx, bson.M{"_id": userID}).Decode(&totp)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return totp, storage.ErrNotFound
+		}
+		s.logger.Errorf("Failed to find TOTP enrollment: %v", err)
+		return totp, err
+	}
+	return totp, nil
+}
+
+func (s *MongoStorage) ConfirmTOTP(ctx context.Context, userID string, step int64, recoveryCodes []string) error {
+	s.logger.Infof("Confirming TOTP enrollment of user %s", userID)
+	return s.update(ctx,
+		bson.M{"_id": userID, "confirmedAt": nil},
+		bson.M{"$set": bson.M{"confirmedAt": time.Now().UTC(), "lastStep": step, "recoveryCodes": recoveryCodes}},
+	)
+}
+
+func (s *MongoStorage) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	return s.update(ctx,
+		bson.M{"_id": userID, "confirmedAt": bson.M{"$ne": nil}, "lastStep": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"lastStep": step}},
+	)
+}
+
+func (s *MongoStorage) UseRecoveryCode(ctx context.Context, userID, hash string) error {
+	s.logger.Infof("Using a recovery code of user %s", userID)
+	return s.update(ctx,
+		bson.M{"_id": userID, "confirmedAt": bson.M{"$ne": nil}, "recoveryCodes": hash},
+		bson.M{"$pull": bson.M{"recoveryCodes": hash}},
+	)
+}
+
+func (s *MongoStorage) DeleteTOTP(ctx context.Context, userID string) error {
+	s.logger.Infof("Deleting TOTP enrollment of user %s", userID)
+
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": userID})
+	if err != nil {
+		s.logger.Errorf("Failed to delete TOTP enrollment: %v", err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *MongoStorage) update(ctx context.Context, filter, update bson.M) error {
+	result, err := s.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		s.logger.Errorf("Failed to update TOTP enrollment: %v", err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
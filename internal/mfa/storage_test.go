@@ -0,0 +1,97 @@
+package mfa
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"rest-api/internal/storage"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+func backends(t *testing.T) map[string]storage.MFAStorage {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	sqlStorage, err := NewSQLStorage(context.Background(), db, "mfa", logger)
+	if err != nil {
+		t.Fatalf("NewSQLStorage() error = %v", err)
+	}
+
+	return map[string]storage.MFAStorage{
+		"memory": NewMemoryStorage(logger),
+		"sql":    sqlStorage,
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now().UTC()
+			if err := s.UseTOTPStep(ctx, "u1", 1); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("UseTOTPStep() without enrollment error = %v, want %v", err, storage.ErrNotFound)
+			}
+
+			if err := s.CreateTOTP(ctx, storage.TOTP{UserID: "u1", Secret: "first", CreatedAt: now}); err != nil {
+				t.Fatalf("CreateTOTP() error = %v", err)
+			}
+			if err := s.CreateTOTP(ctx, storage.TOTP{UserID: "u1", Secret: "second", CreatedAt: now}); err != nil {
+				t.Fatalf("CreateTOTP() replacing a pending enrollment error = %v", err)
+			}
+			if err := s.UseTOTPStep(ctx, "u1", 1); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("UseTOTPStep() before confirmation error = %v, want %v", err, storage.ErrNotFound)
+			}
+
+			if err := s.ConfirmTOTP(ctx, "u1", 10, []string{"r1", "r2"}); err != nil {
+				t.Fatalf("ConfirmTOTP() error = %v", err)
+			}
+			if err := s.ConfirmTOTP(ctx, "u1", 11, nil); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("second ConfirmTOTP() error = %v, want %v", err, storage.ErrNotFound)
+			}
+			if err := s.CreateTOTP(ctx, storage.TOTP{UserID: "u1", Secret: "third", CreatedAt: now}); !errors.Is(err, storage.ErrDuplicateKey) {
+				t.Errorf("CreateTOTP() over a confirmed enrollment error = %v, want %v", err, storage.ErrDuplicateKey)
+			}
+			totp, err := s.FindTOTP(ctx, "u1")
+			if err != nil || totp.Secret != "second" || totp.ConfirmedAt == nil || len(totp.RecoveryCodes) != 2 {
+				t.Errorf("FindTOTP() = %+v, %v", totp, err)
+			}
+
+			// A step is accepted once, earlier steps never again.
+			if err := s.UseTOTPStep(ctx, "u1", 10); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("UseTOTPStep() of the confirming step error = %v, want %v", err, storage.ErrNotFound)
+			}
+			if err := s.UseTOTPStep(ctx, "u1", 12); err != nil {
+				t.Errorf("UseTOTPStep() error = %v", err)
+			}
+			if err := s.UseTOTPStep(ctx, "u1", 11); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("UseTOTPStep() of an earlier step error = %v, want %v", err, storage.ErrNotFound)
+			}
+
+			if err := s.UseRecoveryCode(ctx, "u1", "r1"); err != nil {
+				t.Errorf("UseRecoveryCode() error = %v", err)
+			}
+			if err := s.UseRecoveryCode(ctx, "u1", "r1"); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("second UseRecoveryCode() error = %v, want %v", err, storage.ErrNotFound)
+			}
+
+			if err := s.DeleteTOTP(ctx, "u1"); err != nil {
+				t.Fatalf("DeleteTOTP() error = %v", err)
+			}
+			if _, err := s.FindTOTP(ctx, "u1"); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("FindTOTP() after DeleteTOTP() error = %v, want %v", err, storage.ErrNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"rest-api/internal/storage"
+	"rest-api/pkg/migrate"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexNotFoundCode is the server error code of dropping a missing index.
+const indexNotFoundCode = 27
+
+// All returns the migrations of every collection. Append new ones at the
+// end, never edit one that has shipped.
+func All(collection, sessions, oneTime, attempts, apiKeys string) []migrate.Migration {
+	return []migrate.Migration{
+		{
+			Version:     1,
+			Description: "unique case-insensitive indexes on email and username",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				collation := &options.Collation{Locale: "en", Strength: 2}
+				_, err := db.Collection(collection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{
+						Keys:    bson.D{{Key: "email", Value: 1}},
+						Options: options.Index().SetName("email_unique").SetUnique(true).SetCollation(collation),
+					},
+					{
+						Keys:    bson.D{{Key: "username", Value: 1}},
+						Options: options.Index().SetName("username_unique").SetUnique(true).SetCollation(collation),
+					},
+				})
+				return err
+			},
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				return dropIndexes(ctx, db.Collection(collection), "email_unique", "username_unique")
+			},
+		},
+		{
+			Version:     2,
+			Description: "backfill version of documents created before optimistic locking",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				_, err := db.Collection(collection).UpdateMany(ctx,
+					bson.M{"version": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"version": int64(1)}},
+				)
+				return err
+			},
+			// The backfilled documents can not be told apart, and a version
+			// field is harmless to older code.
+			Down: func(ctx context.Context, db *mongo.Database) error { return nil },
+		},
+		{
+			Version:     3,
+			Description: "refresh token expiry, family and user indexes",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				_, err := db.Collection(sessions).Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{
+						Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+						Options: options.Index().SetName("expires_ttl").SetExpireAfterSeconds(0),
+					},
+					{
+						Keys:    bson.D{{Key: "familyId", Value: 1}},
+						Options: options.Index().SetName("family"),
+					},
+					{
+						Keys:    bson.D{{Key: "userId", Value: 1}},
+						Options: options.Index().SetName("user"),
+					},
+				})
+				return err
+			},
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				return dropIndexes(ctx, db.Collection(sessions), "expires_ttl", "family", "user")
+			},
+		},
+		{
+			Version:     4,
+			Description: "one-time token expiry and user indexes",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				_, err := db.Collection(oneTime).Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{
+						Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+						Options: options.Index().SetName("expires_ttl").SetExpireAfterSeconds(0),
+					},
+					{
+						Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "purpose", Value: 1}},
+						Options: options.Index().SetName("user_purpose"),
+					},
+				})
+				return err
+			},
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				return dropIndexes(ctx, db.Collection(oneTime), "expires_ttl", "user_purpose")
+			},
+		},
+		{
+			Version:     5,
+			Description: "backfill status of users created before account statuses",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				_, err := db.Collection(collection).UpdateMany(ctx,
+					bson.M{"status": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"status": storage.StatusActive}},
+				)
+				return err
+			},
+			// Like version 2, the backfilled documents can not be told apart.
+			Down: func(ctx context.Context, db *mongo.Database) error { return nil },
+		},
+		{
+			Version:     6,
+			Description: "login attempt expiry index",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				_, err := db.Collection(attempts).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+					Options: options.Index().SetName("expires_ttl").SetExpireAfterSeconds(0),
+				})
+				return err
+			},
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				return dropIndexes(ctx, db.Collection(attempts), "expires_ttl")
+			},
+		},
+		{
+			Version:     7,
+			Description: "unique API key hash index",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				_, err := db.Collection(apiKeys).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys:    bson.D{{Key: "hash", Value: 1}},
+					Options: options.Index().SetName("hash_unique").SetUnique(true),
+				})
+				return err
+			},
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				return dropIndexes(ctx, db.Collection(apiKeys), "hash_unique")
+			},
+		},
+		{
+			Version:     8,
+			Description: "leave trashed users out of the email and username indexes",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				// Partial indexes can not match a missing field, so live
+				// users get an explicit null.
+				_, err := db.Collection(collection).UpdateMany(ctx,
+					bson.M{"deletedAt": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"deletedAt": nil}},
+				)
+				if err != nil {
+					return err
+				}
+				return userIndexes(ctx, db.Collection(collection), bson.M{"deletedAt": bson.M{"$type": "null"}})
+			},
+			// Fails while a trashed user shares an email or username with a
+			// live one.
+			Down: func(ctx context.Context, db *mongo.Database) error {
+				return userIndexes(ctx, db.Collection(collection), nil)
+			},
+		},
+	}
+}
+
+// userIndexes replaces the unique email and username indexes, partial when
+// filter is set.
+func userIndexes(ctx context.Context, collection *mongo.Collection, filter bson.M) error {
+	if err := dropIndexes(ctx, collection, "email_unique", "username_unique"); err != nil {
+		return err
+	}
+	collation := &options.Collation{Locale: "en", Strength: 2}
+	models := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("email_unique").SetUnique(true).SetCollation(collation),
+		},
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetName("username_unique").SetUnique(true).SetCollation(collation),
+		},
+	}
+	if filter != nil {
+		for _, model := range models {
+			model.Options.SetPartialFilterExpression(filter)
+		}
+	}
+	_, err := collection.Indexes().CreateMany(ctx, models)
+	return err
+}
+
+func dropIndexes(ctx context.Context, collection *mongo.Collection, names ...string) error {
+	for _, name := range names {
+		_, err := collection.Indexes().DropOne(ctx, name)
+		var cmdErr mongo.CommandError
+		if err != nil && !(errors.As(err, &cmdErr) && cmdErr.Code == indexNotFoundCode) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package session
+
+import (
+	"context"
+	"rest-api/internal/storage"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryStorage keeps refresh tokens in process memory and mirrors the
+// behaviour of MongoStorage.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	tokens  map[string]storage.RefreshToken
+	oneTime map[string]storage.OneTimeToken
+	logger  *logrus.Logger
+}
+
+func NewMemoryStorage(logger *logrus.Logger) *MemoryStorage {
+	logger.Info("Initializing session MemoryStorage")
+	return &MemoryStorage{
+		tokens:  make(map[string]storage.RefreshToken),
+		oneTime: make(map[string]storage.OneTimeToken),
+		logger:  logger,
+	}
+}
+
+func (s *MemoryStorage) CreateRefreshToken(ctx context.Context, token storage.RefreshToken) error {
+	s.logger.Infof("Creating refresh token of family %s for user %s", token.FamilyID, token.UserID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Expired tokens are dropped on insert, MongoStorage has a TTL index.
+	now := time.Now()
+	for hash, t := range s.tokens {
+		if t.ExpiresAt.Before(now) {
+			delete(s.tokens, hash)
+		}
+	}
+	s.tokens[token.Hash] = token
+	return nil
+}
+
+func (s *MemoryStorage) UseRefreshToken(ctx context.Context, hash string) (storage.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[hash]
+	if !ok {
+		s.logger.Warn("Refresh token not found")
+		return token, storage.ErrNotFound
+	}
+	if token.UsedAt == nil {
+		used := token
+		now := time.Now().UTC()
+		used.UsedAt = &now
+		s.tokens[hash] = used
+	}
+	return token, nil
+}
+
+func (s *MemoryStorage) RevokeFamily(ctx context.Context, familyID string) error {
+	s.logger.Infof("Revoking refresh token family %s", familyID)
+	s.revoke(func(t storage.RefreshToken) bool { return t.FamilyID == familyID })
+	return nil
+}
+
+func (s *MemoryStorage) RevokeUser(ctx context.Context, userID string) (int64, error) {
+	s.logger.Infof("Revoking all sessions of user %s", userID)
+	revoked := s.revoke(func(t storage.RefreshToken) bool { return t.UserID == userID })
+	s.logger.Infof("Revoked %d refresh tokens of user %s", revoked, userID)
+	return revoked, nil
+}
+
+func (s *MemoryStorage) revoke(match func(storage.RefreshToken) bool) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var revoked int64
+	now := time.Now().UTC()
+	for hash, t := range s.tokens {
+		if t.RevokedAt == nil && match(t) {
+			t.RevokedAt = &now
+			s.tokens[hash] = t
+			revoked++
+		}
+	}
+	return revoked
+}
@@ -0,0 +1,170 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"rest-api/internal/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (s *MongoStorage) CreateOneTimeToken(ctx context.Context, token storage.OneTimeToken) error {
+	s.logger.Infof("Creating %s token for user %s", token.Purpose, token.UserID)
+
+	_, err := s.oneTime.DeleteMany(ctx, bson.M{"userId": token.UserID, "purpose": token.Purpose, "usedAt": nil})
+	if err != nil {
+		s.logger.Errorf("Failed to delete previous %s tokens: %v", token.Purpose, err)
+		return err
+	}
+	if _, err := s.oneTime.InsertOne(ctx, token); err != nil {
+		s.logger.Errorf("Failed to insert %s token: %v", token.Purpose, err)
+		return err
+	}
+	return nil
+}
+
+func (s *MongoStorage) UseOneTimeToken(ctx context.Context, hash, purpose string) (storage.OneTimeToken, error) {
+	var token storage.OneTimeToken
+	now := time.Now().UTC()
+	err := s.oneTime.FindOneAndUpdate(ctx,
+		bson.M{"_id": hash, "purpose": purpose, "usedAt": nil, "expiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			s.logger.Warnf("No usable %s token", purpose)
+			return token, storage.ErrNotFound
+		}
+		s.logger.Errorf("Failed to use %s token: %v", purpose, err)
+		return token, err
+	}
+	return token, nil
+}
+
+func (s *MongoStorage) FindOneTimeToken(ctx context.Context, hash, purpose string) (storage.OneTimeToken, error) {
+	var token storage.OneTimeToken
+	err := s.oneTime.FindOne(ctx,
+		bson.M{"_id": hash, "purpose": purpose, "usedAt": nil, "expiresAt": bson.M{"$gt": time.Now().UTC()}},
+	).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			s.logger.Warnf("No usable %s token", purpose)
+			return token, storage.ErrNotFound
+		}
+		s.logger.Errorf("Failed to find %s token: %v", purpose, err)
+		return token, err
+	}
+	return token, nil
+}
+
+func (s *MemoryStorage) CreateOneTimeToken(ctx context.Context, token storage.OneTimeToken) error {
+	s.logger.Infof("Creating %s token for user %s", token.Purpose, token.UserID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, t := range s.oneTime {
+		if t.ExpiresAt.Before(now) || (t.UserID == token.UserID && t.Purpose == token.Purpose && t.UsedAt == nil) {
+			delete(s.oneTime, hash)
+		}
+	}
+	s.oneTime[token.Hash] = token
+	return nil
+}
+
+func (s *MemoryStorage) UseOneTimeToken(ctx context.Context, hash, purpose string) (storage.OneTimeToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	token, ok := s.oneTime[hash]
+	if !ok || token.Purpose != purpose || token.UsedAt != nil || !token.ExpiresAt.After(now) {
+		s.logger.Warnf("No usable %s token", purpose)
+		return storage.OneTimeToken{}, storage.ErrNotFound
+	}
+	token.UsedAt = &now
+	s.oneTime[hash] = token
+	return token, nil
+}
+
+func (s *MemoryStorage) FindOneTimeToken(ctx context.Context, hash, purpose string) (storage.OneTimeToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.oneTime[hash]
+	if !ok || token.Purpose != purpose || token.UsedAt != nil || !token.ExpiresAt.After(time.Now().UTC()) {
+		s.logger.Warnf("No usable %s token", purpose)
+		return storage.OneTimeToken{}, storage.ErrNotFound
+	}
+	return token, nil
+}
+
+func (s *SQLStorage) CreateOneTimeToken(ctx context.Context, token storage.OneTimeToken) error {
+	s.logger.Infof("Creating %s token for user %s", token.Purpose, token.UserID)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		fmt.Sprintf(`DELETE FROM %s WHERE expires_at < ? OR (user_id = ? AND purpose = ? AND used_at IS NULL)`, s.oneTime),
+		time.Now().UTC(), token.UserID, token.Purpose,
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to delete previous %s tokens: %v", token.Purpose, err)
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		fmt.Sprintf(`INSERT INTO %s (hash, purpose, user_id, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`, s.oneTime),
+		token.Hash, token.Purpose, token.UserID, token.CreatedAt.UTC(), token.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to insert %s token: %v", token.Purpose, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStorage) UseOneTimeToken(ctx context.Context, hash, purpose string) (storage.OneTimeToken, error) {
+	token := storage.OneTimeToken{Hash: hash, Purpose: purpose}
+	now := time.Now().UTC()
+	err := s.db.QueryRowContext(ctx,
+		fmt.Sprintf(`UPDATE %s SET used_at = ? WHERE hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+			RETURNING user_id, created_at, expires_at`, s.oneTime),
+		now, hash, purpose, now,
+	).Scan(&token.UserID, &token.CreatedAt, &token.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.Warnf("No usable %s token", purpose)
+			return storage.OneTimeToken{}, storage.ErrNotFound
+		}
+		s.logger.Errorf("Failed to use %s token: %v", purpose, err)
+		return storage.OneTimeToken{}, err
+	}
+	token.UsedAt = &now
+	return token, nil
+}
+
+func (s *SQLStorage) FindOneTimeToken(ctx context.Context, hash, purpose string) (storage.OneTimeToken, error) {
+	token := storage.OneTimeToken{Hash: hash, Purpose: purpose}
+	err := s.db.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT user_id, created_at, expires_at FROM %s WHERE hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?`, s.oneTime),
+		hash, purpose, time.Now().UTC(),
+	).Scan(&token.UserID, &token.CreatedAt, &token.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.Warnf("No usable %s token", purpose)
+			return storage.OneTimeToken{}, storage.ErrNotFound
+		}
+		s.logger.Errorf("Failed to find %s token: %v", purpose, err)
+		return storage.OneTimeToken{}, err
+	}
+	return token, nil
+}
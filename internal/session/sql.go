@@ -0,0 +1,190 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"rest-api/internal/storage"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type SQLStorage struct {
+	db      *sql.DB
+	table   string
+	oneTime string
+	logger  *logrus.Logger
+}
+
+func NewSQLStorage(ctx context.Context, db *sql.DB, table, oneTimeTable string, logger *logrus.Logger) (*SQLStorage, error) {
+	logger.Infof("Initializing session SQLStorage for tables: %s, %s", table, oneTimeTable)
+
+	s := &SQLStorage{
+		db:      db,
+		table:   quote(table),
+		oneTime: quote(oneTimeTable),
+		logger:  logger,
+	}
+	if err := s.bootstrap(ctx, table, oneTimeTable); err != nil {
+		logger.Errorf("Failed to bootstrap schema: %v", err)
+		return nil, err
+	}
+	return s, nil
+}
+
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (s *SQLStorage) bootstrap(ctx context.Context, table, oneTimeTable string) error {
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			hash       TEXT PRIMARY KEY,
+			family_id  TEXT NOT NULL,
+			user_id    TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at    TIMESTAMP NULL,
+			revoked_at TIMESTAMP NULL,
+			mfa        BOOLEAN NOT NULL DEFAULT FALSE
+		)`, s.table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_family" ON %s (family_id)`, table, s.table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_user" ON %s (user_id)`, table, s.table),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			hash       TEXT PRIMARY KEY,
+			purpose    TEXT NOT NULL,
+			user_id    TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at    TIMESTAMP NULL
+		)`, s.oneTime),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_user" ON %s (user_id, purpose)`, oneTimeTable, s.oneTime),
+	}
+	for _, statement := range statements {
+		if _, err := s.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	// Tables created by older releases lack the columns added since.
+	return s.ensureColumn(ctx, "mfa", "BOOLEAN NOT NULL DEFAULT FALSE")
+}
+
+func (s *SQLStorage) ensureColumn(ctx context.Context, column, definition string) error {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`PRAGMA table_info(%s)`, s.table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	// Release the connection before altering, SQLite runs with a single one.
+	rows.Close()
+
+	s.logger.Infof("Adding column %s to table %s", column, s.table)
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, s.table, column, definition))
+	return err
+}
+
+func (s *SQLStorage) CreateRefreshToken(ctx context.Context, token storage.RefreshToken) error {
+	s.logger.Infof("Creating refresh token of family %s for user %s", token.FamilyID, token.UserID)
+
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE expires_at < ?`, s.table), time.Now().UTC()); err != nil {
+		s.logger.Warnf("Failed to delete expired refresh tokens: %v", err)
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		fmt.Sprintf(`INSERT INTO %s (hash, family_id, user_id, created_at, expires_at, mfa) VALUES (?, ?, ?, ?, ?, ?)`, s.table),
+		token.Hash, token.FamilyID, token.UserID, token.CreatedAt.UTC(), token.ExpiresAt.UTC(), token.MFA,
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to insert refresh token: %v", err)
+	}
+	return err
+}
+
+func (s *SQLStorage) UseRefreshToken(ctx context.Context, hash string) (storage.RefreshToken, error) {
+	var token storage.RefreshToken
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return token, err
+	}
+	defer tx.Rollback()
+
+	var usedAt, revokedAt sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT hash, family_id, user_id, created_at, expires_at, used_at, revoked_at, mfa FROM %s WHERE hash = ?`, s.table),
+		hash,
+	).Scan(&token.Hash, &token.FamilyID, &token.UserID, &token.CreatedAt, &token.ExpiresAt, &usedAt, &revokedAt, &token.MFA)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.Warn("Refresh token not found")
+			return token, storage.ErrNotFound
+		}
+		s.logger.Errorf("Failed to use refresh token: %v", err)
+		return token, err
+	}
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	if token.UsedAt == nil {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET used_at = ? WHERE hash = ?`, s.table), time.Now().UTC(), hash)
+		if err != nil {
+			s.logger.Errorf("Failed to use refresh token: %v", err)
+			return token, err
+		}
+	}
+	return token, tx.Commit()
+}
+
+func (s *SQLStorage) RevokeFamily(ctx context.Context, familyID string) error {
+	s.logger.Infof("Revoking refresh token family %s", familyID)
+
+	_, err := s.db.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE %s SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL`, s.table),
+		time.Now().UTC(), familyID,
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to revoke refresh token family: %v", err)
+	}
+	return err
+}
+
+func (s *SQLStorage) RevokeUser(ctx context.Context, userID string) (int64, error) {
+	s.logger.Infof("Revoking all sessions of user %s", userID)
+
+	result, err := s.db.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE %s SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, s.table),
+		time.Now().UTC(), userID,
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to revoke sessions: %v", err)
+		return 0, err
+	}
+
+	revoked, _ := result.RowsAffected()
+	s.logger.Infof("Revoked %d refresh tokens of user %s", revoked, userID)
+	return revoked, nil
+}
@@ -0,0 +1,90 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"rest-api/internal/storage"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStorage keeps refresh and one-time tokens, expiring them by TTL index.
+type MongoStorage struct {
+	collection *mongo.Collection
+	oneTime    *mongo.Collection
+	logger     *logrus.Logger
+}
+
+func NewMongoStorage(client *mongo.Client, dbName, collectionName, oneTimeCollection string, logger *logrus.Logger) *MongoStorage {
+	logger.Infof("Initializing session MongoStorage for database: %s, collections: %s, %s", dbName, collectionName, oneTimeCollection)
+	return &MongoStorage{
+		collection: client.Database(dbName).Collection(collectionName),
+		oneTime:    client.Database(dbName).Collection(oneTimeCollection),
+		logger:     logger,
+	}
+}
+
+func (s *MongoStorage) CreateRefreshToken(ctx context.Context, token storage.RefreshToken) error {
+	s.logger.Infof("Creating refresh token of family %s for user %s", token.FamilyID, token.UserID)
+
+	if _, err := s.collection.InsertOne(ctx, token); err != nil {
+		s.logger.Errorf("Failed to insert refresh token: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *MongoStorage) UseRefreshToken(ctx context.Context, hash string) (storage.RefreshToken, error) {
+	var token storage.RefreshToken
+	err := s.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": hash, "usedAt": nil},
+		bson.M{"$set": bson.M{"usedAt": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Either unknown or already used, the latter is returned as is.
+		err = s.collection.FindOne(ctx, bson.M{"_id": hash}).Decode(&token)
+	}
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			s.logger.Warn("Refresh token not found")
+			return token, storage.ErrNotFound
+		}
+		s.logger.Errorf("Failed to use refresh token: %v", err)
+		return token, err
+	}
+	return token, nil
+}
+
+func (s *MongoStorage) RevokeFamily(ctx context.Context, familyID string) error {
+	s.logger.Infof("Revoking refresh token family %s", familyID)
+
+	_, err := s.collection.UpdateMany(ctx,
+		bson.M{"familyId": familyID, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}},
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to revoke refresh token family: %v", err)
+	}
+	return err
+}
+
+func (s *MongoStorage) RevokeUser(ctx context.Context, userID string) (int64, error) {
+	s.logger.Infof("Revoking all sessions of user %s", userID)
+
+	result, err := s.collection.UpdateMany(ctx,
+		bson.M{"userId": userID, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}},
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to revoke sessions: %v", err)
+		return 0, err
+	}
+
+	s.logger.Infof("Revoked %d refresh tokens of user %s", result.ModifiedCount, userID)
+	return result.ModifiedCount, nil
+}
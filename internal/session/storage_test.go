@@ -0,0 +1,115 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"rest-api/internal/storage"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+func backends(t *testing.T) map[string]storage.TokenStorage {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	sqlStorage, err := NewSQLStorage(context.Background(), db, "refresh_tokens", "one_time_tokens", logger)
+	if err != nil {
+		t.Fatalf("NewSQLStorage() error = %v", err)
+	}
+
+	return map[string]storage.TokenStorage{
+		"memory": NewMemoryStorage(logger),
+		"sql":    sqlStorage,
+	}
+}
+
+func TestUseRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now().UTC()
+			for _, token := range []storage.RefreshToken{
+				{Hash: "a", FamilyID: "f1", UserID: "u1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+				{Hash: "b", FamilyID: "f1", UserID: "u1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+				{Hash: "c", FamilyID: "f2", UserID: "u1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+			} {
+				if err := s.CreateRefreshToken(ctx, token); err != nil {
+					t.Fatalf("CreateRefreshToken() error = %v", err)
+				}
+			}
+
+			first, err := s.UseRefreshToken(ctx, "a")
+			if err != nil || first.UsedAt != nil {
+				t.Fatalf("first UseRefreshToken() = %+v, %v, want an unused token", first, err)
+			}
+			again, err := s.UseRefreshToken(ctx, "a")
+			if err != nil || again.UsedAt == nil {
+				t.Fatalf("second UseRefreshToken() = %+v, %v, want the reuse revealed", again, err)
+			}
+			if _, err := s.UseRefreshToken(ctx, "unknown"); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("UseRefreshToken() of an unknown token error = %v, want %v", err, storage.ErrNotFound)
+			}
+
+			if err := s.RevokeFamily(ctx, "f1"); err != nil {
+				t.Fatalf("RevokeFamily() error = %v", err)
+			}
+			if token, _ := s.UseRefreshToken(ctx, "b"); token.RevokedAt == nil {
+				t.Errorf("token of the revoked family is not revoked: %+v", token)
+			}
+			if token, _ := s.UseRefreshToken(ctx, "c"); token.RevokedAt != nil {
+				t.Errorf("token of another family was revoked: %+v", token)
+			}
+		})
+	}
+}
+
+func TestUseOneTimeToken(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now().UTC()
+			create := func(hash string, expiresAt time.Time) {
+				t.Helper()
+				token := storage.OneTimeToken{Hash: hash, Purpose: storage.PurposeMFAChallenge, UserID: "u1", CreatedAt: now, ExpiresAt: expiresAt}
+				if err := s.CreateOneTimeToken(ctx, token); err != nil {
+					t.Fatalf("CreateOneTimeToken() error = %v", err)
+				}
+			}
+
+			create("expired", now.Add(-time.Minute))
+			if _, err := s.UseOneTimeToken(ctx, "expired", storage.PurposeMFAChallenge); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("expired token error = %v, want %v", err, storage.ErrNotFound)
+			}
+
+			create("old", now.Add(time.Hour))
+			create("new", now.Add(time.Hour))
+			if _, err := s.UseOneTimeToken(ctx, "old", storage.PurposeMFAChallenge); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("replaced token error = %v, want %v", err, storage.ErrNotFound)
+			}
+			if _, err := s.UseOneTimeToken(ctx, "new", storage.PurposePasswordReset); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("token of another purpose error = %v, want %v", err, storage.ErrNotFound)
+			}
+			if _, err := s.FindOneTimeToken(ctx, "new", storage.PurposeMFAChallenge); err != nil {
+				t.Errorf("FindOneTimeToken() error = %v", err)
+			}
+			if token, err := s.UseOneTimeToken(ctx, "new", storage.PurposeMFAChallenge); err != nil || token.UserID != "u1" {
+				t.Errorf("UseOneTimeToken() = %+v, %v", token, err)
+			}
+			if _, err := s.UseOneTimeToken(ctx, "new", storage.PurposeMFAChallenge); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("spent token error = %v, want %v", err, storage.ErrNotFound)
+			}
+		})
+	}
+}
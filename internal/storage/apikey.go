@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// APIKey authenticates a service instead of a user. Only the SHA-256 hash
+// of the key is stored, Prefix is its visible start that tells keys apart.
+// Scopes are the permissions the key grants.
+type APIKey struct {
+	ID         string     `json:"id" bson:"_id"`
+	Name       string     `json:"name" bson:"name"`
+	Prefix     string     `json:"prefix" bson:"prefix"`
+	Hash       string     `json:"-" bson:"hash"`
+	Scopes     []string   `json:"scopes" bson:"scopes"`
+	CreatedBy  string     `json:"created_by" bson:"createdBy"`
+	CreatedAt  time.Time  `json:"created_at" bson:"createdAt"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" bson:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" bson:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" bson:"revokedAt,omitempty"`
+}
+
+type APIKeyStorage interface {
+	CreateAPIKey(ctx context.Context, key APIKey) (string, error)
+	// ListAPIKeys returns every key, revoked ones included, newest first.
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	// FindAPIKey returns the key with the given hash, revoked or expired.
+	FindAPIKey(ctx context.Context, hash string) (APIKey, error)
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+	// RevokeAPIKey fails with ErrNotFound when the key is unknown or already
+	// revoked.
+	RevokeAPIKey(ctx context.Context, id string) error
+}
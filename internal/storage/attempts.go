@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// LoginAttempts counts the consecutive failed logins of a key, which names
+// an account or a client IP. The count restarts once ExpiresAt passes.
+type LoginAttempts struct {
+	Key         string    `bson:"_id"`
+	Failures    int       `bson:"failures"`
+	LastFailure time.Time `bson:"lastFailure"`
+	ExpiresAt   time.Time `bson:"expiresAt"`
+}
+
+type AttemptStorage interface {
+	// GetAttempts returns the live failures of key, a zero count when there
+	// are none.
+	GetAttempts(ctx context.Context, key string) (LoginAttempts, error)
+	// RecordFailure adds a failure to key, keeping the count until
+	// expiresAt, and returns the new count.
+	RecordFailure(ctx context.Context, key string, expiresAt time.Time) (LoginAttempts, error)
+	// ResetAttempts forgets the failures of key and reports whether it had
+	// any.
+	ResetAttempts(ctx context.Context, key string) (bool, error)
+}
@@ -0,0 +1,29 @@
+package storage
+
+import "errors"
+
+type BatchOpType string
+
+const (
+	BatchCreate BatchOpType = "create"
+	BatchUpdate BatchOpType = "update"
+	BatchDelete BatchOpType = "delete"
+)
+
+// ErrNotExecuted marks the operations an ordered batch skipped after a failure.
+var ErrNotExecuted = errors.New("not executed")
+
+// BatchOperation is a single write of a batch. Update replaces the client
+// like Storage.Update; update and delete address Client.ID and honour
+// Client.Version as a precondition.
+type BatchOperation struct {
+	Type      BatchOpType
+	Client    Client
+	DeletedBy string
+}
+
+// BatchResult reports the outcome of the operation at the same index.
+type BatchResult struct {
+	ID  string
+	Err error
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotFound        = errors.New("client not found")
+	ErrDuplicateKey    = errors.New("duplicate key")
+	ErrInvalidID       = errors.New("invalid id")
+	ErrVersionConflict = errors.New("version conflict")
+	// ErrInvalidTransition is returned when a status change is not allowed
+	// from the current status of a client.
+	ErrInvalidTransition = errors.New("invalid status transition")
+
+	ErrInvalidListOptions = errors.New("invalid list options")
+)
+
+// ConflictError is returned when a write violates a unique constraint.
+// It matches ErrDuplicateKey with errors.Is.
+type ConflictError struct {
+	Field string
+}
+
+func (e *ConflictError) Error() string {
+	if e.Field == "" {
+		return ErrDuplicateKey.Error()
+	}
+	return fmt.Sprintf("%s: %s", ErrDuplicateKey, e.Field)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrDuplicateKey
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+type FilterOp string
+
+const (
+	FilterEqual  FilterOp = "eq"
+	FilterPrefix FilterOp = "prefix"
+)
+
+// Filter restricts a listing to clients whose Field matches Value.
+type Filter struct {
+	Field string
+	Op    FilterOp
+	Value string
+}
+
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// ListOptions describes a page request. Cursor and Offset are mutually
+// exclusive: a cursor continues a keyset scan started by a previous page.
+type ListOptions struct {
+	Limit   int
+	Offset  int
+	Cursor  string
+	Filters []Filter
+	Sort    []SortField
+	// Deleted lists the trash instead of live clients.
+	Deleted bool
+}
+
+type Page struct {
+	Items      []Client
+	Total      int64
+	NextCursor string
+}
+
+var (
+	filterFields = map[string]bool{"email": true, "username": true, "status": true}
+	sortFields   = map[string]bool{"id": true, "email": true, "username": true}
+)
+
+func (o ListOptions) Validate() error {
+	if o.Limit < 0 || o.Limit > MaxLimit {
+		return fmt.Errorf("%w: limit must be between 0 (the default) and %d", ErrInvalidListOptions, MaxLimit)
+	}
+	if o.Offset < 0 {
+		return fmt.Errorf("%w: offset must not be negative", ErrInvalidListOptions)
+	}
+	if o.Offset > 0 && o.Cursor != "" {
+		return fmt.Errorf("%w: offset and cursor can not be combined", ErrInvalidListOptions)
+	}
+	for _, f := range o.Filters {
+		if !filterFields[f.Field] {
+			return fmt.Errorf("%w: can not filter by %q", ErrInvalidListOptions, f.Field)
+		}
+		if f.Op != FilterEqual && f.Op != FilterPrefix {
+			return fmt.Errorf("%w: unknown filter operator %q", ErrInvalidListOptions, f.Op)
+		}
+	}
+	seen := make(map[string]bool, len(o.Sort))
+	for _, s := range o.Sort {
+		if !sortFields[s.Field] {
+			return fmt.Errorf("%w: can not sort by %q", ErrInvalidListOptions, s.Field)
+		}
+		if seen[s.Field] {
+			return fmt.Errorf("%w: duplicate sort field %q", ErrInvalidListOptions, s.Field)
+		}
+		seen[s.Field] = true
+	}
+	return nil
+}
+
+// PageSize returns the requested limit or DefaultLimit when none was given.
+func (o ListOptions) PageSize() int {
+	if o.Limit == 0 {
+		return DefaultLimit
+	}
+	return o.Limit
+}
+
+// SortKeys returns the requested sort order with the id appended as a
+// tie-breaker, so that every page boundary is unambiguous.
+func (o ListOptions) SortKeys() []SortField {
+	keys := make([]SortField, 0, len(o.Sort)+1)
+	for _, s := range o.Sort {
+		keys = append(keys, s)
+		if s.Field == "id" {
+			return keys
+		}
+	}
+	return append(keys, SortField{Field: "id"})
+}
+
+// Cursor is the decoded form of ListOptions.Cursor: the sort key values
+// of the last client on the previous page.
+type Cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+func EncodeCursor(keys []SortField, last Client) string {
+	c := Cursor{Sort: sortSpec(keys)}
+	for _, k := range keys {
+		c.Values = append(c.Values, last.FieldValue(k.Field))
+	}
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor parses token and checks that it was issued for the same sort order.
+func DecodeCursor(token string, keys []SortField) (Cursor, error) {
+	var c Cursor
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	if c.Sort != sortSpec(keys) || len(c.Values) != len(keys) {
+		return c, fmt.Errorf("%w: cursor does not match sort order", ErrInvalidListOptions)
+	}
+	return c, nil
+}
+
+// FieldValue returns the value of a filterable or sortable field by its API name.
+func (c Client) FieldValue(field string) string {
+	switch field {
+	case "id":
+		return c.ID
+	case "email":
+		return c.Email
+	case "username":
+		return c.Username
+	case "status":
+		return c.Status
+	default:
+		return ""
+	}
+}
+
+func sortSpec(keys []SortField) string {
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if k.Desc {
+			parts = append(parts, "-"+k.Field)
+		} else {
+			parts = append(parts, k.Field)
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// TOTP is the authenticator app enrolled by a client. The secret is stored
+// as sealed by the auth package, recovery codes as SHA-256 hashes.
+type TOTP struct {
+	UserID        string     `bson:"_id"`
+	Secret        string     `bson:"secret"`
+	CreatedAt     time.Time  `bson:"createdAt"`
+	ConfirmedAt   *time.Time `bson:"confirmedAt,omitempty"`
+	RecoveryCodes []string   `bson:"recoveryCodes,omitempty"`
+	// LastStep is the time step of the last accepted code. Codes of that or
+	// an earlier step are refused, so that each code works once.
+	LastStep int64 `bson:"lastStep"`
+}
+
+type MFAStorage interface {
+	// CreateTOTP stores an unconfirmed enrollment, replacing an earlier
+	// unconfirmed one. It fails with ErrDuplicateKey when the client has a
+	// confirmed one.
+	CreateTOTP(ctx context.Context, totp TOTP) error
+	FindTOTP(ctx context.Context, userID string) (TOTP, error)
+	// ConfirmTOTP confirms the pending enrollment of userID with the code of
+	// step and stores the recovery code hashes. It fails with ErrNotFound
+	// when there is no pending enrollment.
+	ConfirmTOTP(ctx context.Context, userID string, step int64, recoveryCodes []string) error
+	// UseTOTPStep records step as used. It fails with ErrNotFound unless
+	// userID has a confirmed enrollment whose last step is earlier.
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	// UseRecoveryCode removes a recovery code hash of a confirmed enrollment,
+	// it fails with ErrNotFound when the hash is not there.
+	UseRecoveryCode(ctx context.Context, userID, hash string) error
+	DeleteTOTP(ctx context.Context, userID string) error
+}
@@ -1,8 +1,19 @@
 package storage
 
+import "time"
+
 type Client struct {
-	ID           string `json:"id" bson:"_id,omitempty"`
-	Email        string `json:"email" bson:"email"`
-	Username     string `json:"username" bson:"username"`
-	PasswordHash string `json:"-" bson:"password"`
+	ID           string   `json:"id" bson:"_id,omitempty"`
+	Email        string   `json:"email" bson:"email"`
+	Username     string   `json:"username" bson:"username"`
+	PasswordHash string   `json:"-" bson:"password"`
+	Version      int64    `json:"version" bson:"version"`
+	Roles        []string `json:"roles,omitempty" bson:"roles,omitempty"`
+	// Status is one of the Status constants, Create stores StatusActive
+	// when it is empty.
+	Status string `json:"status,omitempty" bson:"status,omitempty"`
+	// DeletedAt is stored as null on live users, the unique indexes on
+	// email and username only cover those.
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt"`
+	DeletedBy string     `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
 }
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Fields a Patch may change, named as they are stored.
+const (
+	FieldEmail    = "email"
+	FieldUsername = "username"
+	// FieldPassword holds the password hash.
+	FieldPassword = "password"
+)
+
+var ErrUnknownField = errors.New("unknown field")
+
+// Patch is a partial update of a client. Set assigns fields, every other
+// field is left as it is. None of the fields may be cleared.
+type Patch struct {
+	ID      string
+	Version int64
+	Set     map[string]string
+}
+
+// Check reports a field of p that is not one of the Field constants.
+func (p Patch) Check() error {
+	for field := range p.Set {
+		if !patchable(field) {
+			return fmt.Errorf("%w: %s", ErrUnknownField, field)
+		}
+	}
+	return nil
+}
+
+func patchable(field string) bool {
+	return field == FieldEmail || field == FieldUsername || field == FieldPassword
+}
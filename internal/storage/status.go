@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"slices"
+	"strings"
+)
+
+// Account statuses. Only active clients may log in or use their tokens.
+const (
+	StatusPendingVerification = "pending_verification"
+	StatusActive              = "active"
+	StatusSuspended           = "suspended"
+	StatusDisabled            = "disabled"
+)
+
+// transitions lists the statuses each status may change to. Disabled
+// accounts stay disabled.
+var transitions = map[string][]string{
+	StatusPendingVerification: {StatusActive, StatusDisabled},
+	StatusActive:              {StatusSuspended, StatusDisabled},
+	StatusSuspended:           {StatusActive, StatusDisabled},
+}
+
+// CanTransition reports whether a client may move from status from to to.
+func CanTransition(from, to string) bool {
+	return slices.Contains(transitions[from], to)
+}
+
+// StatusAfterEmailChange returns the status of a client in status whose
+// email changes from old to new. Emails compare case-insensitively.
+//
+// A pending client can not log in, so a typo in the new address locks the
+// owner out. An admin then corrects the email, which mails a new token,
+// or verifies the account with POST /admins/:uuid/verify.
+func StatusAfterEmailChange(status, old, new string) string {
+	if status == StatusActive && !strings.EqualFold(old, new) {
+		return StatusPendingVerification
+	}
+	return status
+}
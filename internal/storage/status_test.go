@@ -0,0 +1,52 @@
+package storage
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{StatusPendingVerification, StatusActive, true},
+		{StatusPendingVerification, StatusDisabled, true},
+		{StatusPendingVerification, StatusSuspended, false},
+		{StatusActive, StatusSuspended, true},
+		{StatusActive, StatusDisabled, true},
+		{StatusActive, StatusPendingVerification, false},
+		{StatusActive, StatusActive, false},
+		{StatusSuspended, StatusActive, true},
+		{StatusSuspended, StatusDisabled, true},
+		{StatusSuspended, StatusPendingVerification, false},
+		{StatusDisabled, StatusActive, false},
+		{StatusDisabled, StatusSuspended, false},
+		{StatusDisabled, StatusPendingVerification, false},
+		{"", StatusActive, false},
+		{StatusActive, "deleted", false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%q, %q) = %t, want %t", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestStatusAfterEmailChange(t *testing.T) {
+	tests := []struct {
+		status, old, new string
+		want             string
+	}{
+		{StatusActive, "bob@example.com", "bob@example.org", StatusPendingVerification},
+		{StatusActive, "bob@example.com", "Bob@Example.com", StatusActive},
+		{StatusActive, "bob@example.com", "bob@example.com", StatusActive},
+		{StatusPendingVerification, "bob@example.com", "bob@example.org", StatusPendingVerification},
+		{StatusSuspended, "bob@example.com", "bob@example.org", StatusSuspended},
+		{StatusDisabled, "bob@example.com", "bob@example.org", StatusDisabled},
+	}
+
+	for _, tt := range tests {
+		if got := StatusAfterEmailChange(tt.status, tt.old, tt.new); got != tt.want {
+			t.Errorf("StatusAfterEmailChange(%q, %q, %q) = %q, want %q", tt.status, tt.old, tt.new, got, tt.want)
+		}
+	}
+}
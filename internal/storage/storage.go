@@ -1,12 +1,62 @@
 package storage
 
-import "context"
+import (
+	"context"
+	"strings"
+	"time"
+)
 
+// Writes are conditional when the client carries a non-zero Version (or
+// DeleteOptions.Version is set): they fail with ErrVersionConflict unless the
+// stored version matches. Every successful write bumps Version. Writes to a
+// client that does not exist fail with ErrNotFound.
+//
+// Delete only moves a client to the trash. Trashed clients are invisible to
+// every method except Restore, Purge and listings with ListOptions.Deleted.
 type Storage interface {
 	Create(ctx context.Context, client Client) (string, error)
 	FindOne(ctx context.Context, id string) (Client, error)
-	Update(ctx context.Context, client Client) error
-	Delete(ctx context.Context, id string) error
-	GetAll(ctx context.Context) ([]Client, error)
-	PartiallyUpdate(ctx context.Context, client Client) error
+	// FindByLogin finds a live client by email when login contains an "@",
+	// by username otherwise. Both compare case-insensitively.
+	FindByLogin(ctx context.Context, login string) (Client, error)
+	// Update and PartiallyUpdate return the client as stored by the write.
+	// They move an active client whose email they change back to
+	// StatusPendingVerification, the new address has to be verified.
+	Update(ctx context.Context, client Client) (Client, error)
+	Delete(ctx context.Context, id string, opts DeleteOptions) error
+	Restore(ctx context.Context, id string) error
+	// Purge permanently removes clients trashed before the given time.
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+	GetAll(ctx context.Context, opts ListOptions) (Page, error)
+	// Stream calls fn for every client matching opts without buffering the
+	// whole result. Limit, Offset and Cursor are ignored.
+	Stream(ctx context.Context, opts ListOptions, fn func(Client) error) error
+	// PartiallyUpdate applies patch to the client patch.ID.
+	PartiallyUpdate(ctx context.Context, patch Patch) (Client, error)
+	// GrantRole and RevokeRole add or remove a role of a live client, which
+	// Update and PartiallyUpdate never touch. Granting a role the client
+	// holds, or revoking one it lacks, changes nothing.
+	GrantRole(ctx context.Context, id, role string) error
+	RevokeRole(ctx context.Context, id, role string) error
+	// SetStatus moves a live client from status from to status to, bumping
+	// its version. It fails with ErrInvalidTransition when to may not follow
+	// from, or when the client is no longer in status from.
+	SetStatus(ctx context.Context, id, from, to string) error
+	// Batch applies ops and returns one result per operation. Ordered batches
+	// stop at the first failure and report the rest as ErrNotExecuted. The
+	// error is only set when the batch as a whole could not be attempted.
+	Batch(ctx context.Context, ops []BatchOperation, ordered bool) ([]BatchResult, error)
+}
+
+// LoginField returns the field FindByLogin matches login against.
+func LoginField(login string) string {
+	if strings.Contains(login, "@") {
+		return "email"
+	}
+	return "username"
+}
+
+type DeleteOptions struct {
+	Version   int64
+	DeletedBy string
 }
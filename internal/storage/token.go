@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// RefreshToken is a server-side session. Only the SHA-256 of the opaque
+// token is stored. Every refresh replaces the token with a new one of the
+// same family, so a token used twice betrays a stolen family.
+type RefreshToken struct {
+	Hash      string     `bson:"_id"`
+	FamilyID  string     `bson:"familyId"`
+	UserID    string     `bson:"userId"`
+	CreatedAt time.Time  `bson:"createdAt"`
+	ExpiresAt time.Time  `bson:"expiresAt"`
+	UsedAt    *time.Time `bson:"usedAt,omitempty"`
+	RevokedAt *time.Time `bson:"revokedAt,omitempty"`
+	// MFA is set when the session passed a second factor at login.
+	MFA bool `bson:"mfa,omitempty"`
+}
+
+const (
+	PurposePasswordReset = "password_reset"
+	PurposeVerifyEmail   = "verify_email"
+	// PurposeMFAChallenge tokens link the two steps of a login with MFA.
+	PurposeMFAChallenge = "mfa_challenge"
+)
+
+// OneTimeToken is a single-use secret sent to a user, such as a password
+// reset link. Like refresh tokens only its SHA-256 is stored.
+type OneTimeToken struct {
+	Hash      string     `bson:"_id"`
+	Purpose   string     `bson:"purpose"`
+	UserID    string     `bson:"userId"`
+	CreatedAt time.Time  `bson:"createdAt"`
+	ExpiresAt time.Time  `bson:"expiresAt"`
+	UsedAt    *time.Time `bson:"usedAt,omitempty"`
+}
+
+type TokenStorage interface {
+	CreateRefreshToken(ctx context.Context, token RefreshToken) error
+	// UseRefreshToken marks the token as used and returns it as it was
+	// before, so a non-nil UsedAt reveals reuse. It fails with ErrNotFound
+	// for unknown tokens.
+	UseRefreshToken(ctx context.Context, hash string) (RefreshToken, error)
+	RevokeFamily(ctx context.Context, familyID string) error
+	// RevokeUser revokes every session of a user and returns how many tokens
+	// it revoked.
+	RevokeUser(ctx context.Context, userID string) (int64, error)
+	// CreateOneTimeToken stores token and deletes the unused tokens the user
+	// holds for the same purpose, only the latest one stays valid.
+	CreateOneTimeToken(ctx context.Context, token OneTimeToken) error
+	// UseOneTimeToken consumes an unused and unexpired token of purpose. It
+	// fails with ErrNotFound for every other token.
+	UseOneTimeToken(ctx context.Context, hash, purpose string) (OneTimeToken, error)
+	// FindOneTimeToken returns the token UseOneTimeToken would consume,
+	// without consuming it.
+	FindOneTimeToken(ctx context.Context, hash, purpose string) (OneTimeToken, error)
+}
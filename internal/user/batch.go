@@ -0,0 +1,180 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rest-api/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// duplicateKeyCode is the server error code of a unique index violation.
+const duplicateKeyCode = 11000
+
+// Batch writes every run of consecutive creates with a single BulkWrite.
+// Updates and deletes run one at a time as conditional writes: BulkWrite
+// only reports how many documents matched in total, so a document deleted
+// or changed concurrently could not be told apart from a successful write.
+func (s *MongoStorage) Batch(ctx context.Context, ops []storage.BatchOperation, ordered bool) ([]storage.BatchResult, error) {
+	s.logger.Infof("Running batch of %d operations, ordered: %t", len(ops), ordered)
+
+	if err := checkBatch(ops); err != nil {
+		return nil, err
+	}
+
+	results := make([]storage.BatchResult, len(ops))
+	for i := 0; i < len(ops); {
+		next := i + 1
+		if ops[i].Type == storage.BatchCreate {
+			for next < len(ops) && ops[next].Type == storage.BatchCreate {
+				next++
+			}
+			if err := s.bulkCreate(ctx, ops[i:next], results[i:next], ordered); err != nil {
+				return nil, err
+			}
+		} else {
+			results[i] = storage.BatchResult{ID: ops[i].Client.ID, Err: batchWrite(ctx, s, ops[i])}
+		}
+
+		if ordered {
+			for j := i; j < next; j++ {
+				if results[j].Err != nil {
+					skipRemaining(results, next-1)
+					s.logger.Infof("Batch stopped at operation %d", j)
+					return results, nil
+				}
+			}
+		}
+		i = next
+	}
+
+	s.logger.Infof("Batch finished: %d operations", len(ops))
+	return results, nil
+}
+
+// bulkCreate inserts the clients of ops with one BulkWrite and records the
+// outcome of each in the result at the same index. Errors that are not tied
+// to a document, such as a write concern error, fail the whole batch since
+// it is unknown which documents were written.
+func (s *MongoStorage) bulkCreate(ctx context.Context, ops []storage.BatchOperation, results []storage.BatchResult, ordered bool) error {
+	models := make([]mongo.WriteModel, len(ops))
+	for i, op := range ops {
+		objectID := primitive.NewObjectID()
+		status := op.Client.Status
+		if status == "" {
+			status = storage.StatusActive
+		}
+		doc := bson.M{
+			"_id":       objectID,
+			"email":     op.Client.Email,
+			"username":  op.Client.Username,
+			"password":  op.Client.PasswordHash,
+			"version":   int64(1),
+			"status":    status,
+			"deletedAt": nil,
+		}
+		if len(op.Client.Roles) > 0 {
+			doc["roles"] = op.Client.Roles
+		}
+		models[i] = mongo.NewInsertOneModel().SetDocument(doc)
+		results[i].ID = objectID.Hex()
+	}
+
+	_, err := s.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(ordered))
+	var bulkErr mongo.BulkWriteException
+	switch {
+	case errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil:
+		for _, writeErr := range bulkErr.WriteErrors {
+			i := writeErr.Index
+			if writeErr.Code == duplicateKeyCode {
+				results[i].Err = duplicateKeyError(writeErr.Message)
+			} else {
+				results[i].Err = errors.New(writeErr.Message)
+			}
+			if ordered {
+				skipRemaining(results, i)
+			}
+		}
+	case err != nil:
+		s.logger.Errorf("Failed to run batch: %v", err)
+		return mongoError(err)
+	}
+
+	// IDs were generated up front, hide the ones never written.
+	for i := range results {
+		if results[i].Err != nil {
+			results[i].ID = ""
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Batch(ctx context.Context, ops []storage.BatchOperation, ordered bool) ([]storage.BatchResult, error) {
+	s.logger.Infof("Running batch of %d operations, ordered: %t", len(ops), ordered)
+	return runBatch(ctx, s, ops, ordered)
+}
+
+func (s *SQLStorage) Batch(ctx context.Context, ops []storage.BatchOperation, ordered bool) ([]storage.BatchResult, error) {
+	s.logger.Infof("Running batch of %d operations, ordered: %t", len(ops), ordered)
+	return runBatch(ctx, s, ops, ordered)
+}
+
+// runBatch applies ops one by one for backends without a native bulk API.
+func runBatch(ctx context.Context, s storage.Storage, ops []storage.BatchOperation, ordered bool) ([]storage.BatchResult, error) {
+	if err := checkBatch(ops); err != nil {
+		return nil, err
+	}
+
+	results := make([]storage.BatchResult, len(ops))
+	for i, op := range ops {
+		if op.Type != storage.BatchCreate {
+			results[i].ID = op.Client.ID
+		}
+	}
+
+	for i, op := range ops {
+		var err error
+		if op.Type == storage.BatchCreate {
+			results[i].ID, err = s.Create(ctx, op.Client)
+		} else {
+			err = batchWrite(ctx, s, op)
+		}
+		results[i].Err = err
+		if err != nil && ordered {
+			skipRemaining(results, i)
+			break
+		}
+	}
+	return results, nil
+}
+
+func checkBatch(ops []storage.BatchOperation) error {
+	for _, op := range ops {
+		switch op.Type {
+		case storage.BatchCreate, storage.BatchUpdate, storage.BatchDelete:
+		default:
+			return fmt.Errorf("unknown batch operation %q", op.Type)
+		}
+	}
+	return nil
+}
+
+// batchWrite runs an update or delete of a batch. Both fail with
+// storage.ErrNotFound or storage.ErrVersionConflict when they match nothing.
+func batchWrite(ctx context.Context, s storage.Storage, op storage.BatchOperation) error {
+	if op.Type == storage.BatchDelete {
+		return s.Delete(ctx, op.Client.ID, storage.DeleteOptions{Version: op.Client.Version, DeletedBy: op.DeletedBy})
+	}
+	_, err := s.Update(ctx, op.Client)
+	return err
+}
+
+func skipRemaining(results []storage.BatchResult, failed int) {
+	for i := failed + 1; i < len(results); i++ {
+		results[i].Err = storage.ErrNotExecuted
+	}
+}
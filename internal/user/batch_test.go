@@ -0,0 +1,120 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"rest-api/internal/storage"
+	"testing"
+)
+
+func TestMemoryBatch(t *testing.T) {
+	ctx := context.Background()
+	missing := "0123456789abcdef01234567"
+
+	tests := []struct {
+		name    string
+		ordered bool
+		ops     func(bob storage.Client) []storage.BatchOperation
+		want    []error
+	}{
+		{
+			name:    "all succeed",
+			ordered: true,
+			ops: func(bob storage.Client) []storage.BatchOperation {
+				return []storage.BatchOperation{
+					{Type: storage.BatchCreate, Client: storage.Client{Email: "amy@example.com", Username: "amy"}},
+					{Type: storage.BatchUpdate, Client: storage.Client{ID: bob.ID, Version: bob.Version, Email: bob.Email, Username: "bobby"}},
+				}
+			},
+			want: []error{nil, nil},
+		},
+		{
+			name:    "ordered stops at the first failure",
+			ordered: true,
+			ops: func(bob storage.Client) []storage.BatchOperation {
+				return []storage.BatchOperation{
+					{Type: storage.BatchCreate, Client: storage.Client{Email: "amy@example.com", Username: "amy"}},
+					{Type: storage.BatchUpdate, Client: storage.Client{ID: missing, Email: "x@example.com", Username: "x"}},
+					{Type: storage.BatchDelete, Client: storage.Client{ID: bob.ID}},
+				}
+			},
+			want: []error{nil, storage.ErrNotFound, storage.ErrNotExecuted},
+		},
+		{
+			name:    "unordered continues",
+			ordered: false,
+			ops: func(bob storage.Client) []storage.BatchOperation {
+				return []storage.BatchOperation{
+					{Type: storage.BatchCreate, Client: storage.Client{Email: bob.Email, Username: "other"}},
+					{Type: storage.BatchUpdate, Client: storage.Client{ID: bob.ID, Version: bob.Version + 1, Email: bob.Email, Username: "bob"}},
+					{Type: storage.BatchDelete, Client: storage.Client{ID: bob.ID, Version: bob.Version}},
+				}
+			},
+			want: []error{storage.ErrDuplicateKey, storage.ErrVersionConflict, nil},
+		},
+		{
+			name:    "delete of a deleted user",
+			ordered: false,
+			ops: func(bob storage.Client) []storage.BatchOperation {
+				return []storage.BatchOperation{
+					{Type: storage.BatchDelete, Client: storage.Client{ID: bob.ID}},
+					{Type: storage.BatchDelete, Client: storage.Client{ID: bob.ID}},
+				}
+			},
+			want: []error{nil, storage.ErrNotFound},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMemory(t)
+			bob := mustCreate(t, s, storage.Client{Email: "bob@example.com", Username: "bob", PasswordHash: "hash"})
+
+			ops := tt.ops(bob)
+			results, err := s.Batch(ctx, ops, tt.ordered)
+			if err != nil {
+				t.Fatalf("Batch() error = %v", err)
+			}
+			if len(results) != len(tt.want) {
+				t.Fatalf("Batch() returned %d results, want %d", len(results), len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := results[i].Err
+				if (want == nil) != (got == nil) || want != nil && !errors.Is(got, want) {
+					t.Errorf("result %d error = %v, want %v", i, got, want)
+				}
+				if want == nil && results[i].ID == "" {
+					t.Errorf("result %d has no ID", i)
+				}
+				if errors.Is(got, storage.ErrNotExecuted) {
+					if _, err := s.FindOne(ctx, bob.ID); err != nil {
+						t.Errorf("skipped operation %d was applied: %v", i, err)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("keeps the password on update", func(t *testing.T) {
+		s := newMemory(t)
+		bob := mustCreate(t, s, storage.Client{Email: "bob@example.com", Username: "bob", PasswordHash: "hash"})
+
+		results, err := s.Batch(ctx, []storage.BatchOperation{
+			{Type: storage.BatchUpdate, Client: storage.Client{ID: bob.ID, Email: bob.Email, Username: "bobby"}},
+		}, true)
+		if err != nil || results[0].Err != nil {
+			t.Fatalf("Batch() error = %v, %v", err, results[0].Err)
+		}
+		updated, _ := s.FindOne(ctx, bob.ID)
+		if updated.PasswordHash != "hash" || updated.Username != "bobby" {
+			t.Errorf("updated user = %+v", updated)
+		}
+	})
+
+	t.Run("unknown operation", func(t *testing.T) {
+		s := newMemory(t)
+		if _, err := s.Batch(ctx, []storage.BatchOperation{{Type: "upsert"}}, true); err == nil {
+			t.Error("Batch() accepted an unknown operation")
+		}
+	})
+}
@@ -1,75 +1,124 @@
 package user
 
 import (
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"rest-api/internal/apperror"
+	"rest-api/internal/auth"
 	"rest-api/internal/handlers"
 	"rest-api/internal/storage"
 	"rest-api/pkg/metrics"
+	"rest-api/pkg/validate"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var _ handlers.Handler = &handler{}
 
 const (
-	usersURL = "/users"
-	userURL  = "/users/:uuid"
+	usersURL   = "/users"
+	userURL    = "/users/:uuid"
+	restoreURL = "/users/:uuid/restore"
+	exportURL  = "/exports/users"
+	batchURL   = "/batch/users"
+)
+
+const (
+	// exportFlushEvery controls how many exported rows are buffered before flushing.
+	exportFlushEvery = 100
+	maxBatchSize     = 1000
 )
 
 type handler struct {
-	logger  *logrus.Logger
-	storage storage.Storage
+	logger    *logrus.Logger
+	storage   storage.Storage
+	passwords handlers.Passwords
+	verifier  handlers.Verifier
+	auth      *auth.Middleware
 }
 
-func NewHandler(logger *logrus.Logger, storage storage.Storage) handlers.Handler {
+func NewHandler(logger *logrus.Logger, storage storage.Storage, passwords handlers.Passwords, verifier handlers.Verifier, auth *auth.Middleware) handlers.Handler {
 	return &handler{
-		logger:  logger,
-		storage: storage,
+		logger:    logger,
+		storage:   storage,
+		passwords: passwords,
+		verifier:  verifier,
+		auth:      auth,
 	}
 }
 
+// Register leaves only sign up open, every other route needs a bearer token.
+// Users may read and change their own record without any permission.
 func (h *handler) Register(router *httprouter.Router) {
-	router.GET(usersURL, metrics.PrometheusMiddleware(h.GetList, usersURL))
+	router.GET(usersURL, metrics.PrometheusMiddleware(h.auth.Handle(auth.Require(auth.UsersRead), h.GetList), usersURL))
 	router.POST(usersURL, metrics.PrometheusMiddleware(h.CreateUser, usersURL))
-	router.GET(userURL, metrics.PrometheusMiddleware(h.GetUserByUUID, usersURL))
-	router.PUT(userURL, h.UpdateUser)
-	router.PATCH(userURL, h.PartiallyUpdateUser)
-	router.DELETE(userURL, h.DeleteUser)
+	router.GET(userURL, metrics.PrometheusMiddleware(h.auth.Handle(auth.SelfOr(auth.UsersRead), h.GetUserByUUID), usersURL))
+	router.PUT(userURL, h.auth.Handle(auth.SelfOr(auth.UsersWrite), h.UpdateUser))
+	router.PATCH(userURL, h.auth.Handle(auth.SelfOr(auth.UsersWrite), h.PartiallyUpdateUser))
+	router.DELETE(userURL, h.auth.Handle(auth.SelfOr(auth.UsersWrite), h.DeleteUser))
+	router.POST(restoreURL, h.auth.Handle(auth.Require(auth.UsersWrite), h.RestoreUser))
+	router.GET(exportURL, metrics.PrometheusMiddleware(h.auth.Handle(auth.Require(auth.UsersRead), h.Export), exportURL))
+	router.POST(batchURL, metrics.PrometheusMiddleware(h.auth.Handle(auth.Require(auth.UsersWrite), h.Batch), batchURL))
 }
 
 func (h *handler) GetList(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	h.logger.Info("GetList called for users")
 
-	users, err := h.storage.GetAll(r.Context())
+	opts, err := handlers.ParseListOptions(r.URL.Query())
+	if err != nil {
+		h.logger.Errorf("Invalid list options: %v", err)
+		writeStorageError(w, r, err)
+		return
+	}
+
+	page, err := h.storage.GetAll(r.Context(), opts)
 	if err != nil {
 		h.logger.Errorf("Failed to get users: %v", err)
-		http.Error(w, "failed to get users", http.StatusInternalServerError)
+		writeStorageError(w, r, err)
 		return
 	}
 
+	handlers.SetLinkHeader(w, r, opts, page)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(users); err != nil {
+	if err := json.NewEncoder(w).Encode(handlers.NewListResponse(opts, page)); err != nil {
 		h.logger.Errorf("Failed to encode users list: %v", err)
-		http.Error(w, "failed to encode users", http.StatusInternalServerError)
 	}
 }
 
 func (h *handler) CreateUser(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	var user storage.Client
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	var req handlers.CreateUserRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
+		apperror.Write(w, r, err)
+		return
+	}
+	if err := h.passwords.Validate(req, validate.Create); err != nil {
+		apperror.Write(w, r, err)
+		return
+	}
+	user, err := h.passwords.Client(req.Input())
+	if err != nil {
+		h.logger.Errorf("Rejected password for new user: %v", err)
+		writeStorageError(w, r, err)
 		return
 	}
+	// Sign ups stay pending until the email is verified.
+	user.Status = storage.StatusPendingVerification
 	id, err := h.storage.Create(r.Context(), user)
 	if err != nil {
-		http.Error(w, "failed to create user", http.StatusInternalServerError)
+		h.logger.Errorf("Failed to create user: %v", err)
+		writeStorageError(w, r, err)
 		return
 	}
+	user.ID = id
+	h.verifier.SendVerification(user)
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{"id": id})
 }
@@ -82,19 +131,21 @@ func (h *handler) GetUserByUUID(w http.ResponseWriter, r *http.Request, params h
 	user, err := h.storage.FindOne(r.Context(), id)
 	if err != nil {
 		h.logger.Errorf("Failed to find user by ID %s: %v", id, err)
-		if err == mongo.ErrNoDocuments {
-			http.Error(w, "user not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "failed to get user", http.StatusInternalServerError)
-		}
+		writeStorageError(w, r, err)
+		return
+	}
+
+	etag := handlers.ETag(user.Version)
+	w.Header().Set("ETag", etag)
+	if handlers.IfNoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(user); err != nil {
+	if err := json.NewEncoder(w).Encode(handlers.NewUserResponse(user)); err != nil {
 		h.logger.Errorf("Failed to encode user: %v", err)
-		http.Error(w, "failed to encode user", http.StatusInternalServerError)
 	}
 }
 
@@ -104,26 +155,50 @@ func (h *handler) UpdateUser(w http.ResponseWriter, r *http.Request, params http
 	id := params.ByName("uuid")
 	h.logger.Infof("Attempting to update user with id: %s", id)
 
-	var user storage.Client
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	var req handlers.ReplaceUserRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
 		h.logger.Errorf("Invalid request body: %v", err)
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		apperror.Write(w, r, err)
+		return
+	}
+	if err := h.passwords.Validate(req, validate.Replace); err != nil {
+		apperror.Write(w, r, err)
+		return
+	}
+	user, err := h.passwords.Client(req.Input())
+	if err != nil {
+		h.logger.Errorf("Rejected password for user %s: %v", id, err)
+		writeStorageError(w, r, err)
+		return
+	}
+
+	version, err := handlers.IfMatchVersion(r)
+	if err != nil {
+		h.logger.Errorf("Precondition failed for user %s: %v", id, err)
+		writeStorageError(w, r, err)
 		return
 	}
 
 	user.ID = id
+	user.Version = version
 
 	h.logger.Infof("User data to be updated: %+v", user)
 
-	err := h.storage.Update(r.Context(), user)
+	previous, err := h.storage.FindOne(r.Context(), id)
+	if err == nil {
+		user, err = h.storage.Update(r.Context(), user)
+	}
 	if err != nil {
 		h.logger.Errorf("Failed to update user %s: %v", id, err)
-		http.Error(w, "failed to update user", http.StatusInternalServerError)
+		writeStorageError(w, r, err)
 		return
 	}
+	handlers.Reverify(h.verifier, previous, user)
 
 	h.logger.Info("User updated successfully")
-	w.WriteHeader(http.StatusNoContent)
+	if err := handlers.WriteUpdated(w, r, user); err != nil {
+		h.logger.Errorf("Failed to encode user: %v", err)
+	}
 }
 
 func (h *handler) PartiallyUpdateUser(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
@@ -131,45 +206,323 @@ func (h *handler) PartiallyUpdateUser(w http.ResponseWriter, r *http.Request, pa
 
 	id := params.ByName("uuid")
 
-	var user storage.Client
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		h.logger.Errorf("Invalid request body: %v", err)
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	patch, err := handlers.DecodePatch(w, r)
+	if err != nil {
+		h.logger.Errorf("Invalid patch: %v", err)
+		apperror.Write(w, r, err)
 		return
 	}
 
-	user.ID = id
+	version, err := handlers.IfMatchVersion(r)
+	if err != nil {
+		h.logger.Errorf("Precondition failed for user %s: %v", id, err)
+		writeStorageError(w, r, err)
+		return
+	}
 
-	err := h.storage.PartiallyUpdate(r.Context(), user)
+	user, previous, err := h.passwords.PatchUser(r.Context(), h.storage, id, version, patch)
 	if err != nil {
 		h.logger.Errorf("Failed to partially update user %s: %v", id, err)
-		http.Error(w, "failed to partially update user", http.StatusInternalServerError)
+		writeStorageError(w, r, err)
 		return
 	}
+	handlers.Reverify(h.verifier, previous, user)
 
-	w.WriteHeader(http.StatusNoContent)
+	if err := handlers.WriteUpdated(w, r, user); err != nil {
+		h.logger.Errorf("Failed to encode user: %v", err)
+	}
 }
 
 func (h *handler) DeleteUser(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	id := params.ByName("uuid")
 	h.logger.Infof("Attempting to delete user with id: %s", id)
 
-	objectID, err := primitive.ObjectIDFromHex(id)
+	version, err := handlers.IfMatchVersion(r)
 	if err != nil {
-		h.logger.Errorf("Invalid UUID format: %v", err)
-		http.Error(w, "invalid UUID format", http.StatusBadRequest)
+		h.logger.Errorf("Precondition failed for user %s: %v", id, err)
+		writeStorageError(w, r, err)
 		return
 	}
 
-	idString := objectID.Hex()
-
-	err = h.storage.Delete(r.Context(), idString)
+	principal, _ := auth.PrincipalFrom(r.Context())
+	err = h.storage.Delete(r.Context(), id, storage.DeleteOptions{Version: version, DeletedBy: principal.ID})
 	if err != nil {
 		h.logger.Errorf("Failed to delete user %s: %v", id, err)
-		http.Error(w, "failed to delete user", http.StatusInternalServerError)
+		writeStorageError(w, r, err)
 		return
 	}
 
 	h.logger.Infof("User %s deleted successfully", id)
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (h *handler) RestoreUser(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	id := params.ByName("uuid")
+	h.logger.Infof("Attempting to restore user with id: %s", id)
+
+	err := h.storage.Restore(r.Context(), id)
+	if err != nil {
+		h.logger.Errorf("Failed to restore user %s: %v", id, err)
+		writeStorageError(w, r, err)
+		return
+	}
+
+	h.logger.Infof("User %s restored successfully", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyBatch mails a verification token to the users a batch created, and
+// to those whose email an update changed, see handlers.Reverify.
+func (h *handler) verifyBatch(ctx context.Context, ops []storage.BatchOperation, results []storage.BatchResult, before map[int]storage.Client) {
+	for i, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		switch ops[i].Type {
+		case storage.BatchCreate:
+			client := ops[i].Client
+			client.ID = result.ID
+			h.verifier.SendVerification(client)
+		case storage.BatchUpdate:
+			previous, ok := before[i]
+			if !ok || strings.EqualFold(previous.Email, ops[i].Client.Email) {
+				continue
+			}
+			after, err := h.storage.FindOne(ctx, result.ID)
+			if err != nil {
+				h.logger.Errorf("Failed to fetch updated user %s: %v", result.ID, err)
+				continue
+			}
+			handlers.Reverify(h.verifier, previous, after)
+		}
+	}
+}
+
+// writeStorageError answers with the problem matching a storage or password
+// policy error.
+func writeStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	apperror.Write(w, r, apperror.FromStorage(err))
+}
+
+// Export streams every user matching the list filters as NDJSON (default)
+// or CSV, selected with ?format= or the Accept header.
+func (h *handler) Export(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	h.logger.Info("Export called for users")
+
+	opts, err := handlers.ParseListOptions(r.URL.Query())
+	if err != nil {
+		h.logger.Errorf("Invalid list options: %v", err)
+		writeStorageError(w, r, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" && strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		format = "csv"
+	}
+
+	var (
+		write func(storage.Client) error
+		flush = func() error { return nil }
+	)
+	switch format {
+	case "", "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="users.ndjson"`)
+		enc := json.NewEncoder(w)
+		write = func(user storage.Client) error { return enc.Encode(handlers.NewUserResponse(user)) }
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"id", "email", "username"}); err != nil {
+			h.logger.Errorf("Failed to write CSV header: %v", err)
+			return
+		}
+		write = func(user storage.Client) error {
+			return cw.Write([]string{user.ID, user.Email, user.Username})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		apperror.Write(w, r, fmt.Errorf("%w: %q, use ndjson or csv", apperror.ErrUnsupportedFormat, format))
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// An export can easily outlive the server wide write timeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.logger.Warnf("Failed to lift write deadline for export: %v", err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	var rows int
+	err = h.storage.Stream(r.Context(), opts, func(user storage.Client) error {
+		if err := write(user); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushEvery == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		if err = flush(); err == nil {
+			err = rc.Flush()
+		}
+	}
+	if err != nil {
+		// The status line is already sent, the client sees a truncated body.
+		h.logger.Errorf("Export aborted after %d users: %v", rows, err)
+		return
+	}
+
+	h.logger.Infof("Exported %d users", rows)
+}
+
+type batchRequest struct {
+	// Ordered defaults to true: the batch stops at the first failing operation.
+	Ordered    *bool            `json:"ordered"`
+	Operations []batchOperation `json:"operations"`
+}
+
+type batchOperation struct {
+	Op      storage.BatchOpType        `json:"op"`
+	ID      string                     `json:"id,omitempty"`
+	Version int64                      `json:"version,omitempty"`
+	User    handlers.CreateUserRequest `json:"user"`
+}
+
+type batchResult struct {
+	Index  int                 `json:"index"`
+	Op     storage.BatchOpType `json:"op"`
+	Status int                 `json:"status"`
+	ID     string              `json:"id,omitempty"`
+	Code   string              `json:"code,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+// Batch applies up to maxBatchSize create, update and delete operations and
+// reports an HTTP-like status for each of them.
+func (h *handler) Batch(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	h.logger.Info("Batch called for users")
+
+	var req batchRequest
+	if err := handlers.Decode(w, r, &req); err != nil {
+		h.logger.Errorf("Invalid request body: %v", err)
+		apperror.Write(w, r, err)
+		return
+	}
+	if len(req.Operations) == 0 || len(req.Operations) > maxBatchSize {
+		apperror.Write(w, r, apperror.New(http.StatusBadRequest, "invalid_batch_size", fmt.Sprintf("a batch must contain between 1 and %d operations", maxBatchSize)))
+		return
+	}
+
+	// Every operation is validated before any is applied, and all violations
+	// are reported together, their fields prefixed with the operation.
+	var invalid []apperror.FieldError
+	for i, op := range req.Operations {
+		mode := validate.Replace
+		switch op.Op {
+		case storage.BatchCreate:
+			mode = validate.Create
+		case storage.BatchUpdate:
+		default:
+			continue
+		}
+		err := h.passwords.Validate(op.User, mode)
+		if err == nil {
+			continue
+		}
+		var problem *apperror.AppError
+		if !errors.As(err, &problem) || len(problem.Fields) == 0 {
+			apperror.Write(w, r, fmt.Errorf("operation %d: %w", i, err))
+			return
+		}
+		for _, field := range problem.Fields {
+			field.Field = fmt.Sprintf("operations[%d].user.%s", i, field.Field)
+			invalid = append(invalid, field)
+		}
+	}
+	if len(invalid) > 0 {
+		apperror.Write(w, r, apperror.Invalid(invalid))
+		return
+	}
+
+	principal, _ := auth.PrincipalFrom(r.Context())
+	ops := make([]storage.BatchOperation, len(req.Operations))
+	for i, op := range req.Operations {
+		var client storage.Client
+		switch op.Op {
+		case storage.BatchCreate, storage.BatchUpdate:
+			var err error
+			if client, err = h.passwords.Client(op.User.Input()); err != nil {
+				apperror.Write(w, r, fmt.Errorf("operation %d: %w", i, apperror.FromStorage(err)))
+				return
+			}
+		case storage.BatchDelete:
+		default:
+			apperror.Write(w, r, apperror.New(http.StatusBadRequest, "unknown_operation", fmt.Sprintf("operation %d: unknown op %q", i, op.Op)))
+			return
+		}
+		if op.Op == storage.BatchCreate {
+			// Like sign ups, created users stay pending until the email is verified.
+			client.ID, client.Version, client.Status = "", 0, storage.StatusPendingVerification
+		} else {
+			client.ID, client.Version = op.ID, op.Version
+		}
+		ops[i] = storage.BatchOperation{Type: op.Op, Client: client, DeletedBy: principal.ID}
+	}
+
+	// Updated users are read ahead to tell whether their email changes.
+	before := make(map[int]storage.Client)
+	for i, op := range ops {
+		if op.Type != storage.BatchUpdate {
+			continue
+		}
+		if client, err := h.storage.FindOne(r.Context(), op.Client.ID); err == nil {
+			before[i] = client
+		}
+	}
+
+	ordered := req.Ordered == nil || *req.Ordered
+	results, err := h.storage.Batch(r.Context(), ops, ordered)
+	if err != nil {
+		h.logger.Errorf("Failed to run batch: %v", err)
+		writeStorageError(w, r, err)
+		return
+	}
+	h.verifyBatch(r.Context(), ops, results, before)
+
+	response := make([]batchResult, len(results))
+	for i, result := range results {
+		response[i] = batchResult{Index: i, Op: ops[i].Type, ID: result.ID}
+		switch {
+		case result.Err == nil && ops[i].Type == storage.BatchCreate:
+			response[i].Status = http.StatusCreated
+		case result.Err == nil:
+			response[i].Status = http.StatusNoContent
+		case errors.Is(result.Err, storage.ErrNotExecuted):
+			response[i].Status = http.StatusFailedDependency
+			response[i].Code = "not_executed"
+			response[i].Error = result.Err.Error()
+		default:
+			problem := apperror.From(apperror.FromStorage(result.Err))
+			response[i].Status = problem.Status
+			response[i].Code = problem.Code
+			response[i].Error = problem.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string][]batchResult{"results": response}); err != nil {
+		h.logger.Errorf("Failed to encode batch results: %v", err)
+	}
+}
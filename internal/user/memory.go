@@ -0,0 +1,427 @@
+package user
+
+import (
+	"context"
+	"rest-api/internal/storage"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStorage keeps users in process memory. It is meant for local
+// development and tests and mirrors the behaviour of MongoStorage.
+type MemoryStorage struct {
+	mu     sync.RWMutex
+	users  map[string]storage.Client
+	logger *logrus.Logger
+}
+
+func NewMemoryStorage(logger *logrus.Logger) *MemoryStorage {
+	logger.Info("Initializing MemoryStorage")
+	return &MemoryStorage{
+		users:  make(map[string]storage.Client),
+		logger: logger,
+	}
+}
+
+func (s *MemoryStorage) GetAll(ctx context.Context, opts storage.ListOptions) (storage.Page, error) {
+	s.logger.Infof("Fetching users from memory: %+v", opts)
+
+	var page storage.Page
+	if err := opts.Validate(); err != nil {
+		return page, err
+	}
+
+	keys := opts.SortKeys()
+	var after *storage.Cursor
+	if opts.Cursor != "" {
+		cursor, err := storage.DecodeCursor(opts.Cursor, keys)
+		if err != nil {
+			return page, err
+		}
+		after = &cursor
+	}
+
+	s.mu.RLock()
+	users := make([]storage.Client, 0, len(s.users))
+	for _, user := range s.users {
+		if matchFilters(user, opts) {
+			users = append(users, user)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool { return compareKeys(users[i], users[j], keys) < 0 })
+	page.Total = int64(len(users))
+
+	if after != nil {
+		start := sort.Search(len(users), func(i int) bool { return compareCursor(users[i], *after, keys) > 0 })
+		users = users[start:]
+	} else {
+		users = users[min(opts.Offset, len(users)):]
+	}
+
+	limit := opts.PageSize()
+	if len(users) > limit {
+		users = users[:limit]
+		page.NextCursor = storage.EncodeCursor(keys, users[limit-1])
+	}
+	page.Items = users
+
+	s.logger.Infof("Successfully fetched %d of %d users", len(users), page.Total)
+	return page, nil
+}
+
+func (s *MemoryStorage) Stream(ctx context.Context, opts storage.ListOptions, fn func(storage.Client) error) error {
+	s.logger.Infof("Streaming users from memory: %+v", opts)
+
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	users := make([]storage.Client, 0, len(s.users))
+	for _, user := range s.users {
+		if matchFilters(user, opts) {
+			users = append(users, user)
+		}
+	}
+	s.mu.RUnlock()
+
+	keys := opts.SortKeys()
+	sort.Slice(users, func(i, j int) bool { return compareKeys(users[i], users[j], keys) < 0 })
+
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Infof("Successfully streamed %d users", len(users))
+	return nil
+}
+
+func (s *MemoryStorage) Create(ctx context.Context, client storage.Client) (string, error) {
+	s.logger.Infof("Creating a new user: %+v", client)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUnique(client, ""); err != nil {
+		s.logger.Errorf("Failed to insert user: %v", err)
+		return "", err
+	}
+
+	client.ID = primitive.NewObjectID().Hex()
+	client.Version = 1
+	if client.Status == "" {
+		client.Status = storage.StatusActive
+	}
+	s.users[client.ID] = client
+
+	s.logger.Infof("User created successfully with ID: %s", client.ID)
+	return client.ID, nil
+}
+
+func (s *MemoryStorage) FindOne(ctx context.Context, id string) (storage.Client, error) {
+	s.logger.Infof("Fetching user with ID: %s", id)
+
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return storage.Client{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.live(objectID.Hex())
+	if !ok {
+		s.logger.Warnf("User with ID %s not found", id)
+		return storage.Client{}, storage.ErrNotFound
+	}
+
+	s.logger.Infof("User found: %+v", user)
+	return user, nil
+}
+
+func (s *MemoryStorage) FindByLogin(ctx context.Context, login string) (storage.Client, error) {
+	field := storage.LoginField(login)
+	s.logger.Infof("Fetching user by %s", field)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.DeletedAt == nil && strings.EqualFold(user.FieldValue(field), login) {
+			s.logger.Infof("User found: %s", user.ID)
+			return user, nil
+		}
+	}
+
+	s.logger.Warnf("User with that %s not found", field)
+	return storage.Client{}, storage.ErrNotFound
+}
+
+func (s *MemoryStorage) Update(ctx context.Context, client storage.Client) (storage.Client, error) {
+	s.logger.Infof("Updating user with ID: %s", client.ID)
+
+	objectID, err := parseObjectID(client.ID)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return storage.Client{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := objectID.Hex()
+	current, ok := s.live(id)
+	if !ok {
+		s.logger.Warnf("User with ID %s not found", id)
+		return storage.Client{}, storage.ErrNotFound
+	}
+	if err := s.checkVersion(current, client.Version); err != nil {
+		return storage.Client{}, err
+	}
+	if err := s.checkUnique(client, id); err != nil {
+		s.logger.Errorf("Failed to update user: %v", err)
+		return storage.Client{}, err
+	}
+	passwordHash := current.PasswordHash
+	if client.PasswordHash != "" {
+		passwordHash = client.PasswordHash
+	}
+	user := storage.Client{
+		ID:           id,
+		Email:        client.Email,
+		Username:     client.Username,
+		PasswordHash: passwordHash,
+		Version:      current.Version + 1,
+		Roles:        current.Roles,
+		Status:       storage.StatusAfterEmailChange(current.Status, current.Email, client.Email),
+	}
+	s.users[id] = user
+
+	s.logger.Infof("User updated successfully, version: %d", user.Version)
+	return user, nil
+}
+
+func (s *MemoryStorage) PartiallyUpdate(ctx context.Context, patch storage.Patch) (storage.Client, error) {
+	s.logger.Infof("Partially updating user with ID: %s", patch.ID)
+
+	objectID, err := parseObjectID(patch.ID)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return storage.Client{}, err
+	}
+	if err := patch.Check(); err != nil {
+		return storage.Client{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := objectID.Hex()
+	user, ok := s.live(id)
+	if !ok {
+		s.logger.Warnf("User with ID %s not found", id)
+		return storage.Client{}, storage.ErrNotFound
+	}
+	if err := s.checkVersion(user, patch.Version); err != nil {
+		return storage.Client{}, err
+	}
+	if email, ok := patch.Set[storage.FieldEmail]; ok {
+		user.Status = storage.StatusAfterEmailChange(user.Status, user.Email, email)
+	}
+	for field, value := range patch.Set {
+		setField(&user, field, value)
+	}
+	if err := s.checkUnique(user, id); err != nil {
+		s.logger.Errorf("Failed to partially update user: %v", err)
+		return storage.Client{}, err
+	}
+	user.Version++
+	s.users[id] = user
+
+	s.logger.Infof("User partially updated successfully, version: %d", user.Version)
+	return user, nil
+}
+
+func setField(user *storage.Client, field, value string) {
+	switch field {
+	case storage.FieldEmail:
+		user.Email = value
+	case storage.FieldUsername:
+		user.Username = value
+	case storage.FieldPassword:
+		user.PasswordHash = value
+	}
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, id string, opts storage.DeleteOptions) error {
+	s.logger.Infof("Deleting user with ID: %s", id)
+
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.live(objectID.Hex())
+	if !ok {
+		s.logger.Warnf("User with ID %s not found", id)
+		return storage.ErrNotFound
+	}
+	if err := s.checkVersion(user, opts.Version); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	user.DeletedAt = &now
+	user.DeletedBy = opts.DeletedBy
+	user.Version++
+	s.users[user.ID] = user
+
+	s.logger.Infof("User %s moved to trash", id)
+	return nil
+}
+
+func (s *MemoryStorage) Restore(ctx context.Context, id string) error {
+	s.logger.Infof("Restoring user with ID: %s", id)
+
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[objectID.Hex()]
+	if !ok || user.DeletedAt == nil {
+		s.logger.Warnf("User with ID %s not found in trash", id)
+		return storage.ErrNotFound
+	}
+	if err := s.checkUnique(user, user.ID); err != nil {
+		s.logger.Warnf("User %s can not be restored: %v", id, err)
+		return err
+	}
+
+	user.DeletedAt = nil
+	user.DeletedBy = ""
+	user.Version++
+	s.users[user.ID] = user
+
+	s.logger.Infof("User %s restored successfully", id)
+	return nil
+}
+
+func (s *MemoryStorage) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	s.logger.Infof("Purging users deleted before %s", deletedBefore.Format(time.RFC3339))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for id, user := range s.users {
+		if user.DeletedAt != nil && user.DeletedAt.Before(deletedBefore) {
+			delete(s.users, id)
+			purged++
+		}
+	}
+
+	s.logger.Infof("Users purged successfully, deleted count: %d", purged)
+	return purged, nil
+}
+
+// live returns the client with the given id unless it is trashed.
+// The caller must hold the lock.
+func (s *MemoryStorage) live(id string) (storage.Client, bool) {
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt != nil {
+		return storage.Client{}, false
+	}
+	return user, true
+}
+
+func (s *MemoryStorage) checkVersion(current storage.Client, version int64) error {
+	if version > 0 && current.Version != version {
+		s.logger.Warnf("User with ID %s was modified concurrently", current.ID)
+		return storage.ErrVersionConflict
+	}
+	return nil
+}
+
+// checkUnique mirrors the case-insensitive unique indexes created by the mongo
+// migrations, which leave out trashed users. The caller must hold the write lock.
+func (s *MemoryStorage) checkUnique(client storage.Client, exceptID string) error {
+	for id, user := range s.users {
+		if id == exceptID || user.DeletedAt != nil {
+			continue
+		}
+		if strings.EqualFold(user.Email, client.Email) {
+			return &storage.ConflictError{Field: "email"}
+		}
+		if strings.EqualFold(user.Username, client.Username) {
+			return &storage.ConflictError{Field: "username"}
+		}
+	}
+	return nil
+}
+
+func matchFilters(user storage.Client, opts storage.ListOptions) bool {
+	if (user.DeletedAt != nil) != opts.Deleted {
+		return false
+	}
+	for _, f := range opts.Filters {
+		value := user.FieldValue(f.Field)
+		switch f.Op {
+		case storage.FilterEqual:
+			if value != f.Value {
+				return false
+			}
+		case storage.FilterPrefix:
+			if !strings.HasPrefix(value, f.Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func compareKeys(a, b storage.Client, keys []storage.SortField) int {
+	for _, k := range keys {
+		c := strings.Compare(a.FieldValue(k.Field), b.FieldValue(k.Field))
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareCursor(user storage.Client, cursor storage.Cursor, keys []storage.SortField) int {
+	for i, k := range keys {
+		c := strings.Compare(user.FieldValue(k.Field), cursor.Values[i])
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
@@ -0,0 +1,125 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"io"
+	"rest-api/internal/storage"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newMemory(t *testing.T) *MemoryStorage {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewMemoryStorage(logger)
+}
+
+func mustCreate(t *testing.T, s *MemoryStorage, client storage.Client) storage.Client {
+	t.Helper()
+	id, err := s.Create(context.Background(), client)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	created, err := s.FindOne(context.Background(), id)
+	if err != nil {
+		t.Fatalf("FindOne() error = %v", err)
+	}
+	return created
+}
+
+func TestMemoryPartiallyUpdate(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		version int64
+		set     map[string]string
+		want    storage.Client
+		err     error
+	}{
+		{
+			name: "username",
+			set:  map[string]string{storage.FieldUsername: "bobby"},
+			want: storage.Client{Email: "bob@example.com", Username: "bobby", PasswordHash: "hash", Status: storage.StatusActive},
+		},
+		{
+			name: "password",
+			set:  map[string]string{storage.FieldPassword: "new"},
+			want: storage.Client{Email: "bob@example.com", Username: "bob", PasswordHash: "new", Status: storage.StatusActive},
+		},
+		{
+			name: "nothing",
+			set:  map[string]string{},
+			want: storage.Client{Email: "bob@example.com", Username: "bob", PasswordHash: "hash", Status: storage.StatusActive},
+		},
+		{
+			name: "email",
+			set:  map[string]string{storage.FieldEmail: "Bob@Example.com"},
+			want: storage.Client{Email: "Bob@Example.com", Username: "bob", PasswordHash: "hash", Status: storage.StatusActive},
+		},
+		{
+			name:    "matching version",
+			version: 1,
+			set:     map[string]string{storage.FieldUsername: "bobby"},
+			want:    storage.Client{Email: "bob@example.com", Username: "bobby", PasswordHash: "hash", Status: storage.StatusActive},
+		},
+		{
+			name:    "stale version",
+			version: 2,
+			set:     map[string]string{storage.FieldUsername: "bobby"},
+			err:     storage.ErrVersionConflict,
+		},
+		{
+			name: "taken username",
+			set:  map[string]string{storage.FieldUsername: "AMY"},
+			err:  storage.ErrDuplicateKey,
+		},
+		{
+			name: "unknown field",
+			set:  map[string]string{"status": storage.StatusActive},
+			err:  storage.ErrUnknownField,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMemory(t)
+			bob := mustCreate(t, s, storage.Client{Email: "bob@example.com", Username: "bob", PasswordHash: "hash"})
+			mustCreate(t, s, storage.Client{Email: "amy@example.com", Username: "amy"})
+
+			got, err := s.PartiallyUpdate(ctx, storage.Patch{ID: bob.ID, Version: tt.version, Set: tt.set})
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("PartiallyUpdate() error = %v, want %v", err, tt.err)
+				}
+				if stored, _ := s.FindOne(ctx, bob.ID); stored.Version != bob.Version || stored.Username != bob.Username {
+					t.Errorf("failed patch changed the user to %+v", stored)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PartiallyUpdate() error = %v", err)
+			}
+
+			tt.want.ID, tt.want.Version = bob.ID, bob.Version+1
+			if got.ID != tt.want.ID || got.Email != tt.want.Email || got.Username != tt.want.Username ||
+				got.PasswordHash != tt.want.PasswordHash || got.Status != tt.want.Status || got.Version != tt.want.Version {
+				t.Errorf("PartiallyUpdate() = %+v, want %+v", got, tt.want)
+			}
+			if stored, _ := s.FindOne(ctx, bob.ID); stored.Version != got.Version || stored.Username != got.Username {
+				t.Errorf("stored user = %+v, returned %+v", stored, got)
+			}
+		})
+	}
+
+	t.Run("missing user", func(t *testing.T) {
+		s := newMemory(t)
+		_, err := s.PartiallyUpdate(ctx, storage.Patch{ID: "0123456789abcdef01234567", Set: map[string]string{storage.FieldUsername: "x"}})
+		if !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("PartiallyUpdate() error = %v, want %v", err, storage.ErrNotFound)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"rest-api/internal/storage"
+	"testing"
+)
+
+func TestRestore(t *testing.T) {
+	backends := []struct {
+		name string
+		new  func(t *testing.T) storage.Storage
+	}{
+		{"memory", func(t *testing.T) storage.Storage { return newMemory(t) }},
+		{"sql", func(t *testing.T) storage.Storage { return newSQL(t, openSQL(t)) }},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := backend.new(t)
+
+			bob, err := s.Create(ctx, storage.Client{Email: "bob@example.com", Username: "bob"})
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if err := s.Delete(ctx, bob, storage.DeleteOptions{}); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+
+			// A trashed user does not hold on to its email and username.
+			robert, err := s.Create(ctx, storage.Client{Email: "BOB@example.com", Username: "Bob"})
+			if err != nil {
+				t.Fatalf("Create() reusing a trashed email error = %v", err)
+			}
+			var conflict *storage.ConflictError
+			if err := s.Restore(ctx, bob); !errors.As(err, &conflict) {
+				t.Fatalf("Restore() error = %v, want a conflict", err)
+			}
+			if _, err := s.FindOne(ctx, bob); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("FindOne() after the failed restore error = %v, want %v", err, storage.ErrNotFound)
+			}
+
+			if err := s.Delete(ctx, robert, storage.DeleteOptions{}); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if err := s.Restore(ctx, bob); err != nil {
+				t.Fatalf("Restore() error = %v", err)
+			}
+			if err := s.Restore(ctx, bob); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("second Restore() error = %v, want %v", err, storage.ErrNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rest-api/internal/storage"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *MongoStorage) GrantRole(ctx context.Context, id, role string) error {
+	s.logger.Infof("Granting role %s to user %s", role, id)
+	return s.changeRole(ctx, id, bson.M{"$ne": role}, bson.M{"$addToSet": bson.M{"roles": role}})
+}
+
+func (s *MongoStorage) RevokeRole(ctx context.Context, id, role string) error {
+	s.logger.Infof("Revoking role %s from user %s", role, id)
+	return s.changeRole(ctx, id, role, bson.M{"$pull": bson.M{"roles": role}})
+}
+
+// changeRole applies update when the roles of the user match rolesFilter, so
+// that the version is only bumped when the roles actually change.
+func (s *MongoStorage) changeRole(ctx context.Context, id string, rolesFilter any, update bson.M) error {
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return err
+	}
+
+	update["$inc"] = bson.M{"version": 1}
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": objectID, "deletedAt": nil, "roles": rolesFilter}, update)
+	if err != nil {
+		s.logger.Errorf("Failed to change roles: %v", err)
+		return mongoError(err)
+	}
+	if result.MatchedCount == 0 {
+		return s.exists(ctx, objectID)
+	}
+
+	s.logger.Infof("Roles of user %s changed", id)
+	return nil
+}
+
+// exists returns storage.ErrNotFound unless a live user has the given id.
+func (s *MongoStorage) exists(ctx context.Context, objectID primitive.ObjectID) error {
+	count, err := s.collection.CountDocuments(ctx, bson.M{"_id": objectID, "deletedAt": nil})
+	if err != nil {
+		return mongoError(err)
+	}
+	if count == 0 {
+		s.logger.Warnf("User with ID %s not found", objectID.Hex())
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (s *MemoryStorage) GrantRole(ctx context.Context, id, role string) error {
+	s.logger.Infof("Granting role %s to user %s", role, id)
+	return s.changeRole(id, func(roles []string) []string {
+		if slices.Contains(roles, role) {
+			return nil
+		}
+		return append(slices.Clone(roles), role)
+	})
+}
+
+func (s *MemoryStorage) RevokeRole(ctx context.Context, id, role string) error {
+	s.logger.Infof("Revoking role %s from user %s", role, id)
+	return s.changeRole(id, func(roles []string) []string {
+		if !slices.Contains(roles, role) {
+			return nil
+		}
+		return slices.DeleteFunc(slices.Clone(roles), func(r string) bool { return r == role })
+	})
+}
+
+// changeRole stores the roles returned by change, which returns nil when
+// there is nothing to change.
+func (s *MemoryStorage) changeRole(id string, change func(roles []string) []string) error {
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.live(objectID.Hex())
+	if !ok {
+		s.logger.Warnf("User with ID %s not found", id)
+		return storage.ErrNotFound
+	}
+	roles := change(user.Roles)
+	if roles == nil {
+		return nil
+	}
+	if len(roles) == 0 {
+		roles = nil
+	}
+	user.Roles = roles
+	user.Version++
+	s.users[user.ID] = user
+
+	s.logger.Infof("Roles of user %s changed", id)
+	return nil
+}
+
+func (s *SQLStorage) GrantRole(ctx context.Context, id, role string) error {
+	s.logger.Infof("Granting role %s to user %s", role, id)
+	return s.changeRole(ctx, id, func(roles []string) []string {
+		if slices.Contains(roles, role) {
+			return nil
+		}
+		return append(roles, role)
+	})
+}
+
+func (s *SQLStorage) RevokeRole(ctx context.Context, id, role string) error {
+	s.logger.Infof("Revoking role %s from user %s", role, id)
+	return s.changeRole(ctx, id, func(roles []string) []string {
+		if !slices.Contains(roles, role) {
+			return nil
+		}
+		return slices.DeleteFunc(roles, func(r string) bool { return r == role })
+	})
+}
+
+// changeRole reads and rewrites the roles column in one transaction.
+func (s *SQLStorage) changeRole(ctx context.Context, id string, change func(roles []string) []string) error {
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var raw string
+	err = tx.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT roles FROM %s WHERE id = ? AND deleted_at IS NULL`, s.table),
+		objectID.Hex(),
+	).Scan(&raw)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.Warnf("User with ID %s not found", id)
+		} else {
+			s.logger.Errorf("Failed to load roles: %v", err)
+		}
+		return sqlError(err)
+	}
+
+	roles := change(decodeRoles(raw))
+	if roles == nil {
+		return nil
+	}
+	_, err = tx.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE %s SET roles = ?, version = version + 1 WHERE id = ?`, s.table),
+		encodeRoles(roles), objectID.Hex(),
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to change roles: %v", err)
+		return sqlError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.logger.Infof("Roles of user %s changed", id)
+	return nil
+}
+
+// Roles are stored as a JSON array in a TEXT column.
+func encodeRoles(roles []string) string {
+	if len(roles) == 0 {
+		return "[]"
+	}
+	raw, _ := json.Marshal(roles)
+	return string(raw)
+}
+
+func decodeRoles(raw string) []string {
+	var roles []string
+	if err := json.Unmarshal([]byte(raw), &roles); err != nil || len(roles) == 0 {
+		return nil
+	}
+	return roles
+}
@@ -0,0 +1,621 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"rest-api/internal/storage"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type SQLStorage struct {
+	db     *sql.DB
+	name   string
+	table  string
+	logger *logrus.Logger
+}
+
+func NewSQLStorage(ctx context.Context, db *sql.DB, table string, logger *logrus.Logger) (*SQLStorage, error) {
+	logger.Infof("Initializing SQLStorage for table: %s", table)
+
+	s := &SQLStorage{
+		db:     db,
+		name:   table,
+		table:  quoteIdent(table),
+		logger: logger,
+	}
+	if err := s.bootstrap(ctx); err != nil {
+		logger.Errorf("Failed to bootstrap schema: %v", err)
+		return nil, err
+	}
+	return s, nil
+}
+
+// clientColumns lists the columns scanned by scanClient, in order.
+const clientColumns = "id, email, username, password, version, roles, status, deleted_at, deleted_by"
+
+// reverifyColumn moves an active user back to pending verification when the
+// email changes, see storage.StatusAfterEmailChange. It takes the active
+// status, the new email and the pending status as arguments. SQLite
+// evaluates every SET expression against the row before the update.
+const reverifyColumn = "status = CASE WHEN status = ? AND lower(email) <> lower(?) THEN ? ELSE status END"
+
+// tableColumns declares the users table. Email and username ignore case only
+// in their unique indexes, which like the mongo ones leave out trashed users.
+const tableColumns = `(
+		id         TEXT PRIMARY KEY,
+		email      TEXT NOT NULL,
+		username   TEXT NOT NULL,
+		password   TEXT NOT NULL DEFAULT '',
+		version    INTEGER NOT NULL DEFAULT 1,
+		roles      TEXT NOT NULL DEFAULT '[]',
+		status     TEXT NOT NULL DEFAULT 'active',
+		deleted_at TIMESTAMP NULL,
+		deleted_by TEXT NOT NULL DEFAULT ''
+	)`
+
+// streamPageSize is how many users Stream reads per query.
+const streamPageSize = storage.MaxLimit
+
+func (s *SQLStorage) bootstrap(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s %s`, s.table, tableColumns))
+	if err != nil {
+		return err
+	}
+
+	// Tables created by older releases lack the columns added since.
+	for _, column := range []struct{ name, definition string }{
+		{"version", "INTEGER NOT NULL DEFAULT 1"},
+		{"deleted_at", "TIMESTAMP NULL"},
+		{"deleted_by", "TEXT NOT NULL DEFAULT ''"},
+		{"roles", "TEXT NOT NULL DEFAULT '[]'"},
+		{"status", "TEXT NOT NULL DEFAULT 'active'"},
+	} {
+		if err := s.ensureColumn(ctx, column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	if err := s.dropColumnCollation(ctx); err != nil {
+		return err
+	}
+
+	for _, column := range []string{"email", "username"} {
+		_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s COLLATE NOCASE) WHERE deleted_at IS NULL`,
+			quoteIdent(s.name+"_"+column+"_unique"), s.table, column))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropColumnCollation rebuilds tables of older releases, whose email and
+// username columns were declared COLLATE NOCASE UNIQUE and so also filtered
+// and sorted without case.
+func (s *SQLStorage) dropColumnCollation(ctx context.Context) error {
+	var schema string
+	err := s.db.QueryRowContext(ctx, `SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?`, s.name).Scan(&schema)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(strings.ToUpper(schema), "NOCASE") {
+		return nil
+	}
+
+	s.logger.Infof("Rebuilding table %s without column collation", s.table)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rebuilt := quoteIdent(s.name + "_rebuild")
+	for _, stmt := range []string{
+		fmt.Sprintf(`CREATE TABLE %s %s`, rebuilt, tableColumns),
+		fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM %s`, rebuilt, clientColumns, clientColumns, s.table),
+		fmt.Sprintf(`DROP TABLE %s`, s.table),
+		fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, rebuilt, s.table),
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStorage) ensureColumn(ctx context.Context, column, definition string) error {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`PRAGMA table_info(%s)`, s.table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	// Release the connection before altering, SQLite runs with a single one.
+	rows.Close()
+
+	s.logger.Infof("Adding column %s to table %s", column, s.table)
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, s.table, column, definition))
+	return err
+}
+
+func (s *SQLStorage) GetAll(ctx context.Context, opts storage.ListOptions) (storage.Page, error) {
+	s.logger.Infof("Fetching users from the database: %+v", opts)
+
+	var page storage.Page
+	if err := opts.Validate(); err != nil {
+		return page, err
+	}
+
+	where, args := sqlFilter(opts)
+	err := s.db.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT COUNT(*) FROM %s%s`, s.table, whereClause(where)),
+		args...,
+	).Scan(&page.Total)
+	if err != nil {
+		s.logger.Errorf("Failed to count users: %v", err)
+		return page, err
+	}
+
+	keys := opts.SortKeys()
+	if opts.Cursor != "" {
+		cursor, err := storage.DecodeCursor(opts.Cursor, keys)
+		if err != nil {
+			return page, err
+		}
+		after, afterArgs := sqlKeyset(keys, cursor)
+		where = append(where, after)
+		args = append(args, afterArgs...)
+	}
+
+	limit := opts.PageSize()
+	users, err := s.query(ctx, where, args, keys, limit+1, opts.Offset)
+	if err != nil {
+		return page, err
+	}
+
+	if len(users) > limit {
+		users = users[:limit]
+		page.NextCursor = storage.EncodeCursor(keys, users[limit-1])
+	}
+	page.Items = users
+
+	s.logger.Infof("Successfully fetched %d of %d users", len(users), page.Total)
+	return page, nil
+}
+
+// Stream reads streamPageSize users at a time and hands them to fn only
+// after the page is read, so that the connection is not held while fn
+// waits on a slow client. SQLite runs with a single one.
+func (s *SQLStorage) Stream(ctx context.Context, opts storage.ListOptions, fn func(storage.Client) error) error {
+	s.logger.Infof("Streaming users from the database: %+v", opts)
+
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	keys := opts.SortKeys()
+	filter, filterArgs := sqlFilter(opts)
+	where, args := filter, filterArgs
+
+	var count int
+	for {
+		users, err := s.query(ctx, where, args, keys, streamPageSize, 0)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if err := fn(user); err != nil {
+				return err
+			}
+			count++
+		}
+		if len(users) < streamPageSize {
+			break
+		}
+
+		last := users[len(users)-1]
+		cursor := storage.Cursor{}
+		for _, k := range keys {
+			cursor.Values = append(cursor.Values, last.FieldValue(k.Field))
+		}
+		after, afterArgs := sqlKeyset(keys, cursor)
+		where = append(filter[:len(filter):len(filter)], after)
+		args = append(filterArgs[:len(filterArgs):len(filterArgs)], afterArgs...)
+	}
+
+	s.logger.Infof("Successfully streamed %d users", count)
+	return nil
+}
+
+// query returns up to limit users matching where, in the order of keys.
+func (s *SQLStorage) query(ctx context.Context, where []string, args []any, keys []storage.SortField, limit, offset int) ([]storage.Client, error) {
+	rows, err := s.db.QueryContext(ctx,
+		fmt.Sprintf(`SELECT %s FROM %s%s ORDER BY %s LIMIT ? OFFSET ?`,
+			clientColumns, s.table, whereClause(where), sqlOrder(keys)),
+		append(args[:len(args):len(args)], limit, offset)...,
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to fetch users: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]storage.Client, 0, limit)
+	for rows.Next() {
+		user, err := scanClient(rows)
+		if err != nil {
+			s.logger.Errorf("Failed to decode user: %v", err)
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		s.logger.Errorf("Rows error while fetching users: %v", err)
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *SQLStorage) Create(ctx context.Context, client storage.Client) (string, error) {
+	s.logger.Infof("Creating a new user: %+v", client)
+
+	if client.Status == "" {
+		client.Status = storage.StatusActive
+	}
+	id := primitive.NewObjectID().Hex()
+	_, err := s.db.ExecContext(ctx,
+		fmt.Sprintf(`INSERT INTO %s (id, email, username, password, version, roles, status) VALUES (?, ?, ?, ?, 1, ?, ?)`, s.table),
+		id, client.Email, client.Username, client.PasswordHash, encodeRoles(client.Roles), client.Status,
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to insert user: %v", err)
+		return "", sqlError(err)
+	}
+
+	s.logger.Infof("User created successfully with ID: %s", id)
+	return id, nil
+}
+
+func (s *SQLStorage) FindOne(ctx context.Context, id string) (storage.Client, error) {
+	s.logger.Infof("Fetching user with ID: %s", id)
+
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return storage.Client{}, err
+	}
+
+	user, err := scanClient(s.db.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT %s FROM %s WHERE id = ? AND deleted_at IS NULL`, clientColumns, s.table),
+		objectID.Hex(),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.Warnf("User with ID %s not found", id)
+		} else {
+			s.logger.Errorf("Failed to fetch user: %v", err)
+		}
+		return user, sqlError(err)
+	}
+
+	s.logger.Infof("User found: %+v", user)
+	return user, nil
+}
+
+func (s *SQLStorage) FindByLogin(ctx context.Context, login string) (storage.Client, error) {
+	field := storage.LoginField(login)
+	s.logger.Infof("Fetching user by %s", field)
+
+	user, err := scanClient(s.db.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT %s FROM %s WHERE %s = ? COLLATE NOCASE AND deleted_at IS NULL`, clientColumns, s.table, field),
+		login,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.Warnf("User with that %s not found", field)
+		} else {
+			s.logger.Errorf("Failed to fetch user: %v", err)
+		}
+		return user, sqlError(err)
+	}
+
+	s.logger.Infof("User found: %s", user.ID)
+	return user, nil
+}
+
+func (s *SQLStorage) Update(ctx context.Context, client storage.Client) (storage.Client, error) {
+	s.logger.Infof("Updating user with ID: %s", client.ID)
+
+	objectID, err := parseObjectID(client.ID)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return storage.Client{}, err
+	}
+
+	// An empty hash keeps the current password.
+	where, args := versionCondition(objectID, client.Version)
+	user, err := scanClient(s.db.QueryRowContext(ctx,
+		fmt.Sprintf(`UPDATE %s SET %s, email = ?, username = ?, password = COALESCE(NULLIF(?, ''), password), version = version + 1 WHERE %s RETURNING %s`, s.table, reverifyColumn, where, clientColumns),
+		append([]any{storage.StatusActive, client.Email, storage.StatusPendingVerification, client.Email, client.Username, client.PasswordHash}, args...)...,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, s.versionMismatch(ctx, objectID)
+	}
+	if err != nil {
+		s.logger.Errorf("Failed to update user: %v", err)
+		return user, sqlError(err)
+	}
+
+	s.logger.Infof("User updated successfully, version: %d", user.Version)
+	return user, nil
+}
+
+func (s *SQLStorage) PartiallyUpdate(ctx context.Context, patch storage.Patch) (storage.Client, error) {
+	s.logger.Infof("Partially updating user with ID: %s", patch.ID)
+
+	objectID, err := parseObjectID(patch.ID)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return storage.Client{}, err
+	}
+	if err := patch.Check(); err != nil {
+		return storage.Client{}, err
+	}
+
+	var (
+		columns []string
+		args    []any
+	)
+	if email, ok := patch.Set[storage.FieldEmail]; ok {
+		columns = append(columns, reverifyColumn)
+		args = append(args, storage.StatusActive, email, storage.StatusPendingVerification)
+	}
+	for field, value := range patch.Set {
+		columns = append(columns, field+" = ?")
+		args = append(args, value)
+	}
+	columns = append(columns, "version = version + 1")
+	where, whereArgs := versionCondition(objectID, patch.Version)
+	args = append(args, whereArgs...)
+
+	user, err := scanClient(s.db.QueryRowContext(ctx,
+		fmt.Sprintf(`UPDATE %s SET %s WHERE %s RETURNING %s`, s.table, strings.Join(columns, ", "), where, clientColumns),
+		args...,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, s.versionMismatch(ctx, objectID)
+	}
+	if err != nil {
+		s.logger.Errorf("Failed to partially update user: %v", err)
+		return user, sqlError(err)
+	}
+
+	s.logger.Infof("User partially updated successfully, version: %d", user.Version)
+	return user, nil
+}
+
+func (s *SQLStorage) Delete(ctx context.Context, id string, opts storage.DeleteOptions) error {
+	s.logger.Infof("Deleting user with ID: %s", id)
+
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return err
+	}
+
+	where, args := versionCondition(objectID, opts.Version)
+	result, err := s.db.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE %s SET deleted_at = ?, deleted_by = ?, version = version + 1 WHERE %s`, s.table, where),
+		append([]any{time.Now().UTC(), opts.DeletedBy}, args...)...,
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to delete user: %v", err)
+		return err
+	}
+
+	deleted, _ := result.RowsAffected()
+	if deleted == 0 {
+		return s.versionMismatch(ctx, objectID)
+	}
+
+	s.logger.Infof("User moved to trash, deleted count: %d", deleted)
+	return nil
+}
+
+func (s *SQLStorage) Restore(ctx context.Context, id string) error {
+	s.logger.Infof("Restoring user with ID: %s", id)
+
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return err
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE %s SET deleted_at = NULL, deleted_by = '', version = version + 1 WHERE id = ? AND deleted_at IS NOT NULL`, s.table),
+		objectID.Hex(),
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to restore user: %v", err)
+		return sqlError(err)
+	}
+
+	if restored, _ := result.RowsAffected(); restored == 0 {
+		s.logger.Warnf("User with ID %s not found in trash", id)
+		return storage.ErrNotFound
+	}
+
+	s.logger.Infof("User %s restored successfully", id)
+	return nil
+}
+
+func (s *SQLStorage) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	s.logger.Infof("Purging users deleted before %s", deletedBefore.Format(time.RFC3339))
+
+	result, err := s.db.ExecContext(ctx,
+		fmt.Sprintf(`DELETE FROM %s WHERE deleted_at IS NOT NULL AND deleted_at < ?`, s.table),
+		deletedBefore.UTC(),
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to purge users: %v", err)
+		return 0, err
+	}
+
+	purged, _ := result.RowsAffected()
+	s.logger.Infof("Users purged successfully, deleted count: %d", purged)
+	return purged, nil
+}
+
+// versionMismatch explains why a conditional write matched nothing: either
+// the row is gone or somebody else changed it in the meantime.
+func (s *SQLStorage) versionMismatch(ctx context.Context, objectID primitive.ObjectID) error {
+	var count int
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE id = ? AND deleted_at IS NULL`, s.table), objectID.Hex()).Scan(&count)
+	if err != nil {
+		s.logger.Errorf("Failed to check user existence: %v", err)
+		return err
+	}
+	if count == 0 {
+		s.logger.Warnf("User with ID %s not found", objectID.Hex())
+		return storage.ErrNotFound
+	}
+	s.logger.Warnf("User with ID %s was modified concurrently", objectID.Hex())
+	return storage.ErrVersionConflict
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanClient(row rowScanner) (storage.Client, error) {
+	var (
+		user      storage.Client
+		roles     string
+		deletedAt sql.NullTime
+	)
+	err := row.Scan(&user.ID, &user.Email, &user.Username, &user.PasswordHash, &user.Version, &roles, &user.Status, &deletedAt, &user.DeletedBy)
+	user.Roles = decodeRoles(roles)
+	if deletedAt.Valid {
+		user.DeletedAt = &deletedAt.Time
+	}
+	return user, err
+}
+
+func versionCondition(objectID primitive.ObjectID, version int64) (string, []any) {
+	if version > 0 {
+		return "id = ? AND deleted_at IS NULL AND version = ?", []any{objectID.Hex(), version}
+	}
+	return "id = ? AND deleted_at IS NULL", []any{objectID.Hex()}
+}
+
+func sqlFilter(opts storage.ListOptions) ([]string, []any) {
+	var (
+		where = []string{"deleted_at IS NULL"}
+		args  []any
+	)
+	if opts.Deleted {
+		where[0] = "deleted_at IS NOT NULL"
+	}
+	for _, f := range opts.Filters {
+		switch f.Op {
+		case storage.FilterEqual:
+			where = append(where, f.Field+" = ?")
+			args = append(args, f.Value)
+		case storage.FilterPrefix:
+			// Unlike substr, LIKE would ignore the case of ASCII letters.
+			where = append(where, "substr("+f.Field+", 1, length(?)) = ?")
+			args = append(args, f.Value, f.Value)
+		}
+	}
+	return where, args
+}
+
+func sqlOrder(keys []storage.SortField) string {
+	order := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if k.Desc {
+			order = append(order, k.Field+" DESC")
+		} else {
+			order = append(order, k.Field+" ASC")
+		}
+	}
+	return strings.Join(order, ", ")
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// sqlKeyset builds a condition matching the rows that sort after cursor.
+func sqlKeyset(keys []storage.SortField, cursor storage.Cursor) (string, []any) {
+	var (
+		or   []string
+		args []any
+	)
+	for i, k := range keys {
+		var and []string
+		for j := 0; j < i; j++ {
+			and = append(and, keys[j].Field+" = ?")
+			args = append(args, cursor.Values[j])
+		}
+		operator := " > ?"
+		if k.Desc {
+			operator = " < ?"
+		}
+		and = append(and, k.Field+operator)
+		args = append(args, cursor.Values[i])
+		or = append(or, "("+strings.Join(and, " AND ")+")")
+	}
+	return "(" + strings.Join(or, " OR ") + ")", args
+}
+
+// sqlError maps driver errors onto the backend-agnostic storage errors.
+func sqlError(err error) error {
+	var sqliteErr *sqlite.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return storage.ErrNotFound
+	case errors.As(err, &sqliteErr) && (sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY):
+		var field string
+		// The message ends with "UNIQUE constraint failed: users.email (2067)".
+		msg := sqliteErr.Error()
+		if i := strings.LastIndex(msg, "failed: "); i >= 0 {
+			column, _, _ := strings.Cut(msg[i+len("failed: "):], " ")
+			field = strings.TrimSuffix(column[strings.LastIndex(column, ".")+1:], ",")
+		}
+		return &storage.ConflictError{Field: field}
+	default:
+		return err
+	}
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,126 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"rest-api/internal/storage"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+func openSQL(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newSQL(t *testing.T, db *sql.DB) *SQLStorage {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := NewSQLStorage(context.Background(), db, "users", logger)
+	if err != nil {
+		t.Fatalf("NewSQLStorage() error = %v", err)
+	}
+	return s
+}
+
+func TestSQLCase(t *testing.T) {
+	ctx := context.Background()
+	s := newSQL(t, openSQL(t))
+	for _, client := range []storage.Client{
+		{Email: "Bob@example.com", Username: "Bob"},
+		{Email: "amy@example.com", Username: "amy"},
+	} {
+		if _, err := s.Create(ctx, client); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	t.Run("unique without case", func(t *testing.T) {
+		_, err := s.Create(ctx, storage.Client{Email: "BOB@EXAMPLE.COM", Username: "robert"})
+		var conflict *storage.ConflictError
+		if !errors.As(err, &conflict) || conflict.Field != storage.FieldEmail {
+			t.Errorf("Create() error = %v, want a conflict on %s", err, storage.FieldEmail)
+		}
+	})
+
+	t.Run("login without case", func(t *testing.T) {
+		user, err := s.FindByLogin(ctx, "bob")
+		if err != nil || user.Username != "Bob" {
+			t.Errorf("FindByLogin() = %+v, %v", user, err)
+		}
+	})
+
+	filters := []struct {
+		name   string
+		filter storage.Filter
+		want   int64
+	}{
+		{"equal", storage.Filter{Field: storage.FieldUsername, Op: storage.FilterEqual, Value: "Bob"}, 1},
+		{"equal other case", storage.Filter{Field: storage.FieldUsername, Op: storage.FilterEqual, Value: "bob"}, 0},
+		{"prefix", storage.Filter{Field: storage.FieldUsername, Op: storage.FilterPrefix, Value: "B"}, 1},
+		{"prefix other case", storage.Filter{Field: storage.FieldUsername, Op: storage.FilterPrefix, Value: "b"}, 0},
+		{"prefix wildcard", storage.Filter{Field: storage.FieldUsername, Op: storage.FilterPrefix, Value: "%"}, 0},
+	}
+	for _, tt := range filters {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := s.GetAll(ctx, storage.ListOptions{Filters: []storage.Filter{tt.filter}})
+			if err != nil {
+				t.Fatalf("GetAll() error = %v", err)
+			}
+			if page.Total != tt.want {
+				t.Errorf("GetAll() total = %d, want %d", page.Total, tt.want)
+			}
+		})
+	}
+
+	t.Run("sorted with case", func(t *testing.T) {
+		page, err := s.GetAll(ctx, storage.ListOptions{Sort: []storage.SortField{{Field: storage.FieldUsername}}})
+		if err != nil {
+			t.Fatalf("GetAll() error = %v", err)
+		}
+		if len(page.Items) != 2 || page.Items[0].Username != "Bob" {
+			t.Errorf("GetAll() = %+v, want Bob first", page.Items)
+		}
+	})
+}
+
+func TestSQLLegacyTable(t *testing.T) {
+	ctx := context.Background()
+	db := openSQL(t)
+	_, err := db.Exec(`CREATE TABLE users (
+		id       TEXT PRIMARY KEY,
+		email    TEXT NOT NULL COLLATE NOCASE UNIQUE,
+		username TEXT NOT NULL COLLATE NOCASE UNIQUE,
+		password TEXT NOT NULL DEFAULT ''
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO users (id, email, username) VALUES ('0123456789abcdef01234567', 'bob@example.com', 'bob')`); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSQL(t, db)
+	user, err := s.FindOne(ctx, "0123456789abcdef01234567")
+	if err != nil || user.Username != "bob" || user.Version != 1 {
+		t.Fatalf("FindOne() = %+v, %v", user, err)
+	}
+	if _, err := s.Create(ctx, storage.Client{Email: "amy@example.com", Username: "BOB"}); !errors.Is(err, storage.ErrDuplicateKey) {
+		t.Errorf("Create() error = %v, want %v", err, storage.ErrDuplicateKey)
+	}
+	page, err := s.GetAll(ctx, storage.ListOptions{Filters: []storage.Filter{{Field: storage.FieldUsername, Op: storage.FilterEqual, Value: "BOB"}}})
+	if err != nil || page.Total != 0 {
+		t.Errorf("GetAll() = %+v, %v, want no match", page, err)
+	}
+}
@@ -0,0 +1,127 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"rest-api/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (s *MongoStorage) SetStatus(ctx context.Context, id, from, to string) error {
+	s.logger.Infof("Changing status of user %s from %s to %s", id, from, to)
+
+	if !storage.CanTransition(from, to) {
+		return storage.ErrInvalidTransition
+	}
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return err
+	}
+
+	result, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": objectID, "deletedAt": nil, "status": from},
+		bson.M{"$set": bson.M{"status": to}, "$inc": bson.M{"version": 1}},
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to change status: %v", err)
+		return mongoError(err)
+	}
+	if result.MatchedCount == 0 {
+		if err := s.exists(ctx, objectID); err != nil {
+			return err
+		}
+		s.logger.Warnf("User %s is not %s", id, from)
+		return storage.ErrInvalidTransition
+	}
+
+	s.logger.Infof("Status of user %s changed to %s", id, to)
+	return nil
+}
+
+func (s *MemoryStorage) SetStatus(ctx context.Context, id, from, to string) error {
+	s.logger.Infof("Changing status of user %s from %s to %s", id, from, to)
+
+	if !storage.CanTransition(from, to) {
+		return storage.ErrInvalidTransition
+	}
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.live(objectID.Hex())
+	if !ok {
+		s.logger.Warnf("User with ID %s not found", id)
+		return storage.ErrNotFound
+	}
+	if user.Status != from {
+		s.logger.Warnf("User %s is not %s", id, from)
+		return storage.ErrInvalidTransition
+	}
+	user.Status = to
+	user.Version++
+	s.users[user.ID] = user
+
+	s.logger.Infof("Status of user %s changed to %s", id, to)
+	return nil
+}
+
+func (s *SQLStorage) SetStatus(ctx context.Context, id, from, to string) error {
+	s.logger.Infof("Changing status of user %s from %s to %s", id, from, to)
+
+	if !storage.CanTransition(from, to) {
+		return storage.ErrInvalidTransition
+	}
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT status FROM %s WHERE id = ? AND deleted_at IS NULL`, s.table),
+		objectID.Hex(),
+	).Scan(&status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.Warnf("User with ID %s not found", id)
+		} else {
+			s.logger.Errorf("Failed to load status: %v", err)
+		}
+		return sqlError(err)
+	}
+	if status != from {
+		s.logger.Warnf("User %s is not %s", id, from)
+		return storage.ErrInvalidTransition
+	}
+
+	_, err = tx.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE %s SET status = ?, version = version + 1 WHERE id = ?`, s.table),
+		to, objectID.Hex(),
+	)
+	if err != nil {
+		s.logger.Errorf("Failed to change status: %v", err)
+		return sqlError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.logger.Infof("Status of user %s changed to %s", id, to)
+	return nil
+}
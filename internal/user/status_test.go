@@ -0,0 +1,101 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"rest-api/internal/storage"
+	"testing"
+)
+
+func TestMemorySetStatus(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		from, to string
+		want     error
+	}{
+		{"activate", storage.StatusPendingVerification, storage.StatusActive, nil},
+		{"not allowed", storage.StatusPendingVerification, storage.StatusSuspended, storage.ErrInvalidTransition},
+		{"user in another status", storage.StatusActive, storage.StatusSuspended, storage.ErrInvalidTransition},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMemory(t)
+			bob := mustCreate(t, s, storage.Client{Email: "bob@example.com", Username: "bob", Status: storage.StatusPendingVerification})
+
+			err := s.SetStatus(ctx, bob.ID, tt.from, tt.to)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("SetStatus() error = %v, want %v", err, tt.want)
+			}
+
+			got, _ := s.FindOne(ctx, bob.ID)
+			wantStatus, wantVersion := bob.Status, bob.Version
+			if tt.want == nil {
+				wantStatus, wantVersion = tt.to, bob.Version+1
+			}
+			if got.Status != wantStatus || got.Version != wantVersion {
+				t.Errorf("user is %s at version %d, want %s at version %d", got.Status, got.Version, wantStatus, wantVersion)
+			}
+		})
+	}
+
+	t.Run("missing user", func(t *testing.T) {
+		s := newMemory(t)
+		err := s.SetStatus(ctx, "0123456789abcdef01234567", storage.StatusActive, storage.StatusSuspended)
+		if !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("SetStatus() error = %v, want %v", err, storage.ErrNotFound)
+		}
+	})
+
+	t.Run("deleted user", func(t *testing.T) {
+		s := newMemory(t)
+		bob := mustCreate(t, s, storage.Client{Email: "bob@example.com", Username: "bob"})
+		if err := s.Delete(ctx, bob.ID, storage.DeleteOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		err := s.SetStatus(ctx, bob.ID, storage.StatusActive, storage.StatusSuspended)
+		if !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("SetStatus() error = %v, want %v", err, storage.ErrNotFound)
+		}
+	})
+}
+
+func TestMemoryEmailChange(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		status string
+		email  string
+		want   string
+	}{
+		{"active with a new email", storage.StatusActive, "bob@example.org", storage.StatusPendingVerification},
+		{"active with another case", storage.StatusActive, "Bob@Example.com", storage.StatusActive},
+		{"suspended with a new email", storage.StatusSuspended, "bob@example.org", storage.StatusSuspended},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMemory(t)
+			bob := mustCreate(t, s, storage.Client{Email: "bob@example.com", Username: "bob", Status: tt.status})
+
+			patched, err := s.PartiallyUpdate(ctx, storage.Patch{ID: bob.ID, Set: map[string]string{storage.FieldEmail: tt.email}})
+			if err != nil {
+				t.Fatalf("PartiallyUpdate() error = %v", err)
+			}
+			if patched.Status != tt.want {
+				t.Errorf("PartiallyUpdate() status = %s, want %s", patched.Status, tt.want)
+			}
+
+			updated, err := s.Update(ctx, storage.Client{ID: bob.ID, Email: tt.email, Username: "bob"})
+			if err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			if updated.Status != tt.want {
+				t.Errorf("Update() status = %s, want %s", updated.Status, tt.want)
+			}
+		})
+	}
+}
@@ -2,14 +2,27 @@ package user
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"regexp"
 	"rest-api/internal/storage"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// duplicateKeyField extracts the offending field from an E11000 error message
+// such as `index: email_unique dup key: { email: "a@b.c" }`.
+var duplicateKeyField = regexp.MustCompile(`dup key: \{ "?(\w+)"?:`)
+
+// streamBatchSize bounds how many documents Stream holds in memory at once.
+const streamBatchSize = 500
+
 type MongoStorage struct {
 	collection *mongo.Collection
 	logger     *logrus.Logger
@@ -23,42 +36,119 @@ func NewMongoStorage(client *mongo.Client, dbName, collectionName string, logger
 	}
 }
 
-func (s *MongoStorage) GetAll(ctx context.Context) ([]storage.Client, error) {
-	s.logger.Info("Fetching all users from the database")
+func (s *MongoStorage) GetAll(ctx context.Context, opts storage.ListOptions) (storage.Page, error) {
+	s.logger.Infof("Fetching users from the database: %+v", opts)
 
-	cursor, err := s.collection.Find(ctx, bson.M{})
+	var page storage.Page
+	if err := opts.Validate(); err != nil {
+		return page, err
+	}
+
+	filter := mongoFilter(opts)
+	total, err := s.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		s.logger.Errorf("Failed to count users: %v", err)
+		return page, err
+	}
+	page.Total = total
+
+	keys := opts.SortKeys()
+	limit := opts.PageSize()
+	findOptions := options.Find().SetSort(mongoSort(keys)).SetLimit(int64(limit) + 1)
+	if opts.Cursor != "" {
+		cursor, err := storage.DecodeCursor(opts.Cursor, keys)
+		if err != nil {
+			return page, err
+		}
+		after, err := mongoKeyset(keys, cursor)
+		if err != nil {
+			return page, err
+		}
+		filter = bson.M{"$and": bson.A{filter, after}}
+	} else if opts.Offset > 0 {
+		findOptions.SetSkip(int64(opts.Offset))
+	}
+
+	cursor, err := s.collection.Find(ctx, filter, findOptions)
 	if err != nil {
 		s.logger.Errorf("Failed to fetch users: %v", err)
-		return nil, err
+		return page, err
 	}
 	defer cursor.Close(ctx)
 
-	var users []storage.Client
+	users := make([]storage.Client, 0, limit)
 	for cursor.Next(ctx) {
 		var user storage.Client
 		if err := cursor.Decode(&user); err != nil {
 			s.logger.Errorf("Failed to decode user: %v", err)
-			return nil, err
+			return page, err
 		}
 		users = append(users, user)
 	}
 
 	if err := cursor.Err(); err != nil {
 		s.logger.Errorf("Cursor error while fetching users: %v", err)
-		return nil, err
+		return page, err
+	}
+
+	if len(users) > limit {
+		users = users[:limit]
+		page.NextCursor = storage.EncodeCursor(keys, users[limit-1])
+	}
+	page.Items = users
+
+	s.logger.Infof("Successfully fetched %d of %d users", len(users), total)
+	return page, nil
+}
+
+func (s *MongoStorage) Stream(ctx context.Context, opts storage.ListOptions, fn func(storage.Client) error) error {
+	s.logger.Infof("Streaming users from the database: %+v", opts)
+
+	if err := opts.Validate(); err != nil {
+		return err
 	}
 
-	s.logger.Infof("Successfully fetched %d users", len(users))
-	return users, nil
+	findOptions := options.Find().SetSort(mongoSort(opts.SortKeys())).SetBatchSize(streamBatchSize)
+	cursor, err := s.collection.Find(ctx, mongoFilter(opts), findOptions)
+	if err != nil {
+		s.logger.Errorf("Failed to stream users: %v", err)
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var count int
+	for cursor.Next(ctx) {
+		var user storage.Client
+		if err := cursor.Decode(&user); err != nil {
+			s.logger.Errorf("Failed to decode user: %v", err)
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+		count++
+	}
+
+	if err := cursor.Err(); err != nil {
+		s.logger.Errorf("Cursor error while streaming users: %v", err)
+		return err
+	}
+
+	s.logger.Infof("Successfully streamed %d users", count)
+	return nil
 }
 
 func (s *MongoStorage) Create(ctx context.Context, client storage.Client) (string, error) {
 	s.logger.Infof("Creating a new user: %+v", client)
 
+	client.Version = 1
+	if client.Status == "" {
+		client.Status = storage.StatusActive
+	}
 	res, err := s.collection.InsertOne(ctx, client)
 	if err != nil {
 		s.logger.Errorf("Failed to insert user: %v", err)
-		return "", err
+		return "", mongoError(err)
 	}
 
 	id, ok := res.InsertedID.(primitive.ObjectID)
@@ -75,102 +165,343 @@ func (s *MongoStorage) FindOne(ctx context.Context, id string) (storage.Client,
 	s.logger.Infof("Fetching user with ID: %s", id)
 
 	var user storage.Client
-	objectID, err := primitive.ObjectIDFromHex(id)
+	objectID, err := parseObjectID(id)
 	if err != nil {
 		s.logger.Errorf("Invalid ObjectID format: %v", err)
 		return user, err
 	}
 
-	err = s.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
+	err = s.collection.FindOne(ctx, bson.M{"_id": objectID, "deletedAt": nil}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			s.logger.Warnf("User with ID %s not found", id)
 		} else {
 			s.logger.Errorf("Failed to fetch user: %v", err)
 		}
-		return user, err
+		return user, mongoError(err)
 	}
 
 	s.logger.Infof("User found: %+v", user)
 	return user, nil
 }
 
-func (s *MongoStorage) Update(ctx context.Context, client storage.Client) error {
+func (s *MongoStorage) FindByLogin(ctx context.Context, login string) (storage.Client, error) {
+	field := storage.LoginField(login)
+	s.logger.Infof("Fetching user by %s", field)
+
+	var user storage.Client
+	err := s.collection.FindOne(ctx,
+		bson.M{field: login, "deletedAt": nil},
+		options.FindOne().SetCollation(&options.Collation{Locale: "en", Strength: 2}),
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			s.logger.Warnf("User with that %s not found", field)
+		} else {
+			s.logger.Errorf("Failed to fetch user: %v", err)
+		}
+		return user, mongoError(err)
+	}
+
+	s.logger.Infof("User found: %s", user.ID)
+	return user, nil
+}
+
+func (s *MongoStorage) Update(ctx context.Context, client storage.Client) (storage.Client, error) {
 	s.logger.Infof("Updating user with ID: %s", client.ID)
 
-	objectID, err := primitive.ObjectIDFromHex(client.ID)
+	objectID, err := parseObjectID(client.ID)
 	if err != nil {
 		s.logger.Errorf("Invalid ObjectID format: %v", err)
-		return err
+		return storage.Client{}, err
 	}
 
-	result, err := s.collection.UpdateOne(
+	var user storage.Client
+	err = s.collection.FindOneAndUpdate(
 		ctx,
-		bson.M{"_id": objectID},
-		bson.M{"$set": bson.M{
-			"email":    client.Email,
-			"username": client.Username,
-			"password": client.PasswordHash,
-		}},
-	)
+		versionFilter(objectID, client.Version),
+		updatePipeline(replaceFields(client)),
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return user, s.versionMismatch(ctx, objectID)
+	}
 	if err != nil {
 		s.logger.Errorf("Failed to update user: %v", err)
-		return err
+		return user, mongoError(err)
 	}
 
-	s.logger.Infof("User updated successfully, modified count: %d", result.ModifiedCount)
-	return nil
+	s.logger.Infof("User updated successfully, version: %d", user.Version)
+	return user, nil
 }
 
-func (s *MongoStorage) PartiallyUpdate(ctx context.Context, client storage.Client) error {
-	s.logger.Infof("Partially updating user with ID: %s", client.ID)
+func (s *MongoStorage) PartiallyUpdate(ctx context.Context, patch storage.Patch) (storage.Client, error) {
+	s.logger.Infof("Partially updating user with ID: %s", patch.ID)
 
-	objectID, err := primitive.ObjectIDFromHex(client.ID)
+	objectID, err := parseObjectID(patch.ID)
 	if err != nil {
 		s.logger.Errorf("Invalid ObjectID format: %v", err)
-		return err
+		return storage.Client{}, err
+	}
+	if err := patch.Check(); err != nil {
+		return storage.Client{}, err
 	}
 
-	updateFields := bson.M{}
-	if client.Email != "" {
-		updateFields["email"] = client.Email
+	fields := bson.M{}
+	for field, value := range patch.Set {
+		fields[field] = value
 	}
-	if client.Username != "" {
-		updateFields["username"] = client.Username
+
+	var user storage.Client
+	err = s.collection.FindOneAndUpdate(
+		ctx,
+		versionFilter(objectID, patch.Version),
+		updatePipeline(fields),
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return user, s.versionMismatch(ctx, objectID)
 	}
-	if client.PasswordHash != "" {
-		updateFields["password"] = client.PasswordHash
+	if err != nil {
+		s.logger.Errorf("Failed to partially update user: %v", err)
+		return user, mongoError(err)
+	}
+
+	s.logger.Infof("User partially updated successfully, version: %d", user.Version)
+	return user, nil
+}
+
+func (s *MongoStorage) Delete(ctx context.Context, id string, opts storage.DeleteOptions) error {
+	s.logger.Infof("Deleting user with ID: %s", id)
+
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		s.logger.Errorf("Invalid ObjectID format: %v", err)
+		return err
 	}
 
 	result, err := s.collection.UpdateOne(
 		ctx,
-		bson.M{"_id": objectID},
-		bson.M{"$set": updateFields},
+		versionFilter(objectID, opts.Version),
+		bson.M{
+			"$set": bson.M{
+				"deletedAt": time.Now().UTC(),
+				"deletedBy": opts.DeletedBy,
+			},
+			"$inc": bson.M{"version": 1},
+		},
 	)
 	if err != nil {
-		s.logger.Errorf("Failed to partially update user: %v", err)
-		return err
+		s.logger.Errorf("Failed to delete user: %v", err)
+		return mongoError(err)
+	}
+	if result.MatchedCount == 0 {
+		return s.versionMismatch(ctx, objectID)
 	}
 
-	s.logger.Infof("User partially updated successfully, modified count: %d", result.ModifiedCount)
+	s.logger.Infof("User moved to trash, deleted count: %d", result.MatchedCount)
 	return nil
 }
 
-func (s *MongoStorage) Delete(ctx context.Context, id string) error {
-	s.logger.Infof("Deleting user with ID: %s", id)
+func (s *MongoStorage) Restore(ctx context.Context, id string) error {
+	s.logger.Infof("Restoring user with ID: %s", id)
 
-	objectID, err := primitive.ObjectIDFromHex(id)
+	objectID, err := parseObjectID(id)
 	if err != nil {
 		s.logger.Errorf("Invalid ObjectID format: %v", err)
 		return err
 	}
 
-	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	result, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID, "deletedAt": bson.M{"$ne": nil}},
+		bson.M{
+			"$set":   bson.M{"deletedAt": nil},
+			"$unset": bson.M{"deletedBy": ""},
+			"$inc":   bson.M{"version": 1},
+		},
+	)
 	if err != nil {
-		s.logger.Errorf("Failed to delete user: %v", err)
-		return err
+		s.logger.Errorf("Failed to restore user: %v", err)
+		return mongoError(err)
+	}
+	if result.MatchedCount == 0 {
+		s.logger.Warnf("User with ID %s not found in trash", id)
+		return storage.ErrNotFound
 	}
 
-	s.logger.Infof("User deleted successfully, deleted count: %d", result.DeletedCount)
+	s.logger.Infof("User %s restored successfully", id)
 	return nil
 }
+
+func (s *MongoStorage) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	s.logger.Infof("Purging users deleted before %s", deletedBefore.Format(time.RFC3339))
+
+	result, err := s.collection.DeleteMany(ctx, bson.M{"deletedAt": bson.M{"$lt": deletedBefore}})
+	if err != nil {
+		s.logger.Errorf("Failed to purge users: %v", err)
+		return 0, err
+	}
+
+	s.logger.Infof("Users purged successfully, deleted count: %d", result.DeletedCount)
+	return result.DeletedCount, nil
+}
+
+// replaceFields is the $set of a full update. The password is only replaced
+// when a new hash is given, an update never clears the credentials.
+func replaceFields(client storage.Client) bson.M {
+	fields := bson.M{
+		"email":    client.Email,
+		"username": client.Username,
+	}
+	if client.PasswordHash != "" {
+		fields["password"] = client.PasswordHash
+	}
+	return fields
+}
+
+// updatePipeline sets fields and bumps the version. It is a pipeline so that
+// an active user whose email changes can be moved back to pending
+// verification in the same write, see storage.StatusAfterEmailChange. The
+// values are literals, a hash starting with "$" is no field path.
+func updatePipeline(fields bson.M) bson.A {
+	set := bson.M{"version": bson.M{"$add": bson.A{"$version", 1}}}
+	for field, value := range fields {
+		set[field] = bson.M{"$literal": value}
+	}
+	if email, ok := fields["email"].(string); ok {
+		// Expressions of one stage all see the document before it.
+		set["status"] = bson.M{"$cond": bson.A{
+			bson.M{"$and": bson.A{
+				bson.M{"$eq": bson.A{"$status", storage.StatusActive}},
+				bson.M{"$ne": bson.A{bson.M{"$toLower": "$email"}, strings.ToLower(email)}},
+			}},
+			storage.StatusPendingVerification,
+			"$status",
+		}}
+	}
+	return bson.A{bson.M{"$set": set}}
+}
+
+// versionMismatch explains why a conditional write matched nothing: either
+// the document is gone or somebody else changed it in the meantime.
+func (s *MongoStorage) versionMismatch(ctx context.Context, objectID primitive.ObjectID) error {
+	count, err := s.collection.CountDocuments(ctx, bson.M{"_id": objectID, "deletedAt": nil}, options.Count().SetLimit(1))
+	if err != nil {
+		s.logger.Errorf("Failed to check user existence: %v", err)
+		return err
+	}
+	if count == 0 {
+		s.logger.Warnf("User with ID %s not found", objectID.Hex())
+		return storage.ErrNotFound
+	}
+	s.logger.Warnf("User with ID %s was modified concurrently", objectID.Hex())
+	return storage.ErrVersionConflict
+}
+
+func versionFilter(objectID primitive.ObjectID, version int64) bson.M {
+	filter := bson.M{"_id": objectID, "deletedAt": nil}
+	if version > 0 {
+		filter["version"] = version
+	}
+	return filter
+}
+
+func mongoFilter(opts storage.ListOptions) bson.M {
+	filter := bson.M{"deletedAt": nil}
+	if opts.Deleted {
+		filter["deletedAt"] = bson.M{"$ne": nil}
+	}
+	// Filters on the same field must all match, so each is a condition of
+	// its own instead of a key of filter.
+	var conditions bson.A
+	for _, f := range opts.Filters {
+		switch f.Op {
+		case storage.FilterEqual:
+			conditions = append(conditions, bson.M{mongoField(f.Field): f.Value})
+		case storage.FilterPrefix:
+			conditions = append(conditions, bson.M{mongoField(f.Field): bson.M{"$regex": "^" + regexp.QuoteMeta(f.Value)}})
+		}
+	}
+	if len(conditions) > 0 {
+		filter["$and"] = conditions
+	}
+	return filter
+}
+
+func mongoSort(keys []storage.SortField) bson.D {
+	sort := bson.D{}
+	for _, k := range keys {
+		direction := 1
+		if k.Desc {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: mongoField(k.Field), Value: direction})
+	}
+	return sort
+}
+
+func mongoField(field string) string {
+	if field == "id" {
+		return "_id"
+	}
+	return field
+}
+
+// mongoKeyset builds a filter matching the documents that sort after cursor.
+func mongoKeyset(keys []storage.SortField, cursor storage.Cursor) (bson.M, error) {
+	values := make([]any, len(keys))
+	for i, k := range keys {
+		values[i] = cursor.Values[i]
+		if k.Field == "id" {
+			objectID, err := parseObjectID(cursor.Values[i])
+			if err != nil {
+				return nil, fmt.Errorf("%w: malformed cursor", storage.ErrInvalidListOptions)
+			}
+			values[i] = objectID
+		}
+	}
+
+	or := bson.A{}
+	for i, k := range keys {
+		clause := bson.M{}
+		for j := 0; j < i; j++ {
+			clause[mongoField(keys[j].Field)] = values[j]
+		}
+		operator := "$gt"
+		if k.Desc {
+			operator = "$lt"
+		}
+		clause[mongoField(k.Field)] = bson.M{operator: values[i]}
+		or = append(or, clause)
+	}
+	return bson.M{"$or": or}, nil
+}
+
+func parseObjectID(id string) (primitive.ObjectID, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return objectID, fmt.Errorf("%w: %v", storage.ErrInvalidID, err)
+	}
+	return objectID, nil
+}
+
+// mongoError maps driver errors onto the backend-agnostic storage errors.
+func mongoError(err error) error {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return storage.ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return duplicateKeyError(err.Error())
+	default:
+		return err
+	}
+}
+
+func duplicateKeyError(message string) *storage.ConflictError {
+	var field string
+	if m := duplicateKeyField.FindStringSubmatch(message); m != nil {
+		field = m[1]
+	}
+	return &storage.ConflictError{Field: field}
+}
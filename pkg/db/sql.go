@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+func NewSQLClient(driver, dsn string, logger *logrus.Logger) (*sql.DB, error) {
+	logger.Infof("Initializing connection to %s database at DSN: %s", driver, dsn)
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		logger.Errorf("Failed to open %s database: %v", driver, err)
+		return nil, fmt.Errorf("failed to open %s database: %w", driver, err)
+	}
+
+	if driver == "sqlite" {
+		// SQLite allows a single writer, serialise access instead of failing with SQLITE_BUSY.
+		db.SetMaxOpenConns(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		logger.Errorf("%s connection check failed: %v", driver, err)
+		return nil, fmt.Errorf("failed to ping %s database: %w", driver, err)
+	}
+
+	logger.Infof("%s connection verified successfully", driver)
+	return db, nil
+}
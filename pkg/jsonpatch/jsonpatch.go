@@ -0,0 +1,373 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents to values decoded by encoding/json into any. Numbers
+// are expected as json.Number, see Unmarshal.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidOperation is returned for a malformed operation: an unknown
+	// op, a bad pointer or a missing value.
+	ErrInvalidOperation = errors.New("invalid patch operation")
+	// ErrPathNotFound is returned when a path does not point into the document.
+	ErrPathNotFound = errors.New("path not found")
+	ErrTestFailed   = errors.New("test operation failed")
+)
+
+// Operation is one operation of a JSON Patch.
+type Operation struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	From string `json:"from,omitempty"`
+	// Value is nil when the operation has no value member.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Unmarshal decodes the single JSON value in data into v the way documents
+// and patches are expected, with numbers as json.Number.
+func Unmarshal(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.Decode(&struct{}{}) != io.EOF {
+		return errors.New("unexpected data after the JSON value")
+	}
+	return nil
+}
+
+// Merge applies the merge patch to doc. Members of patch that are null
+// remove the member of doc, objects are merged recursively and every other
+// value replaces the one in doc. doc is not modified.
+func Merge(doc, patch any) any {
+	members, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	current, _ := doc.(map[string]any)
+	merged := make(map[string]any, len(current))
+	for name, value := range current {
+		merged[name] = value
+	}
+	for name, value := range members {
+		if value == nil {
+			delete(merged, name)
+			continue
+		}
+		merged[name] = Merge(merged[name], value)
+	}
+	return merged
+}
+
+// Apply applies ops to doc in order and returns the result. It fails on
+// the first operation that can not be applied, doc is not modified.
+func Apply(doc any, ops []Operation) (any, error) {
+	doc = clone(doc)
+	for i, op := range ops {
+		var err error
+		doc, err = apply(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return doc, nil
+}
+
+func apply(doc any, op Operation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	case "remove":
+		if len(path) == 0 {
+			return nil, fmt.Errorf("%w: the document itself can not be removed", ErrInvalidOperation)
+		}
+		doc, _, err := remove(doc, path)
+		return doc, err
+	case "replace":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		if doc, _, err = remove(doc, path); err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		var value any
+		if op.Op == "move" {
+			if op.Path == op.From {
+				return doc, nil
+			}
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, fmt.Errorf("%w: %s can not be moved into itself", ErrInvalidOperation, op.From)
+			}
+			if len(from) == 0 {
+				return nil, fmt.Errorf("%w: the document itself can not be moved", ErrInvalidOperation)
+			}
+			doc, value, err = remove(doc, from)
+		} else {
+			value, err = get(doc, from)
+			value = clone(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	case "test":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !Equal(current, value) {
+			return nil, fmt.Errorf("%w: %s", ErrTestFailed, op.Path)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidOperation, op.Op)
+	}
+}
+
+func (op Operation) value() (any, error) {
+	if op.Value == nil {
+		return nil, fmt.Errorf("%w: %s needs a value", ErrInvalidOperation, op.Op)
+	}
+	var value any
+	if err := Unmarshal(op.Value, &value); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOperation, err)
+	}
+	return value, nil
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: pointer %q does not start with /", ErrInvalidOperation, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// index parses an index into an array of length elements. With end set it
+// may also be length, or "-" for it, the position after the last element.
+func index(token string, length int, end bool) (int, error) {
+	if token == "-" && end {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidOperation, token)
+	}
+	if i > length || (i == length && !end) {
+		return 0, fmt.Errorf("%w: index %d is out of range", ErrPathNotFound, i)
+	}
+	return i, nil
+}
+
+func get(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: member %q", ErrPathNotFound, token)
+			}
+			doc = value
+		case []any:
+			i, err := index(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("%w: %q is not in an object or array", ErrPathNotFound, token)
+		}
+	}
+	return doc, nil
+}
+
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token, rest := path[0], path[1:]
+
+	switch node := doc.(type) {
+	case map[string]any:
+		if len(rest) == 0 {
+			node[token] = value
+			return node, nil
+		}
+		child, ok := node[token]
+		if !ok {
+			return nil, fmt.Errorf("%w: member %q", ErrPathNotFound, token)
+		}
+		child, err := add(child, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = child
+		return node, nil
+	case []any:
+		if len(rest) == 0 {
+			i, err := index(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]any, 0, len(node)+1)
+			out = append(out, node[:i]...)
+			out = append(out, value)
+			return append(out, node[i:]...), nil
+		}
+		i, err := index(token, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		child, err := add(node[i], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	default:
+		return nil, fmt.Errorf("%w: %q is not in an object or array", ErrPathNotFound, token)
+	}
+}
+
+// remove returns doc without the value at path, and that value.
+func remove(doc any, path []string) (any, any, error) {
+	token, rest := path[0], path[1:]
+
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: member %q", ErrPathNotFound, token)
+		}
+		if len(rest) == 0 {
+			delete(node, token)
+			return node, child, nil
+		}
+		child, removed, err := remove(child, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		node[token] = child
+		return node, removed, nil
+	case []any:
+		i, err := index(token, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			out := make([]any, 0, len(node)-1)
+			out = append(out, node[:i]...)
+			return append(out, node[i+1:]...), node[i], nil
+		}
+		child, removed, err := remove(node[i], rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		node[i] = child
+		return node, removed, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %q is not in an object or array", ErrPathNotFound, token)
+	}
+}
+
+func clone(value any) any {
+	switch node := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(node))
+		for name, child := range node {
+			out[name] = clone(child)
+		}
+		return out
+	case []any:
+		out := make([]any, len(node))
+		for i, child := range node {
+			out[i] = clone(child)
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+// Equal reports whether two decoded JSON values are equal, numbers are
+// compared by their value.
+func Equal(a, b any) bool {
+	switch x := a.(type) {
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for name, value := range x {
+			other, ok := y[name]
+			if !ok || !Equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !Equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if x == y {
+			return true
+		}
+		fx, errX := x.Float64()
+		fy, errY := y.Float64()
+		return errX == nil && errY == nil && fx == fy
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,329 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func decode(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("Unmarshal(%s): %v", s, err)
+	}
+	return v
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+		err   error
+	}{
+		// Pointer escaping
+		{
+			name:  "tilde and slash escapes",
+			doc:   `{"a/b":1,"m~n":2}`,
+			patch: `[{"op":"replace","path":"/a~1b","value":3},{"op":"remove","path":"/m~0n"}]`,
+			want:  `{"a/b":3}`,
+		},
+		{
+			name:  "~01 is a literal ~1",
+			doc:   `{"~1":1,"/":2}`,
+			patch: `[{"op":"remove","path":"/~01"}]`,
+			want:  `{"/":2}`,
+		},
+		{
+			name:  "empty member name",
+			doc:   `{"":1}`,
+			patch: `[{"op":"replace","path":"/","value":2}]`,
+			want:  `{"":2}`,
+		},
+		{
+			name:  "pointer without leading slash",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"remove","path":"a"}]`,
+			err:   ErrInvalidOperation,
+		},
+
+		// Array index rules
+		{
+			name:  "add at index",
+			doc:   `{"a":[1,3]}`,
+			patch: `[{"op":"add","path":"/a/1","value":2}]`,
+			want:  `{"a":[1,2,3]}`,
+		},
+		{
+			name:  "add at length appends",
+			doc:   `{"a":[1]}`,
+			patch: `[{"op":"add","path":"/a/1","value":2}]`,
+			want:  `{"a":[1,2]}`,
+		},
+		{
+			name:  "add with dash appends",
+			doc:   `{"a":[1]}`,
+			patch: `[{"op":"add","path":"/a/-","value":2}]`,
+			want:  `{"a":[1,2]}`,
+		},
+		{
+			name:  "add past length",
+			doc:   `{"a":[1]}`,
+			patch: `[{"op":"add","path":"/a/2","value":2}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "remove at length",
+			doc:   `{"a":[1]}`,
+			patch: `[{"op":"remove","path":"/a/1"}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "dash only for add",
+			doc:   `{"a":[1]}`,
+			patch: `[{"op":"replace","path":"/a/-","value":2}]`,
+			err:   ErrInvalidOperation,
+		},
+		{
+			name:  "leading zero",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"remove","path":"/a/01"}]`,
+			err:   ErrInvalidOperation,
+		},
+		{
+			name:  "negative index",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"remove","path":"/a/-1"}]`,
+			err:   ErrInvalidOperation,
+		},
+		{
+			name:  "index zero",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"remove","path":"/a/0"}]`,
+			want:  `{"a":[2]}`,
+		},
+
+		// move
+		{
+			name:  "move into itself",
+			doc:   `{"a":{"b":1}}`,
+			patch: `[{"op":"move","from":"/a","path":"/a/c"}]`,
+			err:   ErrInvalidOperation,
+		},
+		{
+			name:  "move to a sibling with a common prefix",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"move","from":"/a","path":"/ab"}]`,
+			want:  `{"ab":1}`,
+		},
+		{
+			name:  "move to itself",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"move","from":"/a","path":"/a"}]`,
+			want:  `{"a":1}`,
+		},
+		{
+			name:  "move within an array",
+			doc:   `[1,2,3]`,
+			patch: `[{"op":"move","from":"/0","path":"/-"}]`,
+			want:  `[2,3,1]`,
+		},
+		{
+			name:  "copy does not alias",
+			doc:   `{"a":{"b":1}}`,
+			patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`,
+			want:  `{"a":{"b":1},"c":{"b":2}}`,
+		},
+
+		// test
+		{
+			name:  "test numbers by value",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"test","path":"/a","value":1.0},{"op":"test","path":"/a","value":1e0}]`,
+			want:  `{"a":1}`,
+		},
+		{
+			name:  "test number against string",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"test","path":"/a","value":"1"}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "test different number",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"test","path":"/a","value":2}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "test nested values",
+			doc:   `{"a":{"b":[1,{"c":null}]}}`,
+			patch: `[{"op":"test","path":"/a","value":{"b":[1.0,{"c":null}]}}]`,
+			want:  `{"a":{"b":[1,{"c":null}]}}`,
+		},
+		{
+			name:  "test missing member",
+			doc:   `{}`,
+			patch: `[{"op":"test","path":"/a","value":null}]`,
+			err:   ErrPathNotFound,
+		},
+
+		// Operations
+		{
+			name:  "add without value",
+			doc:   `{}`,
+			patch: `[{"op":"add","path":"/a"}]`,
+			err:   ErrInvalidOperation,
+		},
+		{
+			name:  "add null value",
+			doc:   `{}`,
+			patch: `[{"op":"add","path":"/a","value":null}]`,
+			want:  `{"a":null}`,
+		},
+		{
+			name:  "unknown op",
+			doc:   `{}`,
+			patch: `[{"op":"frobnicate","path":"/a"}]`,
+			err:   ErrInvalidOperation,
+		},
+		{
+			name:  "remove the document",
+			doc:   `{}`,
+			patch: `[{"op":"remove","path":""}]`,
+			err:   ErrInvalidOperation,
+		},
+		{
+			name:  "replace the document",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"replace","path":"","value":[1]}]`,
+			want:  `[1]`,
+		},
+		{
+			name:  "replace missing member",
+			doc:   `{}`,
+			patch: `[{"op":"replace","path":"/a","value":1}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "add to missing parent",
+			doc:   `{}`,
+			patch: `[{"op":"add","path":"/a/b","value":1}]`,
+			err:   ErrPathNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := decode(t, tt.doc)
+			var ops []Operation
+			if err := Unmarshal([]byte(tt.patch), &ops); err != nil {
+				t.Fatalf("Unmarshal(%s): %v", tt.patch, err)
+			}
+
+			got, err := Apply(doc, ops)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Apply() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if want := decode(t, tt.want); !Equal(got, want) {
+				t.Errorf("Apply() = %v, want %v", got, want)
+			}
+			if !Equal(doc, decode(t, tt.doc)) {
+				t.Errorf("Apply() modified the document to %v", doc)
+			}
+		})
+	}
+}
+
+func TestApplyStopsAtFirstFailure(t *testing.T) {
+	doc := decode(t, `{"a":1}`)
+	ops := []Operation{
+		{Op: "replace", Path: "/a", Value: json.RawMessage(`2`)},
+		{Op: "test", Path: "/a", Value: json.RawMessage(`1`)},
+	}
+	if _, err := Apply(doc, ops); !errors.Is(err, ErrTestFailed) {
+		t.Fatalf("Apply() error = %v, want %v", err, ErrTestFailed)
+	}
+	if !Equal(doc, decode(t, `{"a":1}`)) {
+		t.Errorf("Apply() modified the document to %v", doc)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"replace member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"null removes member", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"null for missing member", `{"a":"b"}`, `{"c":null}`, `{"a":"b"}`},
+		{"nested null", `{"a":{"b":1,"c":2}}`, `{"a":{"b":null}}`, `{"a":{"c":2}}`},
+		{"null never added", `{}`, `{"a":{"b":null}}`, `{"a":{}}`},
+		{"array replaced", `{"a":[1,2]}`, `{"a":[3]}`, `{"a":[3]}`},
+		{"object over scalar", `{"a":1}`, `{"a":{"b":1}}`, `{"a":{"b":1}}`},
+		{"non-object patch replaces", `{"a":1}`, `["x"]`, `["x"]`},
+		{"null patch replaces", `{"a":1}`, `null`, `null`},
+		{"empty patch", `{"a":1}`, `{}`, `{"a":1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := decode(t, tt.doc)
+			got := Merge(doc, decode(t, tt.patch))
+			if want := decode(t, tt.want); !Equal(got, want) {
+				t.Errorf("Merge() = %v, want %v", got, want)
+			}
+			if !Equal(doc, decode(t, tt.doc)) {
+				t.Errorf("Merge() modified the document to %v", doc)
+			}
+		})
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{`1`, `1.0`, true},
+		{`1`, `1e0`, true},
+		{`10`, `1e1`, true},
+		{`1`, `2`, false},
+		{`1`, `"1"`, false},
+		{`null`, `null`, true},
+		{`null`, `false`, false},
+		{`[1,2]`, `[2,1]`, false},
+		{`{"a":1,"b":2}`, `{"b":2,"a":1.0}`, true},
+		{`{"a":1}`, `{"a":1,"b":2}`, false},
+		{`{"a":null}`, `{}`, false},
+	}
+
+	for _, tt := range tests {
+		if got := Equal(decode(t, tt.a), decode(t, tt.b)); got != tt.want {
+			t.Errorf("Equal(%s, %s) = %t, want %t", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	var v any
+	if err := Unmarshal([]byte(`{"a":1} {"b":2}`), &v); err == nil {
+		t.Error("Unmarshal() accepted data after the value")
+	}
+	if err := Unmarshal([]byte(`{"a":12345678901234567890}`), &v); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if n, ok := v.(map[string]any)["a"].(json.Number); !ok || n != "12345678901234567890" {
+		t.Errorf("Unmarshal() number = %#v, want json.Number", v.(map[string]any)["a"])
+	}
+}
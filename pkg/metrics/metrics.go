@@ -26,11 +26,51 @@ var (
 		},
 		[]string{"method", "handler"},
 	)
+
+	loginFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_login_failures_total",
+			Help: "Total number of failed login attempts",
+		},
+		[]string{"scope"},
+	)
+
+	lockoutsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_lockouts_total",
+			Help: "Total number of lockouts after repeated failed logins",
+		},
+		[]string{"scope"},
+	)
+
+	unlocksTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_unlocks_total",
+			Help: "Total number of lockouts lifted by an admin",
+		},
+		[]string{"scope"},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(httpRequestsTotal)
 	prometheus.MustRegister(httpRequestDuration)
+	prometheus.MustRegister(loginFailuresTotal)
+	prometheus.MustRegister(lockoutsTotal)
+	prometheus.MustRegister(unlocksTotal)
+}
+
+// RecordLoginFailure counts a failed login of an account or client IP scope.
+func RecordLoginFailure(scope string) {
+	loginFailuresTotal.WithLabelValues(scope).Inc()
+}
+
+func RecordLockout(scope string) {
+	lockoutsTotal.WithLabelValues(scope).Inc()
+}
+
+func RecordUnlock(scope string) {
+	unlocksTotal.WithLabelValues(scope).Inc()
 }
 
 func PrometheusMiddleware(handler httprouter.Handle, route string) httprouter.Handle {
@@ -55,3 +95,8 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,231 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	migrationsCollection = "schema_migrations"
+	lockCollection       = "schema_migrations_lock"
+	lockID               = "lock"
+)
+
+var (
+	ErrLocked       = errors.New("migrations are locked by another process")
+	ErrIrreversible = errors.New("migration can not be reverted")
+)
+
+// Migration is a single schema change. Up and Down must be idempotent, a
+// crash between running them and recording the result re-runs them.
+type Migration struct {
+	Version     int64
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error
+}
+
+type Status struct {
+	Migration Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type record struct {
+	Version     int64     `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedAt"`
+}
+
+type Migrator struct {
+	db         *mongo.Database
+	migrations []Migration
+	owner      string
+	lockTTL    time.Duration
+	logger     *logrus.Logger
+}
+
+func NewMigrator(db *mongo.Database, migrations []Migration, lockTTL time.Duration, logger *logrus.Logger) (*Migrator, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version <= 0 || m.Up == nil {
+			return nil, fmt.Errorf("migration %d: version must be positive and Up must be set", m.Version)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("migration %d is defined twice", m.Version)
+		}
+	}
+
+	host, _ := os.Hostname()
+	return &Migrator{
+		db:         db,
+		migrations: sorted,
+		owner:      fmt.Sprintf("%s/%d/%d", host, os.Getpid(), time.Now().UnixNano()),
+		lockTTL:    lockTTL,
+		logger:     logger,
+	}, nil
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		rec, ok := applied[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: rec.AppliedAt})
+	}
+	return statuses, nil
+}
+
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, s := range statuses {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration up to and including target, or all of
+// them when target is 0. It returns the number of migrations applied.
+func (m *Migrator) Up(ctx context.Context, target int64) (int, error) {
+	var count int
+	err := m.withLock(ctx, func() error {
+		pending, err := m.Pending(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range pending {
+			if target > 0 && migration.Version > target {
+				break
+			}
+			if err := m.refreshLock(ctx); err != nil {
+				return err
+			}
+
+			m.logger.Infof("Applying migration %d: %s", migration.Version, migration.Description)
+			if err := migration.Up(ctx, m.db); err != nil {
+				return fmt.Errorf("migration %d up: %w", migration.Version, err)
+			}
+			_, err := m.db.Collection(migrationsCollection).ReplaceOne(ctx,
+				bson.M{"_id": migration.Version},
+				record{Version: migration.Version, Description: migration.Description, AppliedAt: time.Now().UTC()},
+				options.Replace().SetUpsert(true),
+			)
+			if err != nil {
+				return fmt.Errorf("record migration %d: %w", migration.Version, err)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down reverts the last steps applied migrations, newest first.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	var count int
+	err := m.withLock(ctx, func() error {
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for i := len(statuses) - 1; i >= 0 && count < steps; i-- {
+			migration := statuses[i].Migration
+			if !statuses[i].Applied {
+				continue
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("migration %d: %w", migration.Version, ErrIrreversible)
+			}
+			if err := m.refreshLock(ctx); err != nil {
+				return err
+			}
+
+			m.logger.Infof("Reverting migration %d: %s", migration.Version, migration.Description)
+			if err := migration.Down(ctx, m.db); err != nil {
+				return fmt.Errorf("migration %d down: %w", migration.Version, err)
+			}
+			if _, err := m.db.Collection(migrationsCollection).DeleteOne(ctx, bson.M{"_id": migration.Version}); err != nil {
+				return fmt.Errorf("unrecord migration %d: %w", migration.Version, err)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]record, error) {
+	cursor, err := m.db.Collection(migrationsCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	applied := make(map[int64]record)
+	for cursor.Next(ctx) {
+		var rec record
+		if err := cursor.Decode(&rec); err != nil {
+			return nil, err
+		}
+		applied[rec.Version] = rec
+	}
+	return applied, cursor.Err()
+}
+
+// withLock runs fn while holding the migration lock. The lock is a lease:
+// a replica that dies while migrating blocks the others for lockTTL at most.
+func (m *Migrator) withLock(ctx context.Context, fn func() error) error {
+	if err := m.refreshLock(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		// Release even when ctx is already cancelled.
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := m.db.Collection(lockCollection).DeleteOne(releaseCtx, bson.M{"_id": lockID, "owner": m.owner}); err != nil {
+			m.logger.Errorf("Failed to release migration lock: %v", err)
+		}
+	}()
+	return fn()
+}
+
+// refreshLock acquires the lock, or extends it when this migrator holds it.
+func (m *Migrator) refreshLock(ctx context.Context) error {
+	now := time.Now().UTC()
+	_, err := m.db.Collection(lockCollection).UpdateOne(ctx,
+		bson.M{"_id": lockID, "$or": bson.A{
+			bson.M{"owner": m.owner},
+			bson.M{"expiresAt": bson.M{"$lt": now}},
+		}},
+		bson.M{"$set": bson.M{"owner": m.owner, "expiresAt": now.Add(m.lockTTL)}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		// The upsert collided with a live lock held by somebody else.
+		return ErrLocked
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// WriterNotifier writes messages to a file or stdout instead of sending
+// them, so that development setups work without a mail server.
+type WriterNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriter(w io.Writer) *WriterNotifier {
+	return &WriterNotifier{w: w}
+}
+
+// NewFile appends messages to the file at path, or writes them to stdout
+// when path is empty.
+func NewFile(path string) (*WriterNotifier, error) {
+	if path == "" {
+		return NewWriter(os.Stdout), nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(f), nil
+}
+
+func (n *WriterNotifier) Notify(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, err := fmt.Fprintf(n.w, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC1123Z), msg.To, msg.Subject, msg.Body)
+	return err
+}
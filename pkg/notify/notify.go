@@ -0,0 +1,29 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to users.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+var ErrInvalidHeader = errors.New("notify: line break in recipient or subject")
+
+// validate keeps user supplied values from injecting headers.
+func (m Message) validate() error {
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return ErrInvalidHeader
+	}
+	return nil
+}
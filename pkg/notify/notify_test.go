@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWriterNotifier(t *testing.T) {
+	var b bytes.Buffer
+	n := NewWriter(&b)
+
+	err := n.Notify(context.Background(), Message{To: "bob@example.com", Subject: "Reset", Body: "token"})
+	if err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	for _, want := range []string{"To: bob@example.com\n", "Subject: Reset\n", "\n\ntoken\n"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Notify() wrote %q, want it to contain %q", b.String(), want)
+		}
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	smtpNotifier, err := NewSMTP(SMTPOptions{Host: "localhost", From: "noreply@example.com"})
+	if err != nil {
+		t.Fatalf("NewSMTP() error = %v", err)
+	}
+
+	for _, msg := range []Message{
+		{To: "bob@example.com\r\nBcc: eve@example.com", Subject: "Reset"},
+		{To: "bob@example.com", Subject: "Reset\nBcc: eve@example.com"},
+	} {
+		var b bytes.Buffer
+		if err := NewWriter(&b).Notify(context.Background(), msg); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("WriterNotifier.Notify(%q) error = %v, want %v", msg, err, ErrInvalidHeader)
+		}
+		if b.Len() != 0 {
+			t.Errorf("WriterNotifier.Notify(%q) wrote %q", msg, b.String())
+		}
+		// Refused before dialing, nothing listens on the server.
+		if err := smtpNotifier.Notify(context.Background(), msg); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("SMTPNotifier.Notify(%q) error = %v, want %v", msg, err, ErrInvalidHeader)
+		}
+	}
+}
+
+func TestSMTPFormat(t *testing.T) {
+	n, err := NewSMTP(SMTPOptions{Host: "localhost", From: "noreply@example.com"})
+	if err != nil {
+		t.Fatalf("NewSMTP() error = %v", err)
+	}
+	if n.opts.Port != 587 {
+		t.Errorf("NewSMTP() port = %d, want 587", n.opts.Port)
+	}
+
+	got := string(n.format(Message{To: "bob@example.com", Subject: "Reset", Body: "line 1\nline 2"}))
+	for _, want := range []string{"From: noreply@example.com\r\n", "To: bob@example.com\r\n", "\r\n\r\nline 1\r\nline 2\r\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("format() = %q, want it to contain %q", got, want)
+		}
+	}
+
+	if _, err := NewSMTP(SMTPOptions{Host: "localhost"}); err == nil {
+		t.Errorf("NewSMTP() without a from address error = nil")
+	}
+}
@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type SMTPOptions struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPNotifier sends messages through an SMTP server. STARTTLS is used
+// whenever the server offers it and is required before authenticating.
+type SMTPNotifier struct {
+	opts SMTPOptions
+}
+
+func NewSMTP(opts SMTPOptions) (*SMTPNotifier, error) {
+	if opts.Host == "" || opts.From == "" {
+		return nil, fmt.Errorf("smtp host and from address are required")
+	}
+	if opts.Port == 0 {
+		opts.Port = 587
+	}
+	return &SMTPNotifier{opts: opts}, nil
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(n.opts.Host, fmt.Sprint(n.opts.Port)))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, n.opts.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: n.opts.Host}); err != nil {
+			return err
+		}
+	}
+	if n.opts.Username != "" {
+		// PlainAuth refuses to send credentials over an unencrypted connection.
+		if err := client.Auth(smtp.PlainAuth("", n.opts.Username, n.opts.Password, n.opts.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(n.opts.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(n.format(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (n *SMTPNotifier) format(msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", n.opts.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
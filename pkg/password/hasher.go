@@ -0,0 +1,165 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+
+	saltLength = 16
+	keyLength  = 32
+
+	// bcryptMaxBytes is the longest password bcrypt accepts.
+	bcryptMaxBytes = 72
+)
+
+var (
+	ErrMismatch      = errors.New("password does not match")
+	ErrUnknownFormat = errors.New("unknown password hash format")
+)
+
+// Params selects the algorithm used for new hashes and its cost. Hashes made
+// with other algorithms or costs still verify, NeedsRehash reports them.
+type Params struct {
+	Algorithm         string
+	BcryptCost        int
+	Argon2Memory      uint32 // KiB
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+}
+
+type Hasher struct {
+	params Params
+}
+
+func NewHasher(params Params) (*Hasher, error) {
+	switch params.Algorithm {
+	case Argon2id:
+		if params.Argon2Memory == 0 || params.Argon2Iterations == 0 || params.Argon2Parallelism == 0 {
+			return nil, errors.New("argon2id memory, iterations and parallelism must be positive")
+		}
+	case Bcrypt:
+		if params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return nil, fmt.Errorf("unknown password algorithm %q", params.Algorithm)
+	}
+	return &Hasher{params: params}, nil
+}
+
+// MaxBytes is the length in bytes of the longest password the algorithm
+// hashes, 0 when there is no limit. Policy.MaxBytes should not exceed it.
+func (h *Hasher) MaxBytes() int {
+	if h.params.Algorithm == Bcrypt {
+		return bcryptMaxBytes
+	}
+	return 0
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.params.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.params.BcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := argon2Params{memory: h.params.Argon2Memory, iterations: h.params.Argon2Iterations, parallelism: h.params.Argon2Parallelism}
+	key := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, keyLength)
+	return p.encode(salt, key), nil
+}
+
+// Verify returns nil when password matches hash and ErrMismatch when it does not.
+func (h *Hasher) Verify(hash, password string) error {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		p, salt, key, err := decodeArgon2(hash)
+		if err != nil {
+			return err
+		}
+		other := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return ErrMismatch
+		}
+		return nil
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	switch {
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return ErrMismatch
+	case err != nil:
+		return fmt.Errorf("%w: %v", ErrUnknownFormat, err)
+	}
+	return nil
+}
+
+// NeedsRehash reports whether hash was made with other settings than the
+// current ones. Callers rehash the plain text password after a successful
+// Verify, which is the only time it is known.
+func (h *Hasher) NeedsRehash(hash string) bool {
+	if h.params.Algorithm == Bcrypt {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != h.params.BcryptCost
+	}
+
+	p, _, _, err := decodeArgon2(hash)
+	return err != nil ||
+		p.memory != h.params.Argon2Memory ||
+		p.iterations != h.params.Argon2Iterations ||
+		p.parallelism != h.params.Argon2Parallelism
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+// encode writes the PHC string format also used by the reference implementation:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func (p argon2Params) encode(salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.iterations, p.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+func decodeArgon2(hash string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return p, nil, nil, ErrUnknownFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	return p, salt, key, nil
+}
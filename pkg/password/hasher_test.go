@@ -0,0 +1,91 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	argon2Test = Params{Algorithm: Argon2id, Argon2Memory: 64, Argon2Iterations: 1, Argon2Parallelism: 1}
+	bcryptTest = Params{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}
+)
+
+func TestHasher(t *testing.T) {
+	for _, params := range []Params{argon2Test, bcryptTest} {
+		t.Run(params.Algorithm, func(t *testing.T) {
+			h, err := NewHasher(params)
+			if err != nil {
+				t.Fatalf("NewHasher() error = %v", err)
+			}
+			hash, err := h.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash() error = %v", err)
+			}
+			if err := h.Verify(hash, "correct horse"); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+			if err := h.Verify(hash, "wrong horse"); !errors.Is(err, ErrMismatch) {
+				t.Errorf("Verify() of a wrong password error = %v, want %v", err, ErrMismatch)
+			}
+			if h.NeedsRehash(hash) {
+				t.Errorf("NeedsRehash() = true for a hash with the current settings")
+			}
+		})
+	}
+}
+
+func TestHasherRehash(t *testing.T) {
+	bcryptHasher, _ := NewHasher(bcryptTest)
+	argon2Hasher, _ := NewHasher(argon2Test)
+	stronger := argon2Test
+	stronger.Argon2Iterations++
+	strongerHasher, _ := NewHasher(stronger)
+
+	old, err := bcryptHasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hashes of another algorithm still verify.
+	if err := argon2Hasher.Verify(old, "correct horse"); err != nil {
+		t.Errorf("Verify() of a bcrypt hash error = %v", err)
+	}
+	if !argon2Hasher.NeedsRehash(old) {
+		t.Errorf("NeedsRehash() = false for a bcrypt hash")
+	}
+
+	weaker, err := argon2Hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strongerHasher.NeedsRehash(weaker) {
+		t.Errorf("NeedsRehash() = false for a hash with fewer iterations")
+	}
+	if err := strongerHasher.Verify(weaker, "correct horse"); err != nil {
+		t.Errorf("Verify() of a hash with other settings error = %v", err)
+	}
+}
+
+func TestNewHasher(t *testing.T) {
+	for _, params := range []Params{
+		{Algorithm: "md5"},
+		{Algorithm: Bcrypt, BcryptCost: bcrypt.MaxCost + 1},
+		{Algorithm: Argon2id, Argon2Memory: 64},
+	} {
+		if _, err := NewHasher(params); err == nil {
+			t.Errorf("NewHasher(%+v) error = nil", params)
+		}
+	}
+}
+
+func TestHasherMaxBytes(t *testing.T) {
+	h, _ := NewHasher(bcryptTest)
+	if _, err := h.Hash(strings.Repeat("a", h.MaxBytes())); err != nil {
+		t.Errorf("Hash() of %d bytes error = %v", h.MaxBytes(), err)
+	}
+	if _, err := h.Hash(strings.Repeat("a", h.MaxBytes()+1)); err == nil {
+		t.Errorf("Hash() of %d bytes error = nil", h.MaxBytes()+1)
+	}
+}
@@ -0,0 +1,136 @@
+package password
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var ErrPolicy = errors.New("password does not meet the policy")
+
+// commonPasswords is always blocked, on top of the configured blocklist.
+var commonPasswords = map[string]bool{
+	"123456789012": true, "password": true, "password1": true, "password123": true,
+	"passw0rd": true, "qwerty": true, "qwertyuiop": true, "qwerty123456": true,
+	"letmein": true, "welcome": true, "iloveyou": true, "admin": true,
+	"administrator": true, "changeme": true, "trustno1": true,
+}
+
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// MaxBytes bounds the UTF-8 length, which the hashing algorithm may
+	// limit, see Hasher.MaxBytes. 0 is no limit.
+	MaxBytes      int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	blocklist     map[string]bool
+}
+
+// PolicyError lists every rule a password breaks.
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrPolicy, strings.Join(e.Violations, ", "))
+}
+
+func (e *PolicyError) Is(target error) bool {
+	return target == ErrPolicy
+}
+
+// Block adds passwords that are rejected regardless of the other rules.
+// Entries are compared case-insensitively. The policy must not be modified
+// once it is used.
+func (p *Policy) Block(passwords ...string) {
+	if p.blocklist == nil {
+		p.blocklist = make(map[string]bool, len(passwords))
+	}
+	for _, password := range passwords {
+		if password = strings.TrimSpace(password); password != "" {
+			p.blocklist[strings.ToLower(password)] = true
+		}
+	}
+}
+
+// BlockFile adds the passwords of a file with one password per line.
+func (p *Policy) BlockFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		p.Block(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// Check validates password. Identifiers such as the email and username of the
+// account must not appear in it.
+func (p *Policy) Check(password string, identifiers ...string) error {
+	var violations []string
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("at least %d characters", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("at most %d characters", p.MaxLength))
+	} else if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		violations = append(violations, fmt.Sprintf("at most %d bytes", p.MaxBytes))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		violations = append(violations, "an upper case letter")
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, "a lower case letter")
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, "a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, "a symbol")
+	}
+
+	lowered := strings.ToLower(password)
+	if commonPasswords[lowered] || p.blocklist[lowered] {
+		violations = append(violations, "a less common password")
+	}
+	for _, id := range identifiers {
+		// Only the local part of an email address, the domain is often short.
+		id, _, _ = strings.Cut(strings.ToLower(id), "@")
+		if len(id) >= 3 && strings.Contains(lowered, id) {
+			violations = append(violations, "no email or username in it")
+			break
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	policy := Policy{MinLength: 8, MaxLength: 16, MaxBytes: 20, RequireUpper: true, RequireDigit: true}
+	policy.Block("Tr0ub4dor&3x")
+
+	tests := []struct {
+		name        string
+		password    string
+		identifiers []string
+		want        []string
+	}{
+		{name: "valid", password: "Correct horse 1"},
+		{name: "too short", password: "Short 1", want: []string{"at least 8 characters"}},
+		{name: "too long", password: "Correct horse battery 1", want: []string{"at most 16 characters"}},
+		{name: "too many bytes", password: "Ünïcödé ünïcödé1", want: []string{"at most 20 bytes"}},
+		{name: "missing classes", password: "correct horse", want: []string{"an upper case letter", "a digit"}},
+		{name: "common", password: "Password123", want: []string{"a less common password"}},
+		{name: "blocked", password: "tr0ub4dor&3X", want: []string{"a less common password"}},
+		{name: "username", password: "Bobby horse 1", identifiers: []string{"bobby"}, want: []string{"no email or username in it"}},
+		{name: "email", password: "Bobby horse 1", identifiers: []string{"Bobby@example.com"}, want: []string{"no email or username in it"}},
+		{name: "short identifier", password: "Bob horse 1", identifiers: []string{"bo"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.password, tt.identifiers...)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Check() error = %v", err)
+				}
+				return
+			}
+
+			var policyErr *PolicyError
+			if !errors.As(err, &policyErr) || !errors.Is(err, ErrPolicy) {
+				t.Fatalf("Check() error = %v, want a %T", err, policyErr)
+			}
+			if !reflect.DeepEqual(policyErr.Violations, tt.want) {
+				t.Errorf("Check() violations = %q, want %q", policyErr.Violations, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyBlockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte("Hunter2hunter2\n\n  summer2024!  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var policy Policy
+	if err := policy.BlockFile(path); err != nil {
+		t.Fatalf("BlockFile() error = %v", err)
+	}
+	for _, password := range []string{"hunter2HUNTER2", "Summer2024!"} {
+		if err := policy.Check(password); !errors.Is(err, ErrPolicy) {
+			t.Errorf("Check(%q) error = %v, want %v", password, err, ErrPolicy)
+		}
+	}
+	if err := policy.Check(strings.Repeat(" ", 3)); err != nil {
+		t.Errorf("Check() of a password matching a blank line error = %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+// Package validate checks request payloads against rules declared in
+// struct tags:
+//
+//	Email    string `json:"email" validate:"required,email,max=254"`
+//	Username string `json:"username" validate:"required,username"`
+//	Password string `json:"password" validate:"required_on_create"`
+//
+// Fields are reported by their json name. Only string fields are checked,
+// embedded structs are walked.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Mode selects which fields are required.
+type Mode int
+
+const (
+	// Create requires the fields tagged required and required_on_create.
+	Create Mode = iota
+	// Replace requires the fields tagged required.
+	Replace
+)
+
+const (
+	usernameMin = 3
+	usernameMax = 32
+)
+
+// Violation is one broken rule of one field.
+type Violation struct {
+	Field   string
+	Rule    string
+	Message string
+}
+
+// Struct checks v, a struct or a pointer to one, and returns every
+// violation. Rules other than the required ones only apply to non-empty
+// fields.
+func Struct(v any, mode Mode) []Violation {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validate: %T is not a struct", v))
+	}
+	var violations []Violation
+	walk(value, mode, &violations)
+	return violations
+}
+
+func walk(value reflect.Value, mode Mode, violations *[]Violation) {
+	for i := range value.NumField() {
+		field := value.Type().Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			walk(value.Field(i), mode, violations)
+			continue
+		}
+		tag, ok := field.Tag.Lookup("validate")
+		if !ok || field.Type.Kind() != reflect.String {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = field.Name
+		}
+		if v, ok := check(name, value.Field(i).String(), tag, mode); !ok {
+			*violations = append(*violations, v)
+		}
+	}
+}
+
+// check returns the first rule of tag that s breaks.
+func check(name, s, tag string, mode Mode) (Violation, bool) {
+	rules := strings.Split(tag, ",")
+	if s == "" {
+		for _, rule := range rules {
+			if rule == "required" || rule == "required_on_create" && mode == Create {
+				return Violation{name, "required", "is required"}, false
+			}
+		}
+		return Violation{}, true
+	}
+
+	for _, rule := range rules {
+		rule, arg, _ := strings.Cut(rule, "=")
+		switch rule {
+		case "required", "required_on_create":
+		case "email":
+			if !isEmail(s) {
+				return Violation{name, rule, "must be a valid email address"}, false
+			}
+		case "username":
+			if n := utf8.RuneCountInString(s); n < usernameMin || n > usernameMax {
+				return Violation{name, rule, fmt.Sprintf("must be %d to %d characters long", usernameMin, usernameMax)}, false
+			}
+			if !isUsername(s) {
+				return Violation{name, rule, "may only contain letters, digits, '.', '_' and '-'"}, false
+			}
+		case "min", "max":
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				panic(fmt.Sprintf("validate: invalid %s rule on %s", rule, name))
+			}
+			if length := utf8.RuneCountInString(s); rule == "min" && length < n {
+				return Violation{name, rule, fmt.Sprintf("must be at least %d characters long", n)}, false
+			} else if rule == "max" && length > n {
+				return Violation{name, rule, fmt.Sprintf("must be at most %d characters long", n)}, false
+			}
+		default:
+			panic(fmt.Sprintf("validate: unknown rule %q on %s", rule, name))
+		}
+	}
+	return Violation{}, true
+}
+
+// isEmail accepts a bare RFC 5322 address, without a display name or
+// angle brackets.
+func isEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Name == "" && addr.Address == s
+}
+
+// isUsername allows ASCII letters, digits, '.', '_' and '-'. Usernames can
+// never contain '@', which is what tells them from emails at login.
+func isUsername(s string) bool {
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package validate
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type account struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Username string `json:"username,omitempty" validate:"required,username"`
+	Password string `json:"password" validate:"required_on_create,min=8"`
+}
+
+type profile struct {
+	account
+	Nickname string `validate:"max=5"`
+	Age      int    `json:"age" validate:"required"`
+	Note     string `json:"note"`
+}
+
+func TestStruct(t *testing.T) {
+	valid := account{Email: "bob@example.com", Username: "bob", Password: "correct horse"}
+
+	tests := []struct {
+		name string
+		v    any
+		mode Mode
+		want []Violation
+	}{
+		{
+			name: "valid",
+			v:    valid,
+		},
+		{
+			name: "pointer",
+			v:    &valid,
+		},
+		{
+			name: "required on create",
+			v:    account{},
+			mode: Create,
+			want: []Violation{
+				{"email", "required", "is required"},
+				{"username", "required", "is required"},
+				{"password", "required", "is required"},
+			},
+		},
+		{
+			name: "password optional on replace",
+			v:    account{Email: "bob@example.com", Username: "bob"},
+			mode: Replace,
+		},
+		{
+			name: "rules skip empty fields",
+			v:    profile{account: valid},
+		},
+		{
+			name: "invalid email",
+			v:    account{Email: "Bob <bob@example.com>", Username: "bob", Password: "correct horse"},
+			want: []Violation{{"email", "email", "must be a valid email address"}},
+		},
+		{
+			name: "email too long",
+			v:    account{Email: strings.Repeat("a", 250) + "@b.cd", Username: "bob", Password: "correct horse"},
+			want: []Violation{{"email", "max", "must be at most 254 characters long"}},
+		},
+		{
+			name: "username too short",
+			v:    account{Email: "bob@example.com", Username: "bo", Password: "correct horse"},
+			want: []Violation{{"username", "username", "must be 3 to 32 characters long"}},
+		},
+		{
+			name: "username too long",
+			v:    account{Email: "bob@example.com", Username: strings.Repeat("b", 33), Password: "correct horse"},
+			want: []Violation{{"username", "username", "must be 3 to 32 characters long"}},
+		},
+		{
+			name: "username with @",
+			v:    account{Email: "bob@example.com", Username: "bob@home", Password: "correct horse"},
+			want: []Violation{{"username", "username", "may only contain letters, digits, '.', '_' and '-'"}},
+		},
+		{
+			name: "username punctuation",
+			v:    account{Email: "bob@example.com", Username: "b.o_b-1", Password: "correct horse"},
+		},
+		{
+			name: "min counts characters",
+			v:    account{Email: "bob@example.com", Username: "bob", Password: "ééééééé"},
+			want: []Violation{{"password", "min", "must be at least 8 characters long"}},
+		},
+		{
+			name: "embedded struct and field name",
+			v:    profile{account: account{Email: "x"}, Nickname: "bobbyb", Age: 0},
+			mode: Replace,
+			want: []Violation{
+				{"email", "email", "must be a valid email address"},
+				{"username", "required", "is required"},
+				{"Nickname", "max", "must be at most 5 characters long"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Struct(tt.v, tt.mode); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Struct() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStructPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+	}{
+		{"not a struct", "bob"},
+		{"unknown rule", struct {
+			Name string `validate:"shiny"`
+		}{Name: "bob"}},
+		{"invalid max", struct {
+			Name string `validate:"max=many"`
+		}{Name: "bob"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Struct() did not panic")
+				}
+			}()
+			Struct(tt.v, Create)
+		})
+	}
+}